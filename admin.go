@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	// statusPath is the path of the admin status endpoint.
+	statusPath = "/status"
+
+	// filtersPath is the path of the admin endpoint which lists the registered filters.
+	filtersPath = "/filters"
+)
+
+// pruneStatus is the time and counts of a completed prune.
+type pruneStatus struct {
+	Time       time.Time `json:"time"`
+	Containers int64     `json:"containers"`
+	Networks   int64     `json:"networks"`
+	Volumes    int64     `json:"volumes"`
+	Images     int64     `json:"images"`
+}
+
+// status is the JSON response of the status endpoint.
+type status struct {
+	// Clients is the number of connected clients.
+	Clients int64 `json:"clients"`
+
+	// Filters is the number of registered filters.
+	Filters int `json:"filters"`
+
+	// Shutdown is set once shutdown has started.
+	Shutdown bool `json:"shutdown"`
+
+	// LastPrune is the last completed prune, if any.
+	LastPrune *pruneStatus `json:"last_prune"`
+}
+
+// listenAdmin starts listening for admin requests if an admin port is configured.
+func (r *reaper) listenAdmin() error {
+	if r.cfg.AdminPort == 0 {
+		return nil
+	}
+
+	var err error
+	if r.adminListener, err = net.Listen("tcp", net.JoinHostPort(r.cfg.BindAddr, strconv.Itoa(int(r.cfg.AdminPort)))); err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+
+	return nil
+}
+
+// adminMux returns the handler of the admin endpoints, which expose
+// details of the filters and resources so aren't served with health.
+func (r *reaper) adminMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc(statusPath, r.handleStatus)
+	mux.HandleFunc(filtersPath, r.handleFilters)
+	mux.HandleFunc(decisionsPath, r.handleDecisions)
+
+	return mux
+}
+
+// serveAdmin serves admin requests until ctx is done.
+func (r *reaper) serveAdmin(ctx context.Context) {
+	srv := &http.Server{
+		Handler:           r.adminMux(),
+		ReadHeaderTimeout: r.cfg.RequestTimeout,
+	}
+
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	r.logger.Info("admin endpoint started", fieldAddress, r.adminListener.Addr().String())
+	if err := srv.Serve(r.adminListener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		r.logger.Error("admin serve", fieldError, err)
+	}
+}
+
+// recordPrune records counts as the last prune for the status endpoint.
+func (r *reaper) recordPrune(counts *removeCounts) {
+	r.statusMtx.Lock()
+	defer r.statusMtx.Unlock()
+
+	r.lastPrune = &pruneStatus{
+		Time:       time.Now(),
+		Containers: counts.containers.Load(),
+		Networks:   counts.networks.Load(),
+		Volumes:    counts.volumes.Load(),
+		Images:     counts.images.Load(),
+	}
+}
+
+// handleStatus returns the live status of the reaper as JSON.
+func (r *reaper) handleStatus(w http.ResponseWriter, _ *http.Request) {
+	s := status{
+		Clients: r.clients.Load(),
+		Filters: len(r.filterArgs()),
+	}
+
+	select {
+	case <-r.shutdown:
+		s.Shutdown = true
+	default:
+	}
+
+	r.statusMtx.Lock()
+	s.LastPrune = r.lastPrune
+	r.statusMtx.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		r.logger.Error("status write", fieldError, err)
+	}
+}
+
+// handleFilters returns the keys of the registered filters as a sorted
+// JSON array, to help debug why resources aren't being removed.
+func (r *reaper) handleFilters(w http.ResponseWriter, _ *http.Request) {
+	args := r.filterArgs()
+	keys := make([]string, 0, len(args))
+	for _, a := range args {
+		keys = append(keys, filterKey(a))
+	}
+	slices.Sort(keys)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(keys); err != nil {
+		r.logger.Error("filters write", fieldError, err)
+	}
+}
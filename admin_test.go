@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testStatus requests the status endpoint of srv and returns the decoded status.
+func testStatus(t *testing.T, srv *httptest.Server) status {
+	t.Helper()
+
+	resp, err := srv.Client().Get(srv.URL + statusPath)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	var s status
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&s))
+
+	return s
+}
+
+func TestStatusEndpoint(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	t.Cleanup(cancel)
+
+	r, err := newReaper(ctx, discardLogger, testConfig, withClient(newMockClient(newRunTest())))
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(http.HandlerFunc(r.handleStatus))
+	t.Cleanup(srv.Close)
+
+	require.Equal(t, status{}, testStatus(t, srv))
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- r.run(ctx)
+	}()
+
+	clientCtx, clientCancel := context.WithCancel(ctx)
+	testConnect(clientCtx, t, r.listener.Addr().String(), testLabels1)
+	require.Eventually(t, func() bool {
+		return testStatus(t, srv) == status{Clients: 1, Filters: 1}
+	}, time.Second, time.Millisecond*10)
+
+	// Disconnecting triggers the prune.
+	clientCancel()
+	select {
+	case err = <-errCh:
+		require.NoError(t, err)
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	s := testStatus(t, srv)
+	require.True(t, s.Shutdown)
+	require.Zero(t, s.Clients)
+	require.NotNil(t, s.LastPrune)
+	require.WithinDuration(t, time.Now(), s.LastPrune.Time, time.Second*5)
+	require.Equal(t, pruneStatus{Time: s.LastPrune.Time, Containers: 1, Networks: 1, Volumes: 1, Images: 1}, *s.LastPrune)
+}
+
+func TestFiltersEndpoint(t *testing.T) {
+	r, err := newReaper(context.Background(), discardLogger, testConfig, withClient(newMockClient(newRunTest())))
+	require.NoError(t, err)
+	t.Cleanup(func() { r.listener.Close() })
+
+	key1 := filterKey(filterArgs(testLabels1))
+	key2 := filterKey(filterArgs(testLabels2))
+	require.NoError(t, r.addFilter("client1", key1))
+	require.NoError(t, r.addFilter("client2", key2))
+
+	srv := httptest.NewServer(http.HandlerFunc(r.handleFilters))
+	t.Cleanup(srv.Close)
+
+	resp, err := srv.Client().Get(srv.URL + filtersPath)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	var keys []string
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&keys))
+	require.ElementsMatch(t, []string{key1, key2}, keys)
+}
+
+func TestAdminMux(t *testing.T) {
+	cfg := testConfigBase
+	cfg.DecisionLogSize = 10
+	r, err := newReaper(context.Background(), discardLogger, withConfig(cfg), withClient(newMockClient(newRunTest())))
+	require.NoError(t, err)
+	t.Cleanup(func() { r.listener.Close() })
+
+	// The filters and decisions are only served by the admin endpoint.
+	admin := httptest.NewServer(r.adminMux())
+	t.Cleanup(admin.Close)
+	health := httptest.NewServer(r.healthMux())
+	t.Cleanup(health.Close)
+
+	for _, path := range []string{statusPath, filtersPath, decisionsPath} {
+		for srv, want := range map[*httptest.Server]int{admin: http.StatusOK, health: http.StatusNotFound} {
+			resp, err := srv.Client().Get(srv.URL + path)
+			require.NoError(t, err)
+			resp.Body.Close()
+			require.Equal(t, want, resp.StatusCode, path)
+		}
+	}
+}
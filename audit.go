@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// auditFilterRegistered is the audit event recorded when a client registers a filter.
+const auditFilterRegistered = "filter_registered"
+
+// auditEvent is a structured record of an action taken on behalf of a client.
+type auditEvent struct {
+	// Time is when the event occurred.
+	Time time.Time `json:"time"`
+
+	// Event is the type of event.
+	Event string `json:"event"`
+
+	// Address is the address of the client, if any.
+	Address string `json:"address,omitempty"`
+
+	// Filters are the filter values by type, if any.
+	Filters map[string][]string `json:"filters,omitempty"`
+}
+
+// audit appends event to the configured audit file, if any, as a JSON line.
+// Failures are logged rather than returned so auditing can't block the reaper.
+// Safe to call concurrently.
+func (r *reaper) audit(event auditEvent) {
+	if r.cfg.AuditFile == "" {
+		return
+	}
+
+	if err := r.appendAudit(event); err != nil {
+		r.logger.Error("audit", fieldError, err, "event", event.Event, "file", r.cfg.AuditFile)
+	}
+}
+
+// appendAudit appends event to the audit file as a JSON line.
+func (r *reaper) appendAudit(event auditEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+
+	r.auditMtx.Lock()
+	defer r.auditMtx.Unlock()
+
+	f, err := os.OpenFile(r.cfg.AuditFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644) //nolint:gosec // Audit is intended to be read by other tools.
+	if err != nil {
+		return fmt.Errorf("open: %w", err)
+	}
+
+	if _, err = f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("write: %w", err)
+	}
+
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("close: %w", err)
+	}
+
+	return nil
+}
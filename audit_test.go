@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// readAudit returns the audit events written to file.
+func readAudit(t *testing.T, file string) []auditEvent {
+	t.Helper()
+
+	f, err := os.Open(file)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var events []auditEvent
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event auditEvent
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		events = append(events, event)
+	}
+	require.NoError(t, scanner.Err())
+
+	return events
+}
+
+func TestAuditFilterRegistered(t *testing.T) {
+	cfg := testConfigBase
+	cfg.AuditFile = filepath.Join(t.TempDir(), "audit.jsonl")
+	r := &reaper{
+		cfg:     &cfg,
+		filters: make(map[string]*filterEntry),
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	require.NoError(t, r.addFilter("client1", "label=a=1&label=b=2"))
+	require.NoError(t, r.addFilter("client2", "label=b=2&label=a=1"))
+	require.Error(t, r.addFilter("client3", "label=%zz"))
+
+	events := readAudit(t, cfg.AuditFile)
+	require.Len(t, events, 2)
+	for i, addr := range []string{"client1", "client2"} {
+		require.Equal(t, auditFilterRegistered, events[i].Event)
+		require.Equal(t, addr, events[i].Address)
+		require.ElementsMatch(t, []string{"a=1", "b=2"}, events[i].Filters["label"])
+		require.False(t, events[i].Time.IsZero())
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// batchAddr is the client address the batch filters are registered by.
+const batchAddr = "batch"
+
+// withBatch returns a reaperOption that sets the filters to reap once,
+// without listening for clients.
+// Default: none, clients register the filters.
+func withBatch(filters []string) reaperOption {
+	return func(r *reaper) error {
+		r.batch = filters
+		return nil
+	}
+}
+
+// addBatchFilters registers the batch filters, as if sent by a single client.
+func (r *reaper) addBatchFilters() error {
+	for _, filter := range r.batch {
+		if err := r.addFilter(batchAddr, filter); err != nil {
+			return fmt.Errorf("filter %q: %w", filter, err)
+		}
+	}
+
+	return nil
+}
+
+// reapOnce prunes the resources matching the batch filters once. Like a
+// manual prune, resources which changed are excluded and the rest pruned,
+// but nothing is pruned if the resources can't be determined.
+// As there are no clients to wait for, the prune is abandoned once ctx is
+// done or shutdown is forced.
+func (r *reaper) reapOnce(ctx context.Context) error {
+	if r.logFile != nil {
+		// Registered first so it's closed after the last log.
+		defer r.logFile.Close()
+	}
+
+	defer r.shutdownTracing()
+	defer r.logger.Info("done")
+	defer r.closeBuildkit()
+	defer r.webhooks.Wait()
+
+	r.logger.Info("reaping once", "filters", len(r.batch))
+
+	// The batch client is done with its filters, so with reference
+	// counting they aren't treated as still in use.
+	r.releaseFilters(batchAddr)
+
+	resources, err := r.resources(time.Now().Add(r.cfg.RetryOffset))
+	switch {
+	case err == nil:
+	case onlyChanges(err):
+		r.logger.Warn("reap resources", fieldError, err)
+	default:
+		return fmt.Errorf("resources: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-r.forced:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if err = r.prune(ctx, resources); err != nil {
+		return fmt.Errorf("prune: %w", err)
+	}
+
+	return nil
+}
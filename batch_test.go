@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReapOnce(t *testing.T) {
+	created := time.Now().Add(-time.Minute).Unix()
+	args := filterArgs(testLabels1)
+	cli := newListMockClient(map[*filters.Args][]types.Container{
+		&args: {{ID: containerID1, Created: created, Labels: testLabels1}},
+	})
+	cli.On("ContainerRemove", mockContext, containerID1, containerRemoveOptions).Return(nil)
+
+	var log safeBuffer
+	r, err := newReaper(context.Background(),
+		withConfig(testConfigBase),
+		withClient(cli),
+		withLogger(slog.New(slog.NewTextHandler(&log, nil))),
+		withBatch([]string{filterKey(args)}),
+	)
+	require.NoError(t, err)
+
+	// There are no listeners.
+	require.Nil(t, r.listener)
+	require.NotContains(t, log.String(), "msg=Started")
+
+	require.NoError(t, r.reapOnce(context.Background()))
+	cli.AssertCalled(t, "ContainerRemove", mockContext, containerID1, containerRemoveOptions)
+	require.Contains(t, log.String(), "msg=removed containers=1 networks=0 volumes=0 images=0")
+	require.Contains(t, log.String(), "msg=done")
+
+	t.Run("list-error", func(t *testing.T) {
+		// Nothing is pruned if the resources can't be determined,
+		// but the BuildKit client is still closed.
+		cli := newListMockClient(nil)
+		cli.On("ContainerList", mockContext, mock.Anything).Return([]types.Container(nil), errors.New("list error"))
+		bk := &mockBuildkitClient{}
+		bk.On("Close").Return(nil).Once()
+		r, err := newReaper(context.Background(),
+			discardLogger,
+			withConfig(testConfigBase),
+			withClient(cli),
+			withBuildkitClient(bk),
+			withBatch([]string{filterKey(args)}),
+		)
+		require.NoError(t, err)
+
+		require.ErrorContains(t, r.reapOnce(context.Background()), "list error")
+		cli.AssertNotCalled(t, "NetworkRemove", mock.Anything, mock.Anything)
+		bk.AssertExpectations(t)
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		// The prune is abandoned on the first signal.
+		cli := newListMockClient(map[*filters.Args][]types.Container{
+			&args: {{ID: containerID1, Created: created, Labels: testLabels1}},
+		})
+		r, err := newReaper(context.Background(),
+			discardLogger,
+			withConfig(testConfigBase),
+			withClient(cli),
+			withBatch([]string{filterKey(args)}),
+		)
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		require.ErrorContains(t, r.reapOnce(ctx), "container left 1 items")
+		cli.AssertNotCalled(t, "ContainerRemove", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("reference-counting", func(t *testing.T) {
+		// The batch filters aren't held by a client, so they're pruned.
+		cli := newListMockClient(map[*filters.Args][]types.Container{
+			&args: {{ID: containerID1, Created: created, Labels: testLabels1}},
+		})
+		cli.On("ContainerRemove", mockContext, containerID1, containerRemoveOptions).Return(nil)
+		cfg := testConfigBase
+		cfg.ReferenceCounting = true
+		r, err := newReaper(context.Background(),
+			discardLogger,
+			withConfig(cfg),
+			withClient(cli),
+			withBatch([]string{filterKey(args)}),
+		)
+		require.NoError(t, err)
+
+		require.NoError(t, r.reapOnce(context.Background()))
+		cli.AssertCalled(t, "ContainerRemove", mockContext, containerID1, containerRemoveOptions)
+	})
+
+	t.Run("invalid-filter", func(t *testing.T) {
+		_, err := newReaper(context.Background(),
+			discardLogger,
+			withConfig(testConfigBase),
+			withClient(newListMockClient(nil)),
+			withBatch([]string{"unknown=value"}),
+		)
+		require.ErrorContains(t, err, `batch: filter "unknown=value"`)
+	})
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// bindPathCommand is the prefix of the command used by clients to register
+// a host path to remove after the prune.
+const bindPathCommand = "BINDPATH="
+
+var (
+	// errBindPathDisabled is returned when a bind path is registered
+	// but no cleanup roots are configured.
+	errBindPathDisabled = errors.New("bind path cleanup disabled")
+
+	// errBindPathNotAllowed is returned when a bind path is not
+	// within one of the configured cleanup roots.
+	errBindPathNotAllowed = errors.New("bind path not within allowed roots")
+)
+
+// validateBindPath validates that path is an absolute path strictly within
+// one of roots and returns its cleaned form. If the parent of path exists
+// any symlinks are resolved, so links can't be used to escape the roots.
+func validateBindPath(roots []string, path string) (string, error) {
+	if len(roots) == 0 {
+		return "", errBindPathDisabled
+	}
+
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("bind path %q: not absolute", path)
+	}
+
+	path = filepath.Clean(path)
+	if !withinRoots(roots, path) {
+		return "", fmt.Errorf("bind path %q: %w", path, errBindPathNotAllowed)
+	}
+
+	parent, err := filepath.EvalSymlinks(filepath.Dir(path))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		// Nothing to resolve yet, the lexical check is sufficient.
+		return path, nil
+	case err != nil:
+		return "", fmt.Errorf("bind path %q: eval symlinks: %w", path, err)
+	}
+
+	resolved := filepath.Join(parent, filepath.Base(path))
+	if !withinRoots(roots, resolved) {
+		return "", fmt.Errorf("bind path %q: resolved to %q: %w", path, resolved, errBindPathNotAllowed)
+	}
+
+	return path, nil
+}
+
+// withinRoots returns true if path is strictly within one of roots.
+// Roots are compared both as configured and with symlinks resolved.
+func withinRoots(roots []string, path string) bool {
+	for _, root := range roots {
+		candidates := []string{filepath.Clean(root)}
+		if resolved, err := filepath.EvalSymlinks(root); err == nil {
+			candidates = append(candidates, resolved)
+		}
+
+		for _, candidate := range candidates {
+			rel, err := filepath.Rel(candidate, path)
+			if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				continue
+			}
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// addBindPath registers path, sent by the client at addr, to be removed
+// once the filters of the client are pruned.
+// Safe to call concurrently.
+func (r *reaper) addBindPath(addr, path string) error {
+	path, err := validateBindPath(r.cfg.BindCleanupRoots, path)
+	if err != nil {
+		return err
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.logger.Info("adding bind path", "path", path, fieldAddress, addr)
+	r.bindPaths[path] = addr
+
+	return nil
+}
+
+// bindPathReleased returns true if the bind paths of the client at addr can
+// be removed, as shutdown has started or the client no longer holds any
+// filters, so the resources using them are being pruned.
+// Safe to call concurrently.
+func (r *reaper) bindPathReleased(addr string) bool {
+	select {
+	case <-r.shutdown:
+		return true
+	default:
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	for _, entry := range r.filters {
+		if _, ok := entry.clients[addr]; ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// removeBindPaths removes the bind paths registered by the clients for
+// which released returns true from the host. Each path is forgotten once
+// removed, so isn't removed again if it's recreated.
+// Paths are validated again before removal as the filesystem may have
+// changed since they were registered.
+func (r *reaper) removeBindPaths(released func(addr string) bool) error {
+	r.mtx.Lock()
+	registered := maps.Clone(r.bindPaths)
+	r.mtx.Unlock()
+
+	paths := make(map[string]string, len(registered))
+	for path, addr := range registered {
+		if released(addr) {
+			paths[path] = addr
+		}
+	}
+
+	if len(paths) == 0 {
+		return nil
+	}
+
+	var removed int
+	var errs []error
+	for path, addr := range paths {
+		logger := r.logger.With("path", path, fieldAddress, addr)
+		if _, err := validateBindPath(r.cfg.BindCleanupRoots, path); err != nil {
+			logger.Error("validate bind path", fieldError, err)
+			errs = append(errs, err)
+			continue
+		}
+
+		logger.Debug("remove bind path")
+		if err := os.RemoveAll(path); err != nil {
+			logger.Error("remove bind path", fieldError, err)
+			errs = append(errs, fmt.Errorf("remove all: %w", err))
+			continue
+		}
+
+		r.forgetBindPath(path, addr)
+		removed++
+	}
+
+	r.logger.Info("removed bind paths", "paths", removed)
+
+	return errors.Join(errs...)
+}
+
+// forgetBindPath forgets path once removed, unless it was registered
+// again by another client since.
+// Safe to call concurrently.
+func (r *reaper) forgetBindPath(path, addr string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.bindPaths[path] == addr {
+		delete(r.bindPaths, path)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_validateBindPath(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(root, "dir"), 0o755))
+	require.NoError(t, os.Symlink(outside, filepath.Join(root, "escape")))
+	require.NoError(t, os.Symlink(filepath.Join(root, "dir"), filepath.Join(root, "inside")))
+	roots := []string{root}
+
+	tests := map[string]struct {
+		roots    []string
+		path     string
+		expected string
+		err      error
+	}{
+		"within-root": {
+			path:     filepath.Join(root, "dir"),
+			expected: filepath.Join(root, "dir"),
+		},
+		"nested-not-exist": {
+			path:     filepath.Join(root, "missing", "nested"),
+			expected: filepath.Join(root, "missing", "nested"),
+		},
+		"cleaned": {
+			path:     root + "/dir/../dir/./file",
+			expected: filepath.Join(root, "dir", "file"),
+		},
+		"symlink-within-root": {
+			path:     filepath.Join(root, "inside", "file"),
+			expected: filepath.Join(root, "inside", "file"),
+		},
+		"disabled": {
+			roots: []string{},
+			path:  filepath.Join(root, "dir"),
+			err:   errBindPathDisabled,
+		},
+		"root-itself": {
+			path: root,
+			err:  errBindPathNotAllowed,
+		},
+		"root-trailing-slash": {
+			path: root + "/",
+			err:  errBindPathNotAllowed,
+		},
+		"outside-root": {
+			path: filepath.Join(outside, "file"),
+			err:  errBindPathNotAllowed,
+		},
+		"parent-traversal": {
+			path: filepath.Join(root, "..", filepath.Base(outside)),
+			err:  errBindPathNotAllowed,
+		},
+		"traversal-after-clean": {
+			path: root + "/dir/../../etc",
+			err:  errBindPathNotAllowed,
+		},
+		"root-prefix": {
+			path: root + "-other/file",
+			err:  errBindPathNotAllowed,
+		},
+		"symlink-escape": {
+			path: filepath.Join(root, "escape", "file"),
+			err:  errBindPathNotAllowed,
+		},
+		"filesystem-root": {
+			path: "/",
+			err:  errBindPathNotAllowed,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if tc.roots == nil {
+				tc.roots = roots
+			}
+
+			path, err := validateBindPath(tc.roots, tc.path)
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err)
+				require.Empty(t, path)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, path)
+		})
+	}
+
+	t.Run("relative", func(t *testing.T) {
+		_, err := validateBindPath(roots, "dir/file")
+		require.ErrorContains(t, err, "not absolute")
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		_, err := validateBindPath(roots, "")
+		require.ErrorContains(t, err, "not absolute")
+	})
+}
+
+func TestBindPathCleanup(t *testing.T) {
+	root := t.TempDir()
+	remove := filepath.Join(root, "remove")
+	require.NoError(t, os.MkdirAll(filepath.Join(remove, "nested"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(remove, "nested", "file"), []byte("data"), 0o600))
+	keep := filepath.Join(root, "keep")
+	require.NoError(t, os.Mkdir(keep, 0o755))
+
+	cfg := testConfigBase
+	cfg.BindCleanupRoots = []string{root}
+	r := &reaper{
+		cfg:       &cfg,
+		filters:   make(map[string]*filterEntry),
+		bindPaths: make(map[string]string),
+		shutdown:  make(chan struct{}),
+		logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	require.NoError(t, r.addBindPath("client1", remove))
+	require.ErrorIs(t, r.addBindPath("client1", t.TempDir()), errBindPathNotAllowed)
+	require.NoError(t, r.removeBindPaths(r.bindPathReleased))
+	require.NoDirExists(t, remove)
+	require.DirExists(t, keep)
+
+	// Removed paths are forgotten, so aren't removed if recreated.
+	require.NoError(t, os.Mkdir(remove, 0o755))
+	require.NoError(t, r.removeBindPaths(r.bindPathReleased))
+	require.DirExists(t, remove)
+
+	t.Run("connected", func(t *testing.T) {
+		// The paths of clients still holding filters are kept until
+		// the clients are released.
+		held := filepath.Join(root, "held")
+		require.NoError(t, os.Mkdir(held, 0o755))
+		require.NoError(t, r.addFilter("client2", "label=held=true"))
+		require.NoError(t, r.addBindPath("client2", held))
+
+		require.NoError(t, r.removeBindPaths(r.bindPathReleased))
+		require.DirExists(t, held)
+
+		r.releaseFilters("client2")
+		require.NoError(t, r.removeBindPaths(r.bindPathReleased))
+		require.NoDirExists(t, held)
+		require.Empty(t, r.bindPaths)
+	})
+
+	t.Run("command", func(t *testing.T) {
+		remove := filepath.Join(root, "command")
+		require.NoError(t, os.Mkdir(remove, 0o755))
+
+		cfg := testConfigBase
+		cfg.BindCleanupRoots = []string{root}
+		tc := newRunTest()
+		tc.connect = func(ctx context.Context, t *testing.T, addr string) {
+			t.Helper()
+			require.Equal(t, "ACK\n", testSend(ctx, t, addr, bindPathCommand+remove))
+		}
+		log, err := testReaperRun(t, tc, withConfig(cfg))
+		require.NoError(t, err)
+		require.Contains(t, log, `msg="removed bind paths" paths=1`)
+		require.NoDirExists(t, remove)
+	})
+
+	t.Run("command-rejected", func(t *testing.T) {
+		cfg := testConfigBase
+		cfg.BindCleanupRoots = []string{root}
+		tc := newRunTest()
+		tc.connect = func(ctx context.Context, t *testing.T, addr string) {
+			t.Helper()
+			require.Equal(t, "NACK\n", testSend(ctx, t, addr, bindPathCommand+t.TempDir()))
+		}
+		log, err := testReaperRun(t, tc, withConfig(cfg))
+		require.NoError(t, err)
+		require.Contains(t, log, `msg="add bind path"`)
+	})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	bkclient "github.com/moby/buildkit/client"
+)
+
+// withBuildkitClient returns a reaperOption that sets the BuildKit client.
+// Default: A BuildKit client connected to BuildkitAddr if configured.
+func withBuildkitClient(client buildkitClient) reaperOption {
+	return func(r *reaper) error {
+		r.buildkit = client
+		return nil
+	}
+}
+
+// closeBuildkit closes the BuildKit client, if any.
+func (r *reaper) closeBuildkit() {
+	if r.buildkit == nil {
+		return
+	}
+
+	if err := r.buildkit.Close(); err != nil {
+		r.logger.Warn("buildkit close", fieldError, err)
+	}
+}
+
+// sessionIDs returns the unique session IDs referenced by label filters
+// of the registered filters.
+func (r *reaper) sessionIDs() []string {
+	label := r.cfg.sessionIDLabel()
+	seen := make(map[string]struct{})
+	var ids []string
+	for _, args := range r.filterArgs() {
+		for _, value := range args.Get("label") {
+			key, id, ok := strings.Cut(value, "=")
+			if !ok || key != label || id == "" {
+				continue
+			}
+
+			if _, ok := seen[id]; ok {
+				continue
+			}
+
+			seen[id] = struct{}{}
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+// pruneBuildCache prunes the Docker daemon's build cache which hasn't been
+// used since until and returns the bytes freed. Build cache has no labels,
+// so unlike other resources it can't be limited to the registered filters.
+func (r *reaper) pruneBuildCache(until time.Time) (uint64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.cfg.RequestTimeout)
+	defer cancel()
+
+	report, err := r.docker().BuildCachePrune(ctx, types.BuildCachePruneOptions{
+		Filters: filters.NewArgs(filters.Arg("until", strconv.FormatInt(until.Unix(), 10))),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("build cache prune: %w", err)
+	}
+
+	for _, id := range report.CachesDeleted {
+		r.logger.Debug("build cache record pruned", "id", id)
+	}
+
+	return report.SpaceReclaimed, nil
+}
+
+// pruneBuildkit prunes BuildKit build cache records whose description
+// contains the session ID of a registered filter.
+// Errors are logged but not returned, as BuildKit is optional and may
+// not be reachable.
+func (r *reaper) pruneBuildkit() {
+	if r.buildkit == nil {
+		return
+	}
+
+	var records int
+	var size int64
+	for _, id := range r.sessionIDs() {
+		logger := r.logger.With("session", id)
+		ch := make(chan bkclient.UsageInfo)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for info := range ch {
+				logger.Debug("buildkit record pruned", "id", info.ID, "size", info.Size)
+				records++
+				size += info.Size
+			}
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), r.cfg.RequestTimeout)
+		err := r.buildkit.Prune(ctx, ch, bkclient.WithFilter([]string{"description~=" + id}))
+		cancel()
+		close(ch)
+		<-done
+
+		if err != nil {
+			logger.Warn("buildkit prune", fieldError, err)
+		}
+	}
+
+	r.logger.Info("buildkit pruned", "records", records, "size", size)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// errDaemonUnavailable is returned when removals are aborted because the
+// Docker daemon didn't respond to the probe after the circuit opened.
+var errDaemonUnavailable = errors.New("daemon unavailable")
+
+// circuitBreaker tracks consecutive removal failures during a prune pass,
+// so removals stop once the daemon appears to be unavailable.
+type circuitBreaker struct {
+	// failures is the number of consecutive removal failures.
+	failures int
+
+	// err is set once the daemon is unavailable, aborting the pass.
+	err error
+
+	// probe is closed once the probe in progress completes, nil if
+	// the daemon isn't being probed.
+	probe chan struct{}
+
+	mtx sync.Mutex
+}
+
+// reset resets the circuit for a new prune pass.
+func (c *circuitBreaker) reset() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.failures = 0
+	c.err = nil
+}
+
+// aborted returns the error the pass was aborted with, if any.
+func (c *circuitBreaker) aborted() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.err
+}
+
+// record records the result of a removal. Any failure, other than
+// removals being aborted, is counted and success closes the circuit.
+func (c *circuitBreaker) record(err error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	switch {
+	case err == nil:
+		c.failures = 0
+	case !errors.Is(err, errDaemonUnavailable):
+		c.failures++
+	}
+}
+
+// circuitWait returns nil if removals can continue. Once the configured number
+// of consecutive removal failures is reached, it waits for the cooldown then
+// probes the daemon with a ping. If the probe succeeds removals resume,
+// otherwise an error wrapping errDaemonUnavailable is returned and the rest
+// of the pass is aborted. Concurrent callers wait for the probe, and any
+// caller stops waiting if ctx is done.
+func (r *reaper) circuitWait(ctx context.Context) error {
+	if r.cfg.CircuitThreshold <= 0 {
+		return nil
+	}
+
+	c := &r.circuit
+	for {
+		c.mtx.Lock()
+		if c.err != nil || c.failures < r.cfg.CircuitThreshold {
+			err := c.err
+			c.mtx.Unlock()
+			return err
+		}
+
+		probe := c.probe
+		if probe == nil {
+			c.probe = make(chan struct{})
+			failures := c.failures
+			c.mtx.Unlock()
+			return r.probeCircuit(ctx, failures)
+		}
+		c.mtx.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err() //nolint:wrapcheck // Nothing to add.
+		case <-probe:
+		}
+	}
+}
+
+// probeCircuit waits for the cooldown then probes the daemon, without
+// holding the circuit's lock so other callers aren't blocked.
+func (r *reaper) probeCircuit(ctx context.Context, failures int) error {
+	c := &r.circuit
+	r.logger.Warn("circuit open, waiting to probe daemon", "failures", failures, "cooldown", r.cfg.CircuitCooldown)
+	err := sleepContext(ctx, r.cfg.CircuitCooldown)
+	if err == nil {
+		err = r.ping(ctx, r.docker())
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	close(c.probe)
+	c.probe = nil
+
+	switch {
+	case ctx.Err() != nil:
+		// Cancelled, so the daemon's availability is unknown.
+		return ctx.Err() //nolint:wrapcheck // Nothing to add.
+	case err != nil:
+		c.err = fmt.Errorf("%w: %w", errDaemonUnavailable, err)
+		r.logger.Error("circuit probe failed, aborting removals", fieldError, err)
+		return c.err
+	}
+
+	r.logger.Info("circuit probe succeeded, resuming removals")
+	c.failures = 0
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var delays []time.Duration
+	orig := sleepContext
+	t.Cleanup(func() { sleepContext = orig })
+	sleepContext = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return ctx.Err()
+	}
+
+	ids := make([]string, 10)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("container%d", i)
+	}
+
+	newTestReaper := func(t *testing.T, pingErr error) (*reaper, *safeBuffer) {
+		t.Helper()
+
+		delays = nil
+		cli := &mockClient{}
+		cli.On("Ping", mockContext).Return(types.Ping{}, pingErr)
+
+		var log safeBuffer
+		cfg := testConfigBase
+		cfg.RemoveRetries = 10
+		cfg.CircuitThreshold = 3
+		cfg.CircuitCooldown = time.Second * 5
+		return &reaper{
+			cfg:    &cfg,
+			client: cli,
+			logger: slog.New(slog.NewTextHandler(&log, nil)),
+		}, &log
+	}
+
+	t.Run("unavailable", func(t *testing.T) {
+		r, log := newTestReaper(t, errors.New("connection refused"))
+
+		var calls, count atomic.Int64
+		fail := func(context.Context, string) error {
+			calls.Add(1)
+			return errors.New("connection refused")
+		}
+		err := r.remove(context.Background(), "container", ids, &count, fail)
+		var rerr *removeError
+		require.ErrorAs(t, err, &rerr)
+		require.Len(t, rerr.left, len(ids))
+		for _, id := range ids[:3] {
+			require.EqualError(t, rerr.left[id], "connection refused")
+		}
+		for _, id := range ids[3:] {
+			require.ErrorIs(t, rerr.left[id], errDaemonUnavailable)
+		}
+
+		// Removals stop at the threshold, after a single probe.
+		require.Equal(t, int64(3), calls.Load())
+		require.Equal(t, []time.Duration{time.Second * 5}, delays)
+		require.Contains(t, log.String(), `level=ERROR msg="circuit probe failed, aborting removals"`)
+
+		// The rest of the pass is aborted without further attempts.
+		err = r.remove(context.Background(), "network", []string{networkID1}, &count, fail)
+		require.ErrorAs(t, err, &rerr)
+		require.ErrorIs(t, rerr.left[networkID1], errDaemonUnavailable)
+		require.Equal(t, int64(3), calls.Load())
+		require.Zero(t, count.Load())
+
+		// A new pass starts with the circuit closed.
+		r.circuit.reset()
+		require.NoError(t, r.circuit.aborted())
+	})
+
+	t.Run("recovered", func(t *testing.T) {
+		r, log := newTestReaper(t, nil)
+
+		var calls, count atomic.Int64
+		err := r.remove(context.Background(), "container", ids, &count, func(context.Context, string) error {
+			if calls.Add(1) <= 3 {
+				return errors.New("timeout")
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, int64(len(ids)), count.Load())
+		require.Contains(t, delays, time.Second*5)
+		require.Contains(t, log.String(), `msg="circuit probe succeeded, resuming removals"`)
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		r, log := newTestReaper(t, nil)
+		r.circuit.failures = 3
+
+		// The cooldown is abandoned without probing if ctx is done.
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		require.ErrorIs(t, r.circuitWait(ctx), context.Canceled)
+		require.NotContains(t, log.String(), "circuit probe")
+		require.NoError(t, r.circuit.aborted())
+		require.Nil(t, r.circuit.probe)
+	})
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types/filters"
+)
+
+// combinedListsKey is the context key of the combined lists of a prune pass.
+type combinedListsKey struct{}
+
+// combinedLists are the resources listed once per prune pass, by resource
+// type and the filters other than labels, when combined listing is enabled.
+type combinedLists struct {
+	// ctx is the context of the prune pass, which bounds the combined
+	// lists instead of the context of the filter listed first, so a
+	// session deadline doesn't fail the lists of the other sessions.
+	ctx context.Context //nolint:containedctx // Shared by the lists of the pass.
+
+	lists map[string]*combinedList
+	mtx   sync.Mutex
+}
+
+// combinedList is a single combined list, listed on first use.
+type combinedList struct {
+	once  sync.Once
+	items any
+	err   error
+}
+
+// withCombinedLists returns ctx with new combined lists for a prune
+// pass, if combined listing is enabled.
+func (r *reaper) withCombinedLists(ctx context.Context) context.Context {
+	if !r.cfg.CombinedList {
+		return ctx
+	}
+
+	lists := &combinedLists{lists: make(map[string]*combinedList)}
+	ctx = context.WithValue(ctx, combinedListsKey{}, lists)
+	lists.ctx = ctx
+
+	return ctx
+}
+
+// get returns the combined list for key, creating it if needed.
+// Safe to call concurrently.
+func (c *combinedLists) get(key string) *combinedList {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	list, ok := c.lists[key]
+	if !ok {
+		list = &combinedList{}
+		c.lists[key] = list
+	}
+
+	return list
+}
+
+// listCombined returns the resources of resourceType matching args.
+//
+// If ctx has combined lists and args include the label namespace, all the
+// resources with the namespace label are listed once per prune pass and
+// matched against the label filters of args in memory, reducing the Docker
+// requests when there are many filters. Otherwise args are listed directly,
+// matching any label glob filters in memory.
+// Combined lists are bounded by the list timeout of resourceType from the
+// context of the prune pass, rather than by ctx, as they're shared.
+func listCombined[T any](ctx context.Context, r *reaper, resourceType string, args filters.Args, labels func(T) map[string]string, list func(ctx context.Context, cli dockerClient, args filters.Args) ([]T, error)) ([]T, error) {
+	lists, _ := ctx.Value(combinedListsKey{}).(*combinedLists)
+	base := r.cfg.LabelNamespace + "=true"
+	// ExactMatch is true if there are no label filters, which could match
+	// resources without the namespace label, so those are listed directly.
+	if lists == nil || len(args.Get("label")) == 0 || !args.ExactMatch("label", base) {
+		listArgs, glob := globLabels(args)
+		items, err := listReconnect(ctx, r, func(cli dockerClient) ([]T, error) {
+			return list(ctx, cli, listArgs)
+		})
+		if err != nil || !glob {
+			return items, err
+		}
+
+		return matchItems(items, labels, args.Get("label")), nil
+	}
+
+	// The filters other than labels, such as a scope network, are
+	// included in the combined list so they're applied by Docker.
+	combinedArgs := args.Clone()
+	for _, label := range args.Get("label") {
+		combinedArgs.Del("label", label)
+	}
+	combinedArgs.Add("label", base)
+
+	combined := lists.get(resourceType + filterKey(combinedArgs))
+	combined.once.Do(func() {
+		r.logger.Debug("combined list", "resource", resourceType, "filter", combinedArgs)
+		listCtx, cancel := context.WithTimeout(lists.ctx, r.cfg.resourceListTimeout(resourceType))
+		defer cancel()
+
+		combined.items, combined.err = listReconnect(listCtx, r, func(cli dockerClient) ([]T, error) {
+			return list(listCtx, cli, combinedArgs)
+		})
+	})
+	if combined.err != nil {
+		return nil, combined.err
+	}
+
+	items := combined.items.([]T) //nolint:forcetypeassert // Always a list of T for the key.
+	return matchItems(items, labels, args.Get("label")), nil
+}
+
+// matchLabels reports whether resourceLabels match all the label filters,
+// each either a key which must be present, a key=value pair or a
+// key=prefix* label glob.
+func matchLabels(resourceLabels map[string]string, labelFilters []string) bool {
+	for _, filter := range labelFilters {
+		key, value, hasValue := strings.Cut(filter, "=")
+		actual, ok := resourceLabels[key]
+		if !ok {
+			return false
+		}
+
+		if prefix, glob := strings.CutSuffix(value, labelGlob); glob {
+			if !strings.HasPrefix(actual, prefix) {
+				return false
+			}
+		} else if hasValue && actual != value {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/stretchr/testify/require"
+)
+
+// labelListClient is a client which lists its resources filtered by
+// label like Docker does, counting the lists.
+type labelListClient struct {
+	*mockClient
+	containers []types.Container
+	networks   []network.Summary
+	volumes    []*volume.Volume
+	images     []image.Summary
+	lists      atomic.Int64
+}
+
+// matches reports whether labels match all the label filters, as Docker does.
+func (c *labelListClient) matches(labels map[string]string, filters []string) bool {
+	for _, filter := range filters {
+		key, value, hasValue := strings.Cut(filter, "=")
+		if actual, ok := labels[key]; !ok || (hasValue && actual != value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (c *labelListClient) ContainerList(_ context.Context, options container.ListOptions) ([]types.Container, error) {
+	c.lists.Add(1)
+	var ret []types.Container
+	for _, item := range c.containers {
+		if options.Filters.Contains("id") && !options.Filters.ExactMatch("id", item.ID) {
+			continue
+		}
+
+		if c.matches(item.Labels, options.Filters.Get("label")) {
+			ret = append(ret, item)
+		}
+	}
+	return ret, nil
+}
+
+func (c *labelListClient) NetworkList(_ context.Context, options network.ListOptions) ([]network.Summary, error) {
+	c.lists.Add(1)
+	var ret []network.Summary
+	for _, item := range c.networks {
+		if c.matches(item.Labels, options.Filters.Get("label")) {
+			ret = append(ret, item)
+		}
+	}
+	return ret, nil
+}
+
+func (c *labelListClient) VolumeList(_ context.Context, options volume.ListOptions) (volume.ListResponse, error) {
+	c.lists.Add(1)
+	var ret volume.ListResponse
+	for _, item := range c.volumes {
+		if c.matches(item.Labels, options.Filters.Get("label")) {
+			ret.Volumes = append(ret.Volumes, item)
+		}
+	}
+	return ret, nil
+}
+
+func (c *labelListClient) ImageList(_ context.Context, options image.ListOptions) ([]image.Summary, error) {
+	c.lists.Add(1)
+	var ret []image.Summary
+	for _, item := range c.images {
+		if c.matches(item.Labels, options.Filters.Get("label")) {
+			ret = append(ret, item)
+		}
+	}
+	return ret, nil
+}
+
+// newLabelListClient returns a client with resources for each of sessions,
+// plus resources of another session and without labels.
+func newLabelListClient(created time.Time, sessions ...map[string]string) *labelListClient {
+	cli := &labelListClient{mockClient: &mockClient{}}
+	cli.On("Ping", mockContext).Return(types.Ping{}, nil)
+	cli.On("NegotiateAPIVersion", mockContext).Return()
+	cli.On("ClientVersion").Return("1.47")
+
+	other := map[string]string{labelBase: "true", sessionIDLabel: "other"}
+	for i, labels := range append(sessions, other, nil) {
+		id := fmt.Sprintf("session%d", i)
+		cli.containers = append(cli.containers, types.Container{ID: id, Created: created.Unix(), Labels: labels})
+		cli.networks = append(cli.networks, network.Summary{ID: id, Created: created, Labels: labels})
+		cli.volumes = append(cli.volumes, &volume.Volume{Name: id, CreatedAt: created.Format(time.RFC3339), Labels: labels})
+		cli.images = append(cli.images, image.Summary{ID: id, Created: created.Unix(), Labels: labels})
+	}
+
+	return cli
+}
+
+// newCombinedTestReaper returns a reaper using cli with a filter
+// registered for each of sessions.
+func newCombinedTestReaper(tb testing.TB, cli dockerClient, combined bool, sessions ...map[string]string) *reaper {
+	tb.Helper()
+
+	cfg := testConfigBase
+	cfg.CombinedList = combined
+	r, err := newReaper(context.Background(), withConfig(cfg), withClient(cli), withLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	require.NoError(tb, err)
+	tb.Cleanup(func() { r.listener.Close() })
+
+	for i, labels := range sessions {
+		require.NoError(tb, r.addFilter(fmt.Sprintf("client%d", i), filterKey(filterArgs(labels))))
+	}
+
+	return r
+}
+
+func TestCombinedList(t *testing.T) {
+	since := time.Now()
+	created := since.Add(-time.Minute)
+	keyOnly := map[string]string{labelBase: "true", sessionIDLabel: testID()}
+	sessions := []map[string]string{testLabels1, testLabels2, keyOnly}
+
+	listed := make(map[bool]*resources)
+	for _, combined := range []bool{false, true} {
+		cli := newLabelListClient(created, sessions...)
+		r := newCombinedTestReaper(t, cli, combined, sessions...)
+
+		res, err := r.resources(since)
+		require.NoError(t, err)
+		listed[combined] = res
+
+		// Each type is listed once, instead of once per filter.
+		expected := int64(len(sessions) * 4)
+		if combined {
+			expected = 4
+		}
+		require.Equal(t, expected, cli.lists.Load())
+	}
+
+	// Both modes find the same resources, only those of the sessions.
+	ids := []string{"session0", "session1", "session2"}
+	for _, res := range listed {
+		require.ElementsMatch(t, ids, res.containers)
+		require.ElementsMatch(t, ids, res.networks)
+		require.ElementsMatch(t, ids, res.volumes)
+		require.ElementsMatch(t, ids, res.images)
+	}
+
+	t.Run("without-namespace-label", func(t *testing.T) {
+		// Filters without the namespace label are listed individually.
+		session := map[string]string{sessionIDLabel: "other"}
+		cli := newLabelListClient(created)
+		r := newCombinedTestReaper(t, cli, true, testLabels1, session)
+
+		res, err := r.resources(since)
+		require.NoError(t, err)
+		require.Equal(t, int64(8), cli.lists.Load())
+		require.Equal(t, []string{"session0"}, res.containers)
+	})
+
+	t.Run("without-label", func(t *testing.T) {
+		// Filters without labels may match resources without the
+		// namespace label, so both modes must find the same ones.
+		args := filters.NewArgs(filters.Arg("id", "session2"))
+		found := make(map[bool][]string)
+		for _, combined := range []bool{false, true} {
+			cli := newLabelListClient(created, testLabels1)
+			r := newCombinedTestReaper(t, cli, combined)
+			require.NoError(t, r.addFilter("client", filterKey(args)))
+
+			res, err := r.resources(since)
+			require.NoError(t, err)
+			found[combined] = res.containers
+		}
+		require.Equal(t, []string{"session2"}, found[false])
+		require.Equal(t, found[false], found[true])
+	})
+
+	t.Run("caller-context", func(t *testing.T) {
+		// The shared list isn't bounded by the context of the filter
+		// listed first, such as one whose session deadline passed.
+		cli := newLabelListClient(created, testLabels1, testLabels2)
+		r := newCombinedTestReaper(t, cli, true)
+		pass := r.withCombinedLists(context.Background())
+		expired, cancel := context.WithCancel(pass)
+		cancel()
+
+		labels := func(c types.Container) map[string]string { return c.Labels }
+		list := func(ctx context.Context, cli dockerClient, args filters.Args) ([]types.Container, error) {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			return cli.ContainerList(ctx, container.ListOptions{All: true, Filters: args}) //nolint:wrapcheck // Test list.
+		}
+		_, err := listCombined(expired, r, "container", filterArgs(testLabels1), labels, list)
+		require.NoError(t, err)
+
+		found, err := listCombined(pass, r, "container", filterArgs(testLabels2), labels, list)
+		require.NoError(t, err)
+		require.Len(t, found, 1)
+		require.Equal(t, "session1", found[0].ID)
+		require.Equal(t, int64(1), cli.lists.Load())
+	})
+
+	t.Run("match-labels", func(t *testing.T) {
+		labels := map[string]string{"a": "1", "b": ""}
+		require.True(t, matchLabels(labels, nil))
+		require.True(t, matchLabels(labels, []string{"a=1", "b"}))
+		require.True(t, matchLabels(labels, []string{"b="}))
+		require.False(t, matchLabels(labels, []string{"a=2"}))
+		require.False(t, matchLabels(labels, []string{"c"}))
+		require.False(t, matchLabels(nil, []string{"a"}))
+
+		// Label globs match by value prefix.
+		globbed := map[string]string{"run": "1234-abcd"}
+		require.True(t, matchLabels(globbed, []string{"run=1234-*"}))
+		require.True(t, matchLabels(globbed, []string{"run=*"}))
+		require.False(t, matchLabels(globbed, []string{"run=5678-*"}))
+		require.False(t, matchLabels(globbed, []string{"other=1234-*"}))
+	})
+}
+
+func BenchmarkResources(b *testing.B) {
+	since := time.Now()
+	created := since.Add(-time.Minute)
+	sessions := make([]map[string]string, 50)
+	for i := range sessions {
+		sessions[i] = map[string]string{labelBase: "true", sessionIDLabel: testID()}
+	}
+
+	for _, combined := range []bool{false, true} {
+		b.Run(fmt.Sprintf("combined=%t", combined), func(b *testing.B) {
+			cli := newLabelListClient(created, sessions...)
+			r := newCombinedTestReaper(b, cli, combined, sessions...)
+
+			b.ResetTimer()
+			for range b.N {
+				if _, err := r.resources(since); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(cli.lists.Load())/float64(b.N), "lists/op")
+		})
+	}
+}
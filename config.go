@@ -1,13 +1,39 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/caarlos0/env/v11"
 )
 
+var (
+	// errNotPositive is returned when a duration which must be positive isn't.
+	errNotPositive = errors.New("must be positive")
+
+	// errInconsistentConfig is returned when options are valid on their
+	// own but inconsistent with each other.
+	errInconsistentConfig = errors.New("inconsistent config")
+
+	// errInvalidPruneOrder is returned when the prune order doesn't list
+	// each resource type exactly once.
+	errInvalidPruneOrder = errors.New("invalid prune order")
+
+	// errInvalidContainerHealth is returned when a container health
+	// status isn't one Docker filters by.
+	errInvalidContainerHealth = errors.New("invalid container health")
+
+	// pruneTypes are the resource types which are pruned, in the default order.
+	pruneTypes = []string{"container", "network", "volume", "image"} //nolint:gochecknoglobals // Read only.
+
+	// containerHealths are the container health statuses Docker filters by.
+	containerHealths = []string{"starting", "healthy", "unhealthy", "none"} //nolint:gochecknoglobals // Read only.
+)
+
 // config represents the configuration for the reaper.
 type config struct {
 	// ConnectionTimeout is the duration without receiving any connections which will trigger a shutdown.
@@ -23,6 +49,11 @@ type config struct {
 	// RemoveRetries is the number of times to retry removing a resource.
 	RemoveRetries int `env:"RYUK_REMOVE_RETRIES" envDefault:"10"`
 
+	// RemoveBackoff is the base delay between attempts to remove a resource,
+	// which doubles each attempt up to a minute with random jitter. If zero
+	// attempts are retried immediately.
+	RemoveBackoff time.Duration `env:"RYUK_REMOVE_BACKOFF" envDefault:"1s"`
+
 	// RetryOffset is the offset added to the start time of the prune pass that is
 	// used as the minimum resource creation time. Any resource created after this
 	// calculated time will trigger a retry to ensure in use resources are not removed.
@@ -42,6 +73,435 @@ type config struct {
 
 	// Verbose is whether to enable verbose aka debug logging.
 	Verbose bool `env:"RYUK_VERBOSE" envDefault:"false"`
+
+	// OrphansFile is the path of a file to write the resources which could
+	// not be removed to as JSON. If empty no file is written.
+	OrphansFile string `env:"RYUK_ORPHANS_FILE"`
+
+	// ReferenceCounting is whether resources matching a filter registered by a
+	// still connected client are excluded from pruning, even if they also match
+	// a filter whose clients have all disconnected.
+	ReferenceCounting bool `env:"RYUK_REFERENCE_COUNTING" envDefault:"false"`
+
+	// MaxMemory is the maximum number of bytes of memory the reaper can use before
+	// it triggers an early best effort prune and shutdown. Zero means no limit.
+	MaxMemory uint64 `env:"RYUK_MAX_MEMORY" envDefault:"0"`
+
+	// BuildkitAddr is the address of a standalone BuildKit daemon to prune
+	// the build cache of after resources are removed. If empty BuildKit
+	// build cache is not pruned.
+	BuildkitAddr string `env:"RYUK_BUILDKIT_ADDR"`
+
+	// BindCleanupRoots are the host directories within which clients can
+	// register paths, using the BINDPATH command, to be removed after the prune.
+	// If empty the BINDPATH command is rejected.
+	BindCleanupRoots []string `env:"RYUK_BIND_CLEANUP_ROOTS" envSeparator:","`
+
+	// ManualPrune is whether a SIGUSR2 signal triggers an immediate prune
+	// of the resources matching the current filters, without shutting down.
+	ManualPrune bool `env:"RYUK_MANUAL_PRUNE" envDefault:"false"`
+
+	// SummaryGroupLabel is the label whose value is used to group the
+	// removed resources summary, for example by project. If empty
+	// no grouped summary is reported.
+	SummaryGroupLabel string `env:"RYUK_SUMMARY_GROUP_LABEL"`
+
+	// HoldFile is the path of a file which, if it exists when a prune
+	// is about to run, causes all removals to be skipped so resources
+	// can be inspected.
+	HoldFile string `env:"RYUK_HOLD_FILE"`
+
+	// TLSCertFile is the path of the PEM encoded certificate used to
+	// serve clients over TLS. Requires TLSKeyFile. The handshake must
+	// complete within ConnectionTimeout.
+	// Not to be confused with DockerTLSCert, used to connect to Docker.
+	TLSCertFile string `env:"RYUK_LISTENER_TLS_CERT"`
+
+	// TLSKeyFile is the path of the PEM encoded private key for TLSCertFile.
+	TLSKeyFile string `env:"RYUK_LISTENER_TLS_KEY"`
+
+	// DeprecatedTLSCertFile is the deprecated name of TLSCertFile,
+	// used if TLSCertFile isn't set.
+	DeprecatedTLSCertFile string `env:"RYUK_TLS_CERT_FILE"`
+
+	// DeprecatedTLSKeyFile is the deprecated name of TLSKeyFile,
+	// used if TLSKeyFile isn't set.
+	DeprecatedTLSKeyFile string `env:"RYUK_TLS_KEY_FILE"`
+
+	// TLSAutodetect enables serving both TLS and plaintext clients on
+	// the same port, detected from the first byte received.
+	// Requires TLSCertFile and TLSKeyFile.
+	TLSAutodetect bool `env:"RYUK_TLS_AUTODETECT" envDefault:"false"`
+
+	// PingInterval is the interval between checks that Docker is reachable.
+	// If a check fails the Docker client is recreated. If zero no checks
+	// are performed.
+	PingInterval time.Duration `env:"RYUK_PING_INTERVAL" envDefault:"0s"`
+
+	// SelfRemove is whether to remove the reaper's own container, identified
+	// by its hostname, as the last action before exiting. Intended for
+	// standalone deployments which want no resources left behind.
+	SelfRemove bool `env:"RYUK_SELF_REMOVE" envDefault:"false"`
+
+	// LogSample is the maximum number of per resource debug log entries
+	// for each resource type listed, after which only the number omitted
+	// is logged. If zero all entries are logged.
+	LogSample int `env:"RYUK_LOG_SAMPLE" envDefault:"0"`
+
+	// SkipSharedNetworks is whether to skip removing networks which have
+	// containers attached that don't match any session filter.
+	SkipSharedNetworks bool `env:"RYUK_SKIP_SHARED_NETWORKS" envDefault:"false"`
+
+	// ShutdownBestEffortOK is whether to exit successfully after a forced
+	// best effort prune at the shutdown timeout, even if not all resources
+	// were removed. Failures are still logged.
+	ShutdownBestEffortOK bool `env:"RYUK_SHUTDOWN_BEST_EFFORT_OK" envDefault:"false"`
+
+	// StopSignal is the signal used to stop containers before they are
+	// removed, for example SIGQUIT so a JVM dumps its state. If empty
+	// containers are stopped with their own stop signal if StopTimeout
+	// is set, otherwise they're not stopped, just forcibly removed.
+	StopSignal string `env:"RYUK_STOP_SIGNAL"`
+
+	// MaxConcurrentHandshakes is the maximum number of connections which
+	// can be in the handshake phase, up to receiving their first message,
+	// at once. Excess connections are queued. If zero there is no limit.
+	MaxConcurrentHandshakes int `env:"RYUK_MAX_CONCURRENT_HANDSHAKES" envDefault:"0"`
+
+	// ListTimeoutContainers is the timeout for listing containers.
+	// If zero RequestTimeout is used.
+	ListTimeoutContainers time.Duration `env:"RYUK_LIST_TIMEOUT_CONTAINERS" envDefault:"0s"`
+
+	// ListTimeoutNetworks is the timeout for listing networks.
+	// If zero RequestTimeout is used.
+	ListTimeoutNetworks time.Duration `env:"RYUK_LIST_TIMEOUT_NETWORKS" envDefault:"0s"`
+
+	// ListTimeoutVolumes is the timeout for listing volumes.
+	// If zero RequestTimeout is used.
+	ListTimeoutVolumes time.Duration `env:"RYUK_LIST_TIMEOUT_VOLUMES" envDefault:"0s"`
+
+	// ListTimeoutImages is the timeout for listing images, which can be
+	// much slower than other types on hosts with many images.
+	// If zero RequestTimeout is used.
+	ListTimeoutImages time.Duration `env:"RYUK_LIST_TIMEOUT_IMAGES" envDefault:"0s"`
+
+	// ImagesUsePrune is whether to remove images with a single prune call
+	// per filter, instead of removing each image individually. Ignored if
+	// reference counting, self protection or label glob exclusions are
+	// enabled, or a filter has a label glob, as Docker can't apply them.
+	ImagesUsePrune bool `env:"RYUK_IMAGES_USE_PRUNE" envDefault:"false"`
+
+	// ReleaseFile is the path of a file which, if it exists when a prune
+	// is triggered, delays removal until it's removed or the shutdown
+	// timeout is reached.
+	ReleaseFile string `env:"RYUK_RELEASE_FILE"`
+
+	// ReleasePollInterval is the interval between checks for the
+	// removal of ReleaseFile.
+	ReleasePollInterval time.Duration `env:"RYUK_RELEASE_POLL_INTERVAL" envDefault:"1s"`
+
+	// SessionDeadline is the maximum time spent listing, and separately
+	// removing, the resources of each session, so a session with many
+	// resources can't delay the others. If zero there is no limit.
+	SessionDeadline time.Duration `env:"RYUK_SESSION_DEADLINE" envDefault:"0s"`
+
+	// PruneContainers is whether matching containers are removed.
+	PruneContainers bool `env:"RYUK_PRUNE_CONTAINERS" envDefault:"true"`
+
+	// PruneNetworks is whether matching networks are removed.
+	PruneNetworks bool `env:"RYUK_PRUNE_NETWORKS" envDefault:"true"`
+
+	// PruneVolumes is whether matching volumes are removed.
+	PruneVolumes bool `env:"RYUK_PRUNE_VOLUMES" envDefault:"true"`
+
+	// PruneImages is whether matching images are removed.
+	PruneImages bool `env:"RYUK_PRUNE_IMAGES" envDefault:"true"`
+
+	// AuditFile is the path of a file to append audit events to as JSON
+	// lines, such as each filter registered by a client. If empty no
+	// audit events are written.
+	AuditFile string `env:"RYUK_AUDIT_FILE"`
+
+	// KeepOnePerImage is whether the newest matching container of each
+	// distinct image is kept, along with its image, in each session so
+	// the image isn't pruned by other tooling.
+	KeepOnePerImage bool `env:"RYUK_KEEP_ONE_PER_IMAGE" envDefault:"false"`
+
+	// DryRun is whether to log the resources which would be removed
+	// instead of removing them.
+	DryRun bool `env:"RYUK_DRY_RUN" envDefault:"false"`
+
+	// RemovalInProgressWait is the maximum time to wait for a resource whose
+	// removal is already in progress, for example by another reaper, to be
+	// gone. If it's gone it's counted as removed, otherwise it's skipped.
+	// If zero it's retried as a failure.
+	RemovalInProgressWait time.Duration `env:"RYUK_REMOVAL_IN_PROGRESS_WAIT" envDefault:"5s"`
+
+	// HealthPort is the port to serve the HTTP health endpoint on.
+	// If zero the endpoint is disabled.
+	HealthPort uint16 `env:"RYUK_HEALTH_PORT" envDefault:"0"`
+
+	// ScopeNetwork is the name or ID of a network which containers must
+	// be attached to in order to be removed. If empty containers on any
+	// network are removed.
+	ScopeNetwork string `env:"RYUK_SCOPE_NETWORK"`
+
+	// ProtectSelf is whether the reaper's own container, identified by its
+	// hostname, and the networks and volumes it uses are never removed.
+	// Intended for running as a service in the compose project being cleaned.
+	ProtectSelf bool `env:"RYUK_PROTECT_SELF" envDefault:"false"`
+
+	// DockerHost is the address of the Docker daemon to connect to,
+	// overriding DOCKER_HOST.
+	DockerHost string `env:"RYUK_DOCKER_HOST"`
+
+	// DockerTLSCert is the path of the PEM encoded client certificate
+	// used to connect to the Docker daemon. Requires DockerTLSKey.
+	DockerTLSCert string `env:"RYUK_TLS_CERT"`
+
+	// DockerTLSKey is the path of the PEM encoded private key for DockerTLSCert.
+	DockerTLSKey string `env:"RYUK_TLS_KEY"`
+
+	// DockerTLSCA is the path of the PEM encoded CA certificate used to
+	// verify the Docker daemon. If empty the system roots are used.
+	DockerTLSCA string `env:"RYUK_TLS_CA"`
+
+	// KeepLabel is the label which, when set to true on a resource, prevents
+	// it from being removed even if it matches a filter. If empty no
+	// resources are kept.
+	KeepLabel string `env:"RYUK_KEEP_LABEL" envDefault:"org.testcontainers.keep"`
+
+	// MinClients is the minimum number of distinct clients which must have
+	// connected before the last client disconnecting triggers a prune.
+	// Intended for suites sharded across parallel workers, so cleanup only
+	// happens once every shard has finished. Shutdown and the initial
+	// ConnectionTimeout are unaffected. If zero any client is sufficient.
+	MinClients int `env:"RYUK_MIN_CLIENTS" envDefault:"0"`
+
+	// CountUntaggedImages is whether an image which was only untagged, as
+	// other tags still reference it, is counted as removed. If false only
+	// images which were deleted are counted.
+	CountUntaggedImages bool `env:"RYUK_COUNT_UNTAGGED_IMAGES" envDefault:"true"`
+
+	// RemoveConcurrency is the maximum number of resources of each type
+	// removed concurrently. Removal order, such as pod containers before
+	// their sandbox, is only guaranteed when one. Values below one are
+	// treated as one.
+	RemoveConcurrency int `env:"RYUK_REMOVE_CONCURRENCY" envDefault:"1"`
+
+	// ContainerHealth are the health statuses of the containers to remove,
+	// one of starting, healthy, unhealthy or none for containers without
+	// a health check. If empty containers are removed whatever their health.
+	ContainerHealth []string `env:"RYUK_CONTAINER_HEALTH" envSeparator:","`
+
+	// RemoveChunkSize is the maximum number of resources of each type
+	// removed before pausing for RemoveChunkPause, bounding the load on
+	// the daemon when removing very large numbers of resources. If zero
+	// resources are removed without pausing.
+	RemoveChunkSize int `env:"RYUK_REMOVE_CHUNK_SIZE" envDefault:"0"`
+
+	// RemoveChunkPause is the pause between chunks of removals.
+	RemoveChunkPause time.Duration `env:"RYUK_REMOVE_CHUNK_PAUSE" envDefault:"0s"`
+
+	// MaintenanceUntil is the end of a maintenance window, in RFC 3339
+	// format, before which prunes are deferred. A prune forced by the
+	// shutdown timeout or memory limit still runs during the window.
+	MaintenanceUntil time.Time `env:"RYUK_MAINTENANCE_UNTIL"`
+
+	// FilterSchema is the path of a JSON file restricting the filters
+	// clients can register, see filterSchema. Filters which aren't
+	// allowed are rejected with a NACK. If empty all filters are allowed.
+	FilterSchema string `env:"RYUK_FILTER_SCHEMA"`
+
+	// ConnectionToken is a shared secret which clients must send as the
+	// first line of each connection, before any filter. Connections which
+	// don't are closed without a response. If empty no token is required.
+	ConnectionToken string `env:"RYUK_CONNECTION_TOKEN"`
+
+	// ReapExitCodes are the exit codes of the exited containers to remove,
+	// for example 0 to keep failed containers for inspection. Containers
+	// which haven't exited are unaffected. If empty all are removed.
+	ReapExitCodes []int `env:"RYUK_REAP_EXIT_CODES" envSeparator:","`
+
+	// ShadowDockerHost is the address of a secondary Docker daemon on which
+	// the resources matching the filters are listed, but never removed, and
+	// any differences from the primary daemon logged. Intended for validating
+	// upgrades. If empty no shadow daemon is used.
+	ShadowDockerHost string `env:"RYUK_SHADOW_DOCKER_HOST"`
+
+	// PruneBuildCache is whether to prune the Docker daemon's build cache
+	// which hasn't been used since the prune started. Build cache has no
+	// labels, so this isn't limited to the resources of the registered
+	// filters.
+	PruneBuildCache bool `env:"RYUK_PRUNE_BUILD_CACHE" envDefault:"false"`
+
+	// ReportSizes is whether to report the bytes reclaimed by removing
+	// containers, volumes and images. Containers are listed with their
+	// size and volume sizes are requested from the daemon, which can be
+	// slow on daemons with a lot of data.
+	ReportSizes bool `env:"RYUK_REPORT_SIZES" envDefault:"false"`
+
+	// SkipActiveExec is whether to skip removing running containers which
+	// have active exec sessions, so interactive debugging sessions aren't
+	// terminated. Each running container is inspected when enabled.
+	SkipActiveExec bool `env:"RYUK_SKIP_ACTIVE_EXEC" envDefault:"false"`
+
+	// CircuitThreshold is the number of consecutive removal failures after
+	// which removals stop until the daemon has been probed. If the probe
+	// fails the rest of the prune pass is aborted. If zero it's disabled.
+	CircuitThreshold int `env:"RYUK_CIRCUIT_THRESHOLD" envDefault:"0"`
+
+	// CircuitCooldown is the duration to wait before probing the daemon
+	// once the circuit threshold is reached.
+	CircuitCooldown time.Duration `env:"RYUK_CIRCUIT_COOLDOWN" envDefault:"10s"`
+
+	// StrictFilters is whether filters which fail to parse are rejected
+	// with NACK, instead of ACK, so clients can surface the error.
+	StrictFilters bool `env:"RYUK_STRICT_FILTERS" envDefault:"false"`
+
+	// ClientReadTimeout is the duration clients have to send their first
+	// message after connecting, otherwise they are disconnected so they
+	// can't keep the reaper alive. If zero clients can wait indefinitely.
+	ClientReadTimeout time.Duration `env:"RYUK_CLIENT_READ_TIMEOUT" envDefault:"0s"`
+
+	// DecisionLogSize is the number of recent prune decisions about
+	// resources kept in memory and served by the admin endpoint.
+	// If zero decisions aren't recorded.
+	DecisionLogSize int `env:"RYUK_DECISION_LOG_SIZE" envDefault:"0"`
+
+	// ReconnectRetries is the number of times the Docker client is
+	// recreated and a list retried if it fails to connect, for example
+	// because the daemon restarted. If zero lists aren't retried.
+	ReconnectRetries int `env:"RYUK_RECONNECT_RETRIES" envDefault:"3"`
+
+	// LabelNamespace is the namespace of the labels used to identify
+	// reaper containers and sessions, for clients which use their own.
+	LabelNamespace string `env:"RYUK_LABEL_NAMESPACE" envDefault:"org.testcontainers"`
+
+	// DeferImages is whether images are only removed by the final prune,
+	// after all other resources, instead of by each prune, as they may
+	// be shared with sessions which are still running.
+	DeferImages bool `env:"RYUK_DEFER_IMAGES" envDefault:"false"`
+
+	// DeferImagesSettle is the duration to wait after all other resources
+	// are removed before removing deferred images.
+	DeferImagesSettle time.Duration `env:"RYUK_DEFER_IMAGES_SETTLE" envDefault:"10s"`
+
+	// HeartbeatInterval is the interval between logs while waiting for a
+	// prune condition, so the reaper can be seen to be alive.
+	// If zero no heartbeat is logged.
+	HeartbeatInterval time.Duration `env:"RYUK_HEARTBEAT_INTERVAL" envDefault:"0s"`
+
+	// WebhookURL is the URL which the resources removed by each prune are
+	// posted to as JSON, for auditing. If empty nothing is posted.
+	WebhookURL string `env:"RYUK_WEBHOOK_URL"`
+
+	// ExpectedDockerHost is the Docker host the reaper must be connected
+	// to, otherwise it refuses to start. If empty any host is accepted.
+	ExpectedDockerHost string `env:"RYUK_EXPECTED_DOCKER_HOST"`
+
+	// ExpectedDaemonLabel is a key=value label the Docker daemon must have,
+	// otherwise the reaper refuses to start. If empty any daemon is accepted.
+	ExpectedDaemonLabel string `env:"RYUK_EXPECTED_DAEMON_LABEL"`
+
+	// BindAddr is the address to listen on for connections and the health
+	// and admin endpoints, for example 127.0.0.1 to only accept local
+	// clients. If empty all interfaces are used.
+	BindAddr string `env:"RYUK_BIND_ADDR"`
+
+	// StrictConfig is whether inconsistent options are rejected,
+	// instead of logged as a warning.
+	StrictConfig bool `env:"RYUK_STRICT_CONFIG" envDefault:"false"`
+
+	// RequiredFilterLabels are the label keys every filter must include,
+	// filters without them are rejected so they can't match unrelated
+	// resources. If empty no labels are required.
+	RequiredFilterLabels []string `env:"RYUK_REQUIRED_FILTER_LABELS" envSeparator:","`
+
+	// MaxFilters is the maximum number of distinct filters which can be
+	// registered, clients registering more are disconnected so they can't
+	// slow every prune. If zero there is no limit.
+	MaxFilters int `env:"RYUK_MAX_FILTERS" envDefault:"0"`
+
+	// CombinedList is whether resources with the label namespace label are
+	// listed once per prune and matched against the label filters in
+	// memory, instead of listed for each filter.
+	CombinedList bool `env:"RYUK_COMBINED_LIST" envDefault:"false"`
+
+	// RequireFirstConnection is whether to exit with an error, instead of
+	// pruning, if no client connects within ConnectionTimeout, as that
+	// indicates the test harness never attached.
+	RequireFirstConnection bool `env:"RYUK_REQUIRE_FIRST_CONNECTION" envDefault:"false"`
+
+	// PruneOrder is the order resource types are removed in, which must
+	// list each of container, network, volume and image exactly once.
+	PruneOrder []string `env:"RYUK_PRUNE_ORDER" envDefault:"container,network,volume,image" envSeparator:","`
+
+	// RemoveContainerVolumes is whether anonymous volumes are removed with
+	// their containers. Disable to keep them for inspection after a failed test.
+	RemoveContainerVolumes bool `env:"RYUK_REMOVE_CONTAINER_VOLUMES" envDefault:"true"`
+
+	// ReconnectionGrace is the additional duration to wait for a new
+	// connection once ReconnectionTimeout has passed after the last client
+	// disconnected, before committing to the prune. This covers frameworks
+	// which briefly disconnect between test classes. If zero there is no grace.
+	ReconnectionGrace time.Duration `env:"RYUK_RECONNECTION_GRACE" envDefault:"0s"`
+
+	// ExcludeLabels are labels, either a key or key=value, which exempt a
+	// resource from removal even if it matches a filter, for example
+	// shared infrastructure which also carries a session label.
+	ExcludeLabels []string `env:"RYUK_EXCLUDE_LABELS" envSeparator:","`
+
+	// ImageRetryOffset is the retry offset used for images instead of
+	// RetryOffset, as images take longer to build than containers take
+	// to start. If zero RetryOffset is used.
+	ImageRetryOffset time.Duration `env:"RYUK_IMAGE_RETRY_OFFSET" envDefault:"0s"`
+
+	// InspectChanges is whether containers which appear to have been created
+	// after the prune started are inspected to re-evaluate them using their
+	// precise creation time, reducing false changes on hosts with clock drift.
+	InspectChanges bool `env:"RYUK_INSPECT_CHANGES" envDefault:"false"`
+
+	// AdminPort is the port to serve the HTTP admin status, filters and
+	// decisions endpoints on. If zero the admin endpoints are disabled.
+	AdminPort uint16 `env:"RYUK_ADMIN_PORT" envDefault:"0"`
+
+	// EmitReadyEnv is whether to also write RYUK_READY=<address> to stderr
+	// once ready, a stable hook for wrappers independent of the log format.
+	EmitReadyEnv bool `env:"RYUK_EMIT_READY_ENV" envDefault:"false"`
+
+	// MaxRemovals is the maximum number of resources a prune can remove,
+	// a prune exceeding it removes nothing, guarding against too broad
+	// filters. If zero there is no limit.
+	MaxRemovals int `env:"RYUK_MAX_REMOVALS" envDefault:"0"`
+
+	// ProgressInterval is the minimum interval between logs of the
+	// progress of removing each resource type. If zero progress
+	// isn't logged.
+	ProgressInterval time.Duration `env:"RYUK_PROGRESS_INTERVAL" envDefault:"0s"`
+
+	// LogFile is the path of the file the default logger writes to instead
+	// of stdout. If empty logs are written to stdout.
+	LogFile string `env:"RYUK_LOG_FILE"`
+
+	// LogMaxSize is the maximum bytes the log file can grow to before it's
+	// rotated to a single backup with the suffix .1. Zero means no limit.
+	LogMaxSize uint64 `env:"RYUK_LOG_MAX_SIZE" envDefault:"104857600"`
+
+	// OtelEndpoint is the URL of the OTLP HTTP endpoint to export traces
+	// of prune passes to. If empty tracing is disabled.
+	OtelEndpoint string `env:"RYUK_OTEL_ENDPOINT"`
+
+	// SessionTTL is the maximum duration a filter is kept after it was
+	// first registered, once exceeded its resources are pruned even if
+	// its clients are still connected. If zero there is no limit.
+	SessionTTL time.Duration `env:"RYUK_SESSION_TTL" envDefault:"0s"`
+
+	// StopTimeout is how long containers are given to stop before they
+	// are killed. If set containers are stopped before they are removed,
+	// otherwise they're only stopped if StopSignal is set, with their
+	// own stop timeout.
+	StopTimeout time.Duration `env:"RYUK_STOP_TIMEOUT" envDefault:"0s"`
 }
 
 // LogAttrs returns the configuration as a slice of attributes.
@@ -52,13 +512,148 @@ func (c config) LogAttrs() []slog.Attr {
 		slog.Duration("request_timeout", c.RequestTimeout),
 		slog.Duration("shutdown_timeout", c.ShutdownTimeout),
 		slog.Int("remove_retries", c.RemoveRetries),
+		slog.Duration("remove_backoff", c.RemoveBackoff),
 		slog.Duration("retry_offset", c.RetryOffset),
 		slog.Duration("changes_retry_interval", c.ChangesRetryInterval),
 		slog.Int("port", int(c.Port)),
 		slog.Bool("verbose", c.Verbose),
+		slog.String("orphans_file", c.OrphansFile),
+		slog.Bool("reference_counting", c.ReferenceCounting),
+		slog.Uint64("max_memory", c.MaxMemory),
+		slog.String("buildkit_addr", c.BuildkitAddr),
+		slog.Any("bind_cleanup_roots", c.BindCleanupRoots),
+		slog.Bool("manual_prune", c.ManualPrune),
+		slog.String("summary_group_label", c.SummaryGroupLabel),
+		slog.String("hold_file", c.HoldFile),
+		slog.String("listener_tls_cert", c.TLSCertFile),
+		slog.String("listener_tls_key", c.TLSKeyFile),
+		slog.Bool("tls_autodetect", c.TLSAutodetect),
+		slog.Duration("ping_interval", c.PingInterval),
+		slog.Bool("self_remove", c.SelfRemove),
+		slog.Int("log_sample", c.LogSample),
+		slog.Bool("skip_shared_networks", c.SkipSharedNetworks),
+		slog.Bool("shutdown_best_effort_ok", c.ShutdownBestEffortOK),
+		slog.String("stop_signal", c.StopSignal),
+		slog.Int("max_concurrent_handshakes", c.MaxConcurrentHandshakes),
+		slog.Duration("list_timeout_containers", c.listTimeout(c.ListTimeoutContainers)),
+		slog.Duration("list_timeout_networks", c.listTimeout(c.ListTimeoutNetworks)),
+		slog.Duration("list_timeout_volumes", c.listTimeout(c.ListTimeoutVolumes)),
+		slog.Duration("list_timeout_images", c.listTimeout(c.ListTimeoutImages)),
+		slog.Bool("images_use_prune", c.ImagesUsePrune),
+		slog.String("release_file", c.ReleaseFile),
+		slog.Duration("release_poll_interval", c.ReleasePollInterval),
+		slog.Duration("session_deadline", c.SessionDeadline),
+		slog.Bool("prune_containers", c.PruneContainers),
+		slog.Bool("prune_networks", c.PruneNetworks),
+		slog.Bool("prune_volumes", c.PruneVolumes),
+		slog.Bool("prune_images", c.PruneImages),
+		slog.String("audit_file", c.AuditFile),
+		slog.Bool("keep_one_per_image", c.KeepOnePerImage),
+		slog.Bool("dry_run", c.DryRun),
+		slog.Duration("removal_in_progress_wait", c.RemovalInProgressWait),
+		slog.Int("health_port", int(c.HealthPort)),
+		slog.String("scope_network", c.ScopeNetwork),
+		slog.Bool("protect_self", c.ProtectSelf),
+		slog.String("docker_host", c.DockerHost),
+		slog.String("docker_tls_cert", c.DockerTLSCert),
+		slog.String("docker_tls_key", c.DockerTLSKey),
+		slog.String("docker_tls_ca", c.DockerTLSCA),
+		slog.String("keep_label", c.KeepLabel),
+		slog.Int("min_clients", c.MinClients),
+		slog.Bool("count_untagged_images", c.CountUntaggedImages),
+		slog.Int("remove_concurrency", c.RemoveConcurrency),
+		slog.Any("container_health", c.ContainerHealth),
+		slog.Int("remove_chunk_size", c.RemoveChunkSize),
+		slog.Duration("remove_chunk_pause", c.RemoveChunkPause),
+		slog.Time("maintenance_until", c.MaintenanceUntil),
+		slog.String("filter_schema", c.FilterSchema),
+		slog.Bool("connection_token", c.ConnectionToken != ""),
+		slog.Any("reap_exit_codes", c.ReapExitCodes),
+		slog.String("shadow_docker_host", c.ShadowDockerHost),
+		slog.Bool("prune_build_cache", c.PruneBuildCache),
+		slog.Bool("report_sizes", c.ReportSizes),
+		slog.Bool("skip_active_exec", c.SkipActiveExec),
+		slog.Int("circuit_threshold", c.CircuitThreshold),
+		slog.Duration("circuit_cooldown", c.CircuitCooldown),
+		slog.Bool("strict_filters", c.StrictFilters),
+		slog.Duration("client_read_timeout", c.ClientReadTimeout),
+		slog.Int("decision_log_size", c.DecisionLogSize),
+		slog.Int("reconnect_retries", c.ReconnectRetries),
+		slog.String("label_namespace", c.LabelNamespace),
+		slog.Bool("defer_images", c.DeferImages),
+		slog.Duration("defer_images_settle", c.DeferImagesSettle),
+		slog.Duration("heartbeat_interval", c.HeartbeatInterval),
+		slog.String("webhook_url", c.WebhookURL),
+		slog.String("expected_docker_host", c.ExpectedDockerHost),
+		slog.String("expected_daemon_label", c.ExpectedDaemonLabel),
+		slog.String("bind_addr", c.BindAddr),
+		slog.Bool("strict_config", c.StrictConfig),
+		slog.Any("required_filter_labels", c.RequiredFilterLabels),
+		slog.Int("max_filters", c.MaxFilters),
+		slog.Bool("combined_list", c.CombinedList),
+		slog.Bool("require_first_connection", c.RequireFirstConnection),
+		slog.Any("prune_order", c.PruneOrder),
+		slog.Bool("remove_container_volumes", c.RemoveContainerVolumes),
+		slog.Duration("reconnection_grace", c.ReconnectionGrace),
+		slog.Any("exclude_labels", c.ExcludeLabels),
+		slog.Duration("image_retry_offset", c.retryOffset(c.ImageRetryOffset)),
+		slog.Bool("inspect_changes", c.InspectChanges),
+		slog.Int("admin_port", int(c.AdminPort)),
+		slog.Bool("emit_ready_env", c.EmitReadyEnv),
+		slog.Int("max_removals", c.MaxRemovals),
+		slog.Duration("progress_interval", c.ProgressInterval),
+		slog.String("log_file", c.LogFile),
+		slog.Uint64("log_max_size", c.LogMaxSize),
+		slog.String("otel_endpoint", c.OtelEndpoint),
+		slog.Duration("session_ttl", c.SessionTTL),
+		slog.Duration("stop_timeout", c.StopTimeout),
 	}
 }
 
+// ryukLabel returns the label used to identify reaper containers.
+func (c config) ryukLabel() string {
+	return c.LabelNamespace + ryukLabelSuffix
+}
+
+// sessionIDLabel returns the label used to identify the session a resource belongs to.
+func (c config) sessionIDLabel() string {
+	return c.LabelNamespace + sessionIDLabelSuffix
+}
+
+// listTimeout returns timeout if set, otherwise the request timeout.
+func (c config) listTimeout(timeout time.Duration) time.Duration {
+	if timeout > 0 {
+		return timeout
+	}
+
+	return c.RequestTimeout
+}
+
+// resourceListTimeout returns the list timeout of resourceType.
+func (c config) resourceListTimeout(resourceType string) time.Duration {
+	switch resourceType {
+	case "container":
+		return c.listTimeout(c.ListTimeoutContainers)
+	case "network":
+		return c.listTimeout(c.ListTimeoutNetworks)
+	case "volume":
+		return c.listTimeout(c.ListTimeoutVolumes)
+	case "image":
+		return c.listTimeout(c.ListTimeoutImages)
+	default:
+		return c.RequestTimeout
+	}
+}
+
+// retryOffset returns offset if set, otherwise the retry offset.
+func (c config) retryOffset(offset time.Duration) time.Duration {
+	if offset != 0 {
+		return offset
+	}
+
+	return c.RetryOffset
+}
+
 // loadConfig loads the configuration from the environment
 // applying defaults where necessary.
 func loadConfig() (*config, error) {
@@ -67,5 +662,116 @@ func loadConfig() (*config, error) {
 		return nil, fmt.Errorf("parse env: %w", err)
 	}
 
+	cfg.applyDeprecated()
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("validate: %w", err)
+	}
+
 	return &cfg, nil
 }
+
+// applyDeprecated sets the options which aren't set from their deprecated
+// names, so existing configurations keep working.
+func (c *config) applyDeprecated() {
+	if c.TLSCertFile == "" {
+		c.TLSCertFile = c.DeprecatedTLSCertFile
+	}
+
+	if c.TLSKeyFile == "" {
+		c.TLSKeyFile = c.DeprecatedTLSKeyFile
+	}
+}
+
+// deprecated returns the replacement of each deprecated option which is set,
+// by its deprecated name.
+func (c config) deprecated() map[string]string {
+	replacements := make(map[string]string)
+	if c.DeprecatedTLSCertFile != "" {
+		replacements["RYUK_TLS_CERT_FILE"] = "RYUK_LISTENER_TLS_CERT"
+	}
+
+	if c.DeprecatedTLSKeyFile != "" {
+		replacements["RYUK_TLS_KEY_FILE"] = "RYUK_LISTENER_TLS_KEY"
+	}
+
+	return replacements
+}
+
+// validate returns an error naming the first invalid option.
+func (c config) validate() error {
+	// Durations which would spin timers or fail every request if not positive.
+	for _, option := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"RYUK_CONNECTION_TIMEOUT", c.ConnectionTimeout},
+		{"RYUK_RECONNECTION_TIMEOUT", c.ReconnectionTimeout},
+		{"RYUK_REQUEST_TIMEOUT", c.RequestTimeout},
+		{"RYUK_CHANGES_RETRY_INTERVAL", c.ChangesRetryInterval},
+		{"RYUK_RELEASE_POLL_INTERVAL", c.ReleasePollInterval},
+	} {
+		if option.value <= 0 {
+			return fmt.Errorf("%s: %w, got %s", option.name, errNotPositive, option.value)
+		}
+	}
+
+	if err := c.validatePruneOrder(); err != nil {
+		return fmt.Errorf("RYUK_PRUNE_ORDER: %w", err)
+	}
+
+	for _, health := range c.ContainerHealth {
+		if !slices.Contains(containerHealths, health) {
+			return fmt.Errorf("RYUK_CONTAINER_HEALTH: %w: %q, must be one of %s",
+				errInvalidContainerHealth, health, strings.Join(containerHealths, ","))
+		}
+	}
+
+	if c.StrictConfig {
+		return c.consistent()
+	}
+
+	return nil
+}
+
+// validatePruneOrder returns an error if the prune order doesn't list
+// each resource type exactly once.
+func (c config) validatePruneOrder() error {
+	for i, resourceType := range c.PruneOrder {
+		if !slices.Contains(pruneTypes, resourceType) {
+			return fmt.Errorf("%w: unknown type %q", errInvalidPruneOrder, resourceType)
+		}
+
+		if slices.Contains(c.PruneOrder[:i], resourceType) {
+			return fmt.Errorf("%w: duplicate type %q", errInvalidPruneOrder, resourceType)
+		}
+	}
+
+	for _, resourceType := range pruneTypes {
+		if !slices.Contains(c.PruneOrder, resourceType) {
+			return fmt.Errorf("%w: missing type %q, order must include %s", errInvalidPruneOrder, resourceType, strings.Join(pruneTypes, ","))
+		}
+	}
+
+	return nil
+}
+
+// pruneOrder returns the order resource types are removed in, the default
+// if not configured.
+func (c config) pruneOrder() []string {
+	if len(c.PruneOrder) == 0 {
+		return pruneTypes
+	}
+
+	return c.PruneOrder
+}
+
+// consistent returns an error if options are inconsistent with each other.
+func (c config) consistent() error {
+	if c.ShutdownTimeout < c.ReconnectionTimeout {
+		// A prune can be forced while clients are still reconnecting.
+		return fmt.Errorf("%w: RYUK_SHUTDOWN_TIMEOUT %s is shorter than RYUK_RECONNECTION_TIMEOUT %s",
+			errInconsistentConfig, c.ShutdownTimeout, c.ReconnectionTimeout)
+	}
+
+	return nil
+}
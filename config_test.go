@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"log/slog"
 	"os"
 	"reflect"
 	"testing"
@@ -30,14 +32,31 @@ func Test_loadConfig(t *testing.T) {
 
 	t.Run("defaults", func(t *testing.T) {
 		expected := config{
-			Port:                 8080,
-			ConnectionTimeout:    time.Minute,
-			ReconnectionTimeout:  time.Second * 10,
-			ShutdownTimeout:      time.Minute * 10,
-			RemoveRetries:        10,
-			RequestTimeout:       time.Second * 10,
-			RetryOffset:          -time.Second,
-			ChangesRetryInterval: time.Second,
+			Port:                   8080,
+			ConnectionTimeout:      time.Minute,
+			ReconnectionTimeout:    time.Second * 10,
+			ShutdownTimeout:        time.Minute * 10,
+			RemoveRetries:          10,
+			RemoveBackoff:          time.Second,
+			RequestTimeout:         time.Second * 10,
+			RetryOffset:            -time.Second,
+			ChangesRetryInterval:   time.Second,
+			ReleasePollInterval:    time.Second,
+			PruneContainers:        true,
+			PruneNetworks:          true,
+			PruneVolumes:           true,
+			PruneImages:            true,
+			RemovalInProgressWait:  time.Second * 5,
+			KeepLabel:              "org.testcontainers.keep",
+			CountUntaggedImages:    true,
+			RemoveConcurrency:      1,
+			CircuitCooldown:        time.Second * 10,
+			ReconnectRetries:       3,
+			LabelNamespace:         "org.testcontainers",
+			DeferImagesSettle:      time.Second * 10,
+			PruneOrder:             []string{"container", "network", "volume", "image"},
+			RemoveContainerVolumes: true,
+			LogMaxSize:             104857600,
 		}
 
 		cfg, err := loadConfig()
@@ -53,19 +72,195 @@ func Test_loadConfig(t *testing.T) {
 		t.Setenv("RYUK_VERBOSE", "true")
 		t.Setenv("RYUK_REQUEST_TIMEOUT", "4s")
 		t.Setenv("RYUK_REMOVE_RETRIES", "5")
+		t.Setenv("RYUK_REMOVE_BACKOFF", "250ms")
 		t.Setenv("RYUK_RETRY_OFFSET", "-6s")
 		t.Setenv("RYUK_CHANGES_RETRY_INTERVAL", "8s")
+		t.Setenv("RYUK_ORPHANS_FILE", "/tmp/orphans.json")
+		t.Setenv("RYUK_REFERENCE_COUNTING", "true")
+		t.Setenv("RYUK_MAX_MEMORY", "1073741824")
+		t.Setenv("RYUK_BUILDKIT_ADDR", "tcp://buildkitd:1234")
+		t.Setenv("RYUK_BIND_CLEANUP_ROOTS", "/tmp/a,/tmp/b")
+		t.Setenv("RYUK_MANUAL_PRUNE", "true")
+		t.Setenv("RYUK_SUMMARY_GROUP_LABEL", "project")
+		t.Setenv("RYUK_HOLD_FILE", "/tmp/ryuk.hold")
+		t.Setenv("RYUK_LISTENER_TLS_CERT", "/tmp/cert.pem")
+		t.Setenv("RYUK_LISTENER_TLS_KEY", "/tmp/key.pem")
+		t.Setenv("RYUK_TLS_AUTODETECT", "true")
+		t.Setenv("RYUK_PING_INTERVAL", "30s")
+		t.Setenv("RYUK_SELF_REMOVE", "true")
+		t.Setenv("RYUK_LOG_SAMPLE", "5")
+		t.Setenv("RYUK_SKIP_SHARED_NETWORKS", "true")
+		t.Setenv("RYUK_SHUTDOWN_BEST_EFFORT_OK", "true")
+		t.Setenv("RYUK_STOP_SIGNAL", "SIGQUIT")
+		t.Setenv("RYUK_MAX_CONCURRENT_HANDSHAKES", "4")
+		t.Setenv("RYUK_LIST_TIMEOUT_CONTAINERS", "11s")
+		t.Setenv("RYUK_LIST_TIMEOUT_NETWORKS", "12s")
+		t.Setenv("RYUK_LIST_TIMEOUT_VOLUMES", "13s")
+		t.Setenv("RYUK_LIST_TIMEOUT_IMAGES", "1m")
+		t.Setenv("RYUK_IMAGES_USE_PRUNE", "true")
+		t.Setenv("RYUK_RELEASE_FILE", "/tmp/ryuk.lock")
+		t.Setenv("RYUK_RELEASE_POLL_INTERVAL", "5s")
+		t.Setenv("RYUK_SESSION_DEADLINE", "30s")
+		t.Setenv("RYUK_PRUNE_CONTAINERS", "false")
+		t.Setenv("RYUK_PRUNE_NETWORKS", "false")
+		t.Setenv("RYUK_PRUNE_VOLUMES", "false")
+		t.Setenv("RYUK_PRUNE_IMAGES", "false")
+		t.Setenv("RYUK_AUDIT_FILE", "/tmp/audit.jsonl")
+		t.Setenv("RYUK_KEEP_ONE_PER_IMAGE", "true")
+		t.Setenv("RYUK_DRY_RUN", "true")
+		t.Setenv("RYUK_REMOVAL_IN_PROGRESS_WAIT", "2s")
+		t.Setenv("RYUK_HEALTH_PORT", "8081")
+		t.Setenv("RYUK_SCOPE_NETWORK", "tests")
+		t.Setenv("RYUK_PROTECT_SELF", "true")
+		t.Setenv("RYUK_DOCKER_HOST", "tcp://docker:2376")
+		t.Setenv("RYUK_TLS_CERT", "/certs/cert.pem")
+		t.Setenv("RYUK_TLS_KEY", "/certs/key.pem")
+		t.Setenv("RYUK_TLS_CA", "/certs/ca.pem")
+		t.Setenv("RYUK_KEEP_LABEL", "debug.keep")
+		t.Setenv("RYUK_MIN_CLIENTS", "4")
+		t.Setenv("RYUK_COUNT_UNTAGGED_IMAGES", "false")
+		t.Setenv("RYUK_REMOVE_CONCURRENCY", "8")
+		t.Setenv("RYUK_CONTAINER_HEALTH", "unhealthy,none")
+		t.Setenv("RYUK_REMOVE_CHUNK_SIZE", "100")
+		t.Setenv("RYUK_REMOVE_CHUNK_PAUSE", "2s")
+		t.Setenv("RYUK_MAINTENANCE_UNTIL", "2030-01-02T03:04:05Z")
+		t.Setenv("RYUK_FILTER_SCHEMA", "/etc/ryuk/schema.json")
+		t.Setenv("RYUK_CONNECTION_TOKEN", "secret")
+		t.Setenv("RYUK_REAP_EXIT_CODES", "0,143")
+		t.Setenv("RYUK_SHADOW_DOCKER_HOST", "tcp://shadow:2375")
+		t.Setenv("RYUK_PRUNE_BUILD_CACHE", "true")
+		t.Setenv("RYUK_REPORT_SIZES", "true")
+		t.Setenv("RYUK_SKIP_ACTIVE_EXEC", "true")
+		t.Setenv("RYUK_CIRCUIT_THRESHOLD", "5")
+		t.Setenv("RYUK_CIRCUIT_COOLDOWN", "30s")
+		t.Setenv("RYUK_STRICT_FILTERS", "true")
+		t.Setenv("RYUK_CLIENT_READ_TIMEOUT", "15s")
+		t.Setenv("RYUK_DECISION_LOG_SIZE", "100")
+		t.Setenv("RYUK_RECONNECT_RETRIES", "6")
+		t.Setenv("RYUK_LABEL_NAMESPACE", "io.podman.testing")
+		t.Setenv("RYUK_DEFER_IMAGES", "true")
+		t.Setenv("RYUK_DEFER_IMAGES_SETTLE", "20s")
+		t.Setenv("RYUK_HEARTBEAT_INTERVAL", "1m")
+		t.Setenv("RYUK_WEBHOOK_URL", "http://audit:8080/ryuk")
+		t.Setenv("RYUK_EXPECTED_DOCKER_HOST", "tcp://ci-docker:2376")
+		t.Setenv("RYUK_EXPECTED_DAEMON_LABEL", "env=ci")
+		t.Setenv("RYUK_BIND_ADDR", "127.0.0.1")
+		t.Setenv("RYUK_STRICT_CONFIG", "true")
+		t.Setenv("RYUK_REQUIRED_FILTER_LABELS", "org.testcontainers.sessionId,team")
+		t.Setenv("RYUK_MAX_FILTERS", "500")
+		t.Setenv("RYUK_COMBINED_LIST", "true")
+		t.Setenv("RYUK_REQUIRE_FIRST_CONNECTION", "true")
+		t.Setenv("RYUK_PRUNE_ORDER", "container,volume,network,image")
+		t.Setenv("RYUK_REMOVE_CONTAINER_VOLUMES", "false")
+		t.Setenv("RYUK_RECONNECTION_GRACE", "5s")
+		t.Setenv("RYUK_EXCLUDE_LABELS", "infrastructure,team=platform")
+		t.Setenv("RYUK_IMAGE_RETRY_OFFSET", "-30s")
+		t.Setenv("RYUK_INSPECT_CHANGES", "true")
+		t.Setenv("RYUK_ADMIN_PORT", "8082")
+		t.Setenv("RYUK_EMIT_READY_ENV", "true")
+		t.Setenv("RYUK_MAX_REMOVALS", "100")
+		t.Setenv("RYUK_PROGRESS_INTERVAL", "5s")
+		t.Setenv("RYUK_LOG_FILE", "/var/log/ryuk.log")
+		t.Setenv("RYUK_LOG_MAX_SIZE", "1048576")
+		t.Setenv("RYUK_OTEL_ENDPOINT", "http://localhost:4318")
+		t.Setenv("RYUK_SESSION_TTL", "1h")
+		t.Setenv("RYUK_STOP_TIMEOUT", "30s")
 
 		expected := config{
-			Port:                 1234,
-			ConnectionTimeout:    time.Second * 2,
-			ReconnectionTimeout:  time.Second * 3,
-			ShutdownTimeout:      time.Second * 7,
-			Verbose:              true,
-			RemoveRetries:        5,
-			RequestTimeout:       time.Second * 4,
-			RetryOffset:          -time.Second * 6,
-			ChangesRetryInterval: time.Second * 8,
+			Port:                    1234,
+			ConnectionTimeout:       time.Second * 2,
+			ReconnectionTimeout:     time.Second * 3,
+			ShutdownTimeout:         time.Second * 7,
+			Verbose:                 true,
+			RemoveRetries:           5,
+			RemoveBackoff:           time.Millisecond * 250,
+			RequestTimeout:          time.Second * 4,
+			RetryOffset:             -time.Second * 6,
+			ChangesRetryInterval:    time.Second * 8,
+			OrphansFile:             "/tmp/orphans.json",
+			ReferenceCounting:       true,
+			MaxMemory:               1 << 30,
+			BuildkitAddr:            "tcp://buildkitd:1234",
+			BindCleanupRoots:        []string{"/tmp/a", "/tmp/b"},
+			ManualPrune:             true,
+			SummaryGroupLabel:       "project",
+			HoldFile:                "/tmp/ryuk.hold",
+			TLSCertFile:             "/tmp/cert.pem",
+			TLSKeyFile:              "/tmp/key.pem",
+			TLSAutodetect:           true,
+			PingInterval:            time.Second * 30,
+			SelfRemove:              true,
+			LogSample:               5,
+			SkipSharedNetworks:      true,
+			ShutdownBestEffortOK:    true,
+			StopSignal:              "SIGQUIT",
+			MaxConcurrentHandshakes: 4,
+			ListTimeoutContainers:   time.Second * 11,
+			ListTimeoutNetworks:     time.Second * 12,
+			ListTimeoutVolumes:      time.Second * 13,
+			ListTimeoutImages:       time.Minute,
+			ImagesUsePrune:          true,
+			ReleaseFile:             "/tmp/ryuk.lock",
+			ReleasePollInterval:     time.Second * 5,
+			SessionDeadline:         time.Second * 30,
+			AuditFile:               "/tmp/audit.jsonl",
+			KeepOnePerImage:         true,
+			DryRun:                  true,
+			RemovalInProgressWait:   time.Second * 2,
+			HealthPort:              8081,
+			ScopeNetwork:            "tests",
+			ProtectSelf:             true,
+			DockerHost:              "tcp://docker:2376",
+			DockerTLSCert:           "/certs/cert.pem",
+			DockerTLSKey:            "/certs/key.pem",
+			DockerTLSCA:             "/certs/ca.pem",
+			KeepLabel:               "debug.keep",
+			MinClients:              4,
+			RemoveConcurrency:       8,
+			ContainerHealth:         []string{"unhealthy", "none"},
+			RemoveChunkSize:         100,
+			RemoveChunkPause:        time.Second * 2,
+			MaintenanceUntil:        time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC),
+			FilterSchema:            "/etc/ryuk/schema.json",
+			ConnectionToken:         "secret",
+			ReapExitCodes:           []int{0, 143},
+			ShadowDockerHost:        "tcp://shadow:2375",
+			PruneBuildCache:         true,
+			ReportSizes:             true,
+			SkipActiveExec:          true,
+			CircuitThreshold:        5,
+			CircuitCooldown:         time.Second * 30,
+			StrictFilters:           true,
+			ClientReadTimeout:       time.Second * 15,
+			DecisionLogSize:         100,
+			ReconnectRetries:        6,
+			LabelNamespace:          "io.podman.testing",
+			DeferImages:             true,
+			DeferImagesSettle:       time.Second * 20,
+			HeartbeatInterval:       time.Minute,
+			WebhookURL:              "http://audit:8080/ryuk",
+			ExpectedDockerHost:      "tcp://ci-docker:2376",
+			ExpectedDaemonLabel:     "env=ci",
+			BindAddr:                "127.0.0.1",
+			StrictConfig:            true,
+			RequiredFilterLabels:    []string{"org.testcontainers.sessionId", "team"},
+			MaxFilters:              500,
+			CombinedList:            true,
+			RequireFirstConnection:  true,
+			PruneOrder:              []string{"container", "volume", "network", "image"},
+			ReconnectionGrace:       time.Second * 5,
+			ExcludeLabels:           []string{"infrastructure", "team=platform"},
+			ImageRetryOffset:        -time.Second * 30,
+			InspectChanges:          true,
+			AdminPort:               8082,
+			EmitReadyEnv:            true,
+			MaxRemovals:             100,
+			ProgressInterval:        time.Second * 5,
+			LogFile:                 "/var/log/ryuk.log",
+			LogMaxSize:              1048576,
+			OtelEndpoint:            "http://localhost:4318",
+			SessionTTL:              time.Hour,
+			StopTimeout:             time.Second * 30,
 		}
 
 		cfg, err := loadConfig()
@@ -81,7 +276,66 @@ func Test_loadConfig(t *testing.T) {
 		"RYUK_VERBOSE",
 		"RYUK_REQUEST_TIMEOUT",
 		"RYUK_REMOVE_RETRIES",
+		"RYUK_REMOVE_BACKOFF",
 		"RYUK_RETRY_OFFSET",
+		"RYUK_MAX_MEMORY",
+		"RYUK_TLS_AUTODETECT",
+		"RYUK_PING_INTERVAL",
+		"RYUK_SELF_REMOVE",
+		"RYUK_LOG_SAMPLE",
+		"RYUK_SKIP_SHARED_NETWORKS",
+		"RYUK_SHUTDOWN_BEST_EFFORT_OK",
+		"RYUK_MAX_CONCURRENT_HANDSHAKES",
+		"RYUK_LIST_TIMEOUT_CONTAINERS",
+		"RYUK_LIST_TIMEOUT_NETWORKS",
+		"RYUK_LIST_TIMEOUT_VOLUMES",
+		"RYUK_LIST_TIMEOUT_IMAGES",
+		"RYUK_IMAGES_USE_PRUNE",
+		"RYUK_RELEASE_POLL_INTERVAL",
+		"RYUK_SESSION_DEADLINE",
+		"RYUK_PRUNE_CONTAINERS",
+		"RYUK_PRUNE_NETWORKS",
+		"RYUK_PRUNE_VOLUMES",
+		"RYUK_PRUNE_IMAGES",
+		"RYUK_KEEP_ONE_PER_IMAGE",
+		"RYUK_DRY_RUN",
+		"RYUK_REMOVAL_IN_PROGRESS_WAIT",
+		"RYUK_HEALTH_PORT",
+		"RYUK_PROTECT_SELF",
+		"RYUK_MIN_CLIENTS",
+		"RYUK_COUNT_UNTAGGED_IMAGES",
+		"RYUK_REMOVE_CONCURRENCY",
+		"RYUK_REMOVE_CHUNK_SIZE",
+		"RYUK_REMOVE_CHUNK_PAUSE",
+		"RYUK_MAINTENANCE_UNTIL",
+		"RYUK_REAP_EXIT_CODES",
+		"RYUK_PRUNE_BUILD_CACHE",
+		"RYUK_REPORT_SIZES",
+		"RYUK_SKIP_ACTIVE_EXEC",
+		"RYUK_CIRCUIT_THRESHOLD",
+		"RYUK_CIRCUIT_COOLDOWN",
+		"RYUK_STRICT_FILTERS",
+		"RYUK_CLIENT_READ_TIMEOUT",
+		"RYUK_DECISION_LOG_SIZE",
+		"RYUK_RECONNECT_RETRIES",
+		"RYUK_DEFER_IMAGES",
+		"RYUK_DEFER_IMAGES_SETTLE",
+		"RYUK_HEARTBEAT_INTERVAL",
+		"RYUK_STRICT_CONFIG",
+		"RYUK_MAX_FILTERS",
+		"RYUK_COMBINED_LIST",
+		"RYUK_REQUIRE_FIRST_CONNECTION",
+		"RYUK_REMOVE_CONTAINER_VOLUMES",
+		"RYUK_RECONNECTION_GRACE",
+		"RYUK_IMAGE_RETRY_OFFSET",
+		"RYUK_INSPECT_CHANGES",
+		"RYUK_ADMIN_PORT",
+		"RYUK_EMIT_READY_ENV",
+		"RYUK_MAX_REMOVALS",
+		"RYUK_PROGRESS_INTERVAL",
+		"RYUK_LOG_MAX_SIZE",
+		"RYUK_SESSION_TTL",
+		"RYUK_STOP_TIMEOUT",
 	} {
 		t.Run("invalid-"+name, func(t *testing.T) {
 			t.Setenv(name, "invalid")
@@ -89,4 +343,121 @@ func Test_loadConfig(t *testing.T) {
 			require.Error(t, err)
 		})
 	}
+
+	for _, name := range []string{
+		"RYUK_CONNECTION_TIMEOUT",
+		"RYUK_RECONNECTION_TIMEOUT",
+		"RYUK_REQUEST_TIMEOUT",
+		"RYUK_CHANGES_RETRY_INTERVAL",
+		"RYUK_RELEASE_POLL_INTERVAL",
+	} {
+		for _, value := range []string{"0s", "-1s"} {
+			t.Run("not-positive-"+name+value, func(t *testing.T) {
+				t.Setenv(name, value)
+				_, err := loadConfig()
+				require.ErrorIs(t, err, errNotPositive)
+				require.EqualError(t, err, "validate: "+name+": must be positive, got "+value)
+			})
+		}
+	}
+
+	for value, want := range map[string]string{
+		"container,network,volume,image,pod":     `unknown type "pod"`,
+		"container,network,volume":               `missing type "image", order must include container,network,volume,image`,
+		"container,network,volume,image,network": `duplicate type "network"`,
+	} {
+		t.Run("invalid-prune-order-"+value, func(t *testing.T) {
+			t.Setenv("RYUK_PRUNE_ORDER", value)
+			_, err := loadConfig()
+			require.ErrorIs(t, err, errInvalidPruneOrder)
+			require.EqualError(t, err, "validate: RYUK_PRUNE_ORDER: invalid prune order: "+want)
+		})
+	}
+
+	t.Run("invalid-container-health", func(t *testing.T) {
+		t.Setenv("RYUK_CONTAINER_HEALTH", "unhealthy,dead")
+		_, err := loadConfig()
+		require.ErrorIs(t, err, errInvalidContainerHealth)
+		require.EqualError(t, err, `validate: RYUK_CONTAINER_HEALTH: invalid container health: "dead", must be one of starting,healthy,unhealthy,none`)
+	})
+
+	t.Run("deprecated-tls", func(t *testing.T) {
+		t.Setenv("RYUK_TLS_CERT_FILE", "/tmp/cert.pem")
+		t.Setenv("RYUK_TLS_KEY_FILE", "/tmp/key.pem")
+		cfg, err := loadConfig()
+		require.NoError(t, err)
+		require.Equal(t, "/tmp/cert.pem", cfg.TLSCertFile)
+		require.Equal(t, "/tmp/key.pem", cfg.TLSKeyFile)
+		require.Equal(t, map[string]string{
+			"RYUK_TLS_CERT_FILE": "RYUK_LISTENER_TLS_CERT",
+			"RYUK_TLS_KEY_FILE":  "RYUK_LISTENER_TLS_KEY",
+		}, cfg.deprecated())
+
+		t.Setenv("RYUK_LISTENER_TLS_CERT", "/tmp/listener-cert.pem")
+		t.Setenv("RYUK_LISTENER_TLS_KEY", "/tmp/listener-key.pem")
+		cfg, err = loadConfig()
+		require.NoError(t, err)
+		require.Equal(t, "/tmp/listener-cert.pem", cfg.TLSCertFile)
+		require.Equal(t, "/tmp/listener-key.pem", cfg.TLSKeyFile)
+	})
+}
+
+func Test_configConsistent(t *testing.T) {
+	t.Run("consistent", func(t *testing.T) {
+		t.Setenv("RYUK_STRICT_CONFIG", "true")
+		t.Setenv("RYUK_SHUTDOWN_TIMEOUT", "10s")
+		t.Setenv("RYUK_RECONNECTION_TIMEOUT", "10s")
+		cfg, err := loadConfig()
+		require.NoError(t, err)
+		require.NoError(t, cfg.consistent())
+	})
+
+	t.Run("inconsistent", func(t *testing.T) {
+		t.Setenv("RYUK_SHUTDOWN_TIMEOUT", "5s")
+		t.Setenv("RYUK_RECONNECTION_TIMEOUT", "10s")
+		cfg, err := loadConfig()
+		require.NoError(t, err)
+		require.EqualError(t, cfg.consistent(), "inconsistent config: RYUK_SHUTDOWN_TIMEOUT 5s is shorter than RYUK_RECONNECTION_TIMEOUT 10s")
+
+		// Logged as a warning when not strict.
+		var log safeBuffer
+		cfg.Port = 0
+		r, err := newReaper(context.Background(),
+			withConfig(*cfg),
+			withClient(newListMockClient(nil)),
+			withLogger(slog.New(slog.NewTextHandler(&log, nil))),
+		)
+		require.NoError(t, err)
+		t.Cleanup(func() { r.listener.Close() })
+		require.Contains(t, log.String(), `level=WARN msg=config error="inconsistent config: RYUK_SHUTDOWN_TIMEOUT 5s`)
+	})
+
+	t.Run("inconsistent-strict", func(t *testing.T) {
+		t.Setenv("RYUK_STRICT_CONFIG", "true")
+		t.Setenv("RYUK_SHUTDOWN_TIMEOUT", "5s")
+		t.Setenv("RYUK_RECONNECTION_TIMEOUT", "10s")
+		_, err := loadConfig()
+		require.ErrorIs(t, err, errInconsistentConfig)
+	})
+}
+
+func Test_listTimeout(t *testing.T) {
+	cfg := config{RequestTimeout: time.Second * 10, ListTimeoutImages: time.Minute}
+	require.Equal(t, time.Minute, cfg.listTimeout(cfg.ListTimeoutImages))
+	require.Equal(t, time.Second*10, cfg.listTimeout(cfg.ListTimeoutNetworks))
+
+	attrs := make(map[string]slog.Value)
+	for _, attr := range cfg.LogAttrs() {
+		attrs[attr.Key] = attr.Value
+	}
+	require.Equal(t, time.Minute, attrs["list_timeout_images"].Duration())
+	require.Equal(t, time.Second*10, attrs["list_timeout_networks"].Duration())
+}
+
+func Test_retryOffset(t *testing.T) {
+	cfg := config{RetryOffset: -time.Second}
+	require.Equal(t, -time.Second, cfg.retryOffset(cfg.ImageRetryOffset))
+
+	cfg.ImageRetryOffset = -time.Minute
+	require.Equal(t, -time.Minute, cfg.retryOffset(cfg.ImageRetryOffset))
 }
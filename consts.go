@@ -1,11 +1,17 @@
 package main
 
 const (
-	// labelBase is the base label for testcontainers.
-	labelBase = "org.testcontainers"
+	// ryukLabelSuffix is appended to the label namespace to form the
+	// label used to identify reaper containers.
+	ryukLabelSuffix = ".ryuk"
 
-	// ryukLabel is the label used to identify reaper containers.
-	ryukLabel = labelBase + ".ryuk"
+	// sessionIDLabelSuffix is appended to the label namespace to form the
+	// label used to identify the session a resource belongs to.
+	sessionIDLabelSuffix = ".sessionId"
+
+	// minAPIVersion is the minimum Docker API version known to reliably
+	// support label filters on images.
+	minAPIVersion = "1.25"
 
 	// fieldError is the log field key for errors.
 	fieldError = "error"
@@ -15,4 +21,22 @@ const (
 
 	// fieldClients is the log field used for client counts.
 	fieldClients = "clients"
+
+	// exitFailure is the exit code used for failures without a more
+	// specific code, such as a failed prune.
+	exitFailure = 1
+
+	// exitConfig is the exit code used when the config is invalid.
+	exitConfig = 2
+
+	// exitConnectivity is the exit code used when the Docker daemon
+	// can't be reached.
+	exitConnectivity = 3
+
+	// exitListen is the exit code used when the listener can't be bound.
+	exitListen = 4
+
+	// exitNoConnection is the exit code used when no client connected
+	// and a first connection is required.
+	exitNoConnection = 5
 )
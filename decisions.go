@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Decisions recorded in the decision log.
+const (
+	decisionMatched = "matched"
+	decisionSkipped = "skipped"
+	decisionRemoved = "removed"
+	decisionFailed  = "failed"
+)
+
+// decisionReasonChanged is the reason resources created after the prune
+// started are skipped.
+const decisionReasonChanged = "created after prune started"
+
+// decisionsPath is the path of the endpoint which returns the decision log.
+const decisionsPath = "/decisions"
+
+// decision is a prune decision made about a resource.
+type decision struct {
+	// Time is when the decision was made.
+	Time time.Time `json:"time"`
+
+	// Resource is the type of the resource.
+	Resource string `json:"resource"`
+
+	// ID is the ID, or name for volumes, of the resource.
+	ID string `json:"id"`
+
+	// Decision is what was decided.
+	Decision string `json:"decision"`
+
+	// Reason is why, if not implied by the decision.
+	Reason string `json:"reason,omitempty"`
+}
+
+// decisionLog is a fixed size ring buffer of the most recent decisions.
+type decisionLog struct {
+	entries []decision
+	next    int
+	full    bool
+	mtx     sync.Mutex
+}
+
+// newDecisionLog returns a decision log holding the last size decisions,
+// or nil if size isn't positive.
+func newDecisionLog(size int) *decisionLog {
+	if size <= 0 {
+		return nil
+	}
+
+	return &decisionLog{entries: make([]decision, size)}
+}
+
+// add adds d to the log, replacing the oldest decision if full.
+// Safe to call concurrently and on a nil log.
+func (l *decisionLog) add(d decision) {
+	if l == nil {
+		return
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.entries[l.next] = d
+	l.next++
+	if l.next == len(l.entries) {
+		l.next = 0
+		l.full = true
+	}
+}
+
+// list returns the decisions in the log, oldest first.
+// Safe to call concurrently.
+func (l *decisionLog) list() []decision {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if !l.full {
+		return append([]decision{}, l.entries[:l.next]...)
+	}
+
+	return append(append([]decision{}, l.entries[l.next:]...), l.entries[:l.next]...)
+}
+
+// decide records a decision about the resource id, if the decision log is enabled.
+func (r *reaper) decide(resourceType, id, what, reason string) {
+	r.decisions.add(decision{
+		Time:     time.Now(),
+		Resource: resourceType,
+		ID:       id,
+		Decision: what,
+		Reason:   reason,
+	})
+}
+
+// handleDecisions returns the decision log as a JSON array, oldest first,
+// so an operator can see recent decisions without verbose logging.
+func (r *reaper) handleDecisions(w http.ResponseWriter, _ *http.Request) {
+	if r.decisions == nil {
+		http.Error(w, "decision log disabled", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(r.decisions.list()); err != nil {
+		r.logger.Error("decisions write", fieldError, err)
+	}
+}
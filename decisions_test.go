@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecisionLog(t *testing.T) {
+	log := newDecisionLog(3)
+	for _, id := range []string{"1", "2"} {
+		log.add(decision{ID: id})
+	}
+	require.Equal(t, []decision{{ID: "1"}, {ID: "2"}}, log.list())
+
+	// Once full the oldest decisions are replaced.
+	for _, id := range []string{"3", "4", "5"} {
+		log.add(decision{ID: id})
+	}
+	require.Equal(t, []decision{{ID: "3"}, {ID: "4"}, {ID: "5"}}, log.list())
+
+	t.Run("disabled", func(t *testing.T) {
+		var log *decisionLog
+		require.Nil(t, newDecisionLog(0))
+		log.add(decision{ID: "1"})
+	})
+}
+
+func TestDecisions(t *testing.T) {
+	since := time.Now()
+	created := since.Add(-time.Minute).Unix()
+	args := filterArgs(testLabels1)
+	cli := newListMockClient(map[*filters.Args][]types.Container{
+		&args: {
+			{ID: "kept", Created: created, Labels: map[string]string{"keep": "true"}},
+			{ID: "changed", Created: since.Add(time.Minute).Unix()},
+			{ID: "removed", Created: created},
+			{ID: "failed", Created: created},
+		},
+	})
+
+	cfg := testConfigBase
+	cfg.KeepLabel = "keep"
+	cfg.DecisionLogSize = 10
+	r := &reaper{
+		cfg:       &cfg,
+		client:    cli,
+		logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+		decisions: newDecisionLog(cfg.DecisionLogSize),
+	}
+
+	ids, _, err := r.affectedContainers(context.Background(), since, args, nil, nil)
+	require.ErrorIs(t, err, errChangesDetected)
+
+	var count atomic.Int64
+	err = r.remove(context.Background(), "container", ids, &count, func(_ context.Context, id string) error {
+		if id == "failed" {
+			return errors.New("in use")
+		}
+		return nil
+	})
+	require.Error(t, err)
+
+	srv := httptest.NewServer(http.HandlerFunc(r.handleDecisions))
+	t.Cleanup(srv.Close)
+
+	resp, err := srv.Client().Get(srv.URL + decisionsPath)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var decisions []decision
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&decisions))
+	for i := range decisions {
+		require.False(t, decisions[i].Time.IsZero())
+		require.Equal(t, "container", decisions[i].Resource)
+		decisions[i].Time = time.Time{}
+		decisions[i].Resource = ""
+	}
+	require.Equal(t, []decision{
+		{ID: "kept", Decision: decisionSkipped, Reason: "keep label"},
+		{ID: "changed", Decision: decisionSkipped, Reason: decisionReasonChanged},
+		{ID: "removed", Decision: decisionMatched},
+		{ID: "failed", Decision: decisionMatched},
+		{ID: "removed", Decision: decisionRemoved},
+		{ID: "failed", Decision: decisionFailed, Reason: "in use"},
+	}, decisions)
+
+	t.Run("disabled", func(t *testing.T) {
+		r.decisions = nil
+		resp, err := srv.Client().Get(srv.URL + decisionsPath)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
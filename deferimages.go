@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+)
+
+// deferImages moves the images of res, including those of its sessions,
+// to the images deferred until the final prune, if images are deferred.
+// Must be called with pruneMtx held.
+func (r *reaper) deferImages(res *resources) {
+	if !r.cfg.DeferImages || len(res.images) == 0 {
+		return
+	}
+
+	if r.deferredImages == nil {
+		r.deferredImages = &resources{groups: make(map[string]string), sizes: make(map[string]int64), sessionIDs: make(map[string]string)}
+	}
+
+	r.logger.Info("images deferred", "images", len(res.images))
+	r.deferredImages.images = append(r.deferredImages.images, res.images...)
+	r.deferredImages.filters = append(r.deferredImages.filters, res.filters...)
+	maps.Copy(r.deferredImages.groups, res.groups)
+	maps.Copy(r.deferredImages.sizes, res.sizes)
+	maps.Copy(r.deferredImages.sessionIDs, res.sessionIDs)
+
+	// Images are only pruned if created before the earliest listing.
+	if r.deferredImages.since.IsZero() || res.since.Before(r.deferredImages.since) {
+		r.deferredImages.since = res.since
+	}
+
+	res.images = nil
+	for _, session := range res.sessions {
+		session.images = nil
+	}
+}
+
+// takeDeferredImages defers the images of res and returns all the
+// deferred images, or nil if there are none.
+// Must be called with pruneMtx held.
+func (r *reaper) takeDeferredImages(res *resources) *resources {
+	r.deferImages(res)
+	images := r.deferredImages
+	r.deferredImages = nil
+
+	return images
+}
+
+// pruneDeferredImages waits for the settle duration then removes the
+// deferred images, once all other resources have been removed.
+// The wait and removal are bounded by ctx.
+func (r *reaper) pruneDeferredImages(ctx context.Context, images *resources) error {
+	if images == nil || r.holding() {
+		return nil
+	}
+
+	r.logger.Info("removing deferred images", "images", len(images.images), "settle", r.cfg.DeferImagesSettle)
+	if err := sleepContext(ctx, r.cfg.DeferImagesSettle); err != nil {
+		r.logger.Warn("deferred images abandoned", "images", len(images.images), fieldError, err)
+		return fmt.Errorf("settle: %w", err)
+	}
+
+	counts := removeCounts{ids: r.newRemovedIDs()}
+	summary := newGroupSummary(images.groups)
+	errs, err := r.removePass(ctx, images, &counts, summary)
+	if err != nil {
+		return err
+	}
+
+	r.logger.Info("removed deferred images", counts.logAttrs(r.cfg.ReportSizes)...)
+	r.logAccounting("image", images.images, counts.images.Load(), errs)
+	summary.log(r.logger, r.cfg.SummaryGroupLabel)
+	r.notifyWebhook(images.filters, &counts)
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeferImages(t *testing.T) {
+	var delays []time.Duration
+	orig := sleepContext
+	t.Cleanup(func() { sleepContext = orig })
+	sleepContext = func(_ context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+
+	args1 := filterArgs(testLabels1)
+	args2 := filterArgs(testLabels2)
+
+	cli := &mockClient{}
+	cli.On("ImageRemove", mockContext, imageID1, imageRemoveOptions).
+		Return([]image.DeleteResponse{{Deleted: imageID1}}, nil)
+	cli.On("ImageRemove", mockContext, imageID2, imageRemoveOptions).
+		Return([]image.DeleteResponse{{Deleted: imageID2}}, nil)
+
+	var log safeBuffer
+	cfg := testConfigBase
+	cfg.DeferImages = true
+	cfg.DeferImagesSettle = time.Second * 5
+	r := &reaper{
+		cfg:    &cfg,
+		client: cli,
+		logger: slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	}
+
+	// Images of each prune accumulate, other resources are left.
+	first := &resources{containers: []string{containerID1}, images: []string{imageID1}, filters: []filters.Args{args1}}
+	r.deferImages(first)
+	require.Equal(t, []string{containerID1}, first.containers)
+	require.Empty(t, first.images)
+
+	session := &resources{images: []string{imageID2}, filters: []filters.Args{args2}}
+	second := &resources{images: []string{imageID2}, filters: []filters.Args{args2}, sessions: []*resources{session}}
+	r.deferImages(second)
+	require.Empty(t, second.images)
+	require.Empty(t, session.images)
+
+	// The final prune takes all the deferred images, including its own.
+	final := &resources{images: []string{imageID1}, filters: []filters.Args{args1}}
+	images := r.takeDeferredImages(final)
+	require.Empty(t, final.images)
+	require.Equal(t, []string{imageID1, imageID2, imageID1}, images.images)
+	require.Equal(t, []filters.Args{args1, args2, args1}, images.filters)
+	require.Nil(t, r.deferredImages)
+
+	// Each image is removed once, after the settle duration.
+	require.NoError(t, r.pruneDeferredImages(context.Background(), images))
+	require.Equal(t, []time.Duration{cfg.DeferImagesSettle}, delays)
+	cli.AssertNumberOfCalls(t, "ImageRemove", 2)
+	require.Contains(t, log.String(), `msg="removed deferred images" containers=0 networks=0 volumes=0 images=2`)
+
+	t.Run("none", func(t *testing.T) {
+		delays = nil
+		require.Nil(t, r.takeDeferredImages(&resources{}))
+		require.NoError(t, r.pruneDeferredImages(context.Background(), nil))
+		require.Empty(t, delays)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		cfg.DeferImages = false
+		res := &resources{images: []string{imageID1}}
+		r.deferImages(res)
+		require.Equal(t, []string{imageID1}, res.images)
+		require.Nil(t, r.deferredImages)
+	})
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+var (
+	// errUnexpectedDockerHost is returned if the Docker host isn't the expected one.
+	errUnexpectedDockerHost = errors.New("unexpected docker host")
+
+	// errDaemonLabelMissing is returned if the daemon doesn't have the expected label.
+	errDaemonLabelMissing = errors.New("daemon label missing")
+)
+
+// checkEnvironment verifies the Docker host and daemon are the expected
+// ones, if configured, so a misconfigured reaper can't prune resources
+// of the wrong environment.
+func (r *reaper) checkEnvironment(ctx context.Context) error {
+	if r.cfg.ExpectedDockerHost != "" {
+		if host := r.client.DaemonHost(); host != r.cfg.ExpectedDockerHost {
+			return fmt.Errorf("%w: %q, expected %q", errUnexpectedDockerHost, host, r.cfg.ExpectedDockerHost)
+		}
+	}
+
+	if r.cfg.ExpectedDaemonLabel == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.RequestTimeout)
+	defer cancel()
+
+	info, err := r.client.Info(ctx)
+	if err != nil {
+		return fmt.Errorf("info: %w", err)
+	}
+
+	if !slices.Contains(info.Labels, r.cfg.ExpectedDaemonLabel) {
+		return fmt.Errorf("%w: %q, daemon %q has %q", errDaemonLabelMissing, r.cfg.ExpectedDaemonLabel, info.Name, info.Labels)
+	}
+
+	return nil
+}
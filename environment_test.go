@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/system"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckEnvironment(t *testing.T) {
+	const host = "tcp://ci-docker:2376"
+	info := system.Info{Name: "ci", Labels: []string{"env=ci", "region=eu"}}
+
+	tests := map[string]struct {
+		expectedHost  string
+		expectedLabel string
+		infoErr       error
+		err           error
+		msg           string
+	}{
+		"disabled": {},
+		"host-match": {
+			expectedHost: host,
+		},
+		"host-mismatch": {
+			expectedHost: "unix:///var/run/docker.sock",
+			err:          errUnexpectedDockerHost,
+			msg:          `unexpected docker host: "tcp://ci-docker:2376", expected "unix:///var/run/docker.sock"`,
+		},
+		"label-match": {
+			expectedHost:  host,
+			expectedLabel: "env=ci",
+		},
+		"label-mismatch": {
+			expectedLabel: "env=dev",
+			err:           errDaemonLabelMissing,
+			msg:           `daemon label missing: "env=dev", daemon "ci" has ["env=ci" "region=eu"]`,
+		},
+		"info-error": {
+			expectedLabel: "env=ci",
+			infoErr:       errors.New("connection refused"),
+			msg:           "info: connection refused",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cli := &mockClient{}
+			cli.On("DaemonHost").Return(host)
+			cli.On("Info", mockContext).Return(info, tc.infoErr)
+
+			cfg := testConfigBase
+			cfg.ExpectedDockerHost = tc.expectedHost
+			cfg.ExpectedDaemonLabel = tc.expectedLabel
+			r := &reaper{cfg: &cfg, client: cli}
+
+			err := r.checkEnvironment(context.Background())
+			if tc.msg == "" {
+				require.NoError(t, err)
+				return
+			}
+
+			require.EqualError(t, err, tc.msg)
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err)
+			}
+		})
+	}
+
+	t.Run("new-reaper", func(t *testing.T) {
+		cli := &mockClient{}
+		cli.On("NegotiateAPIVersion", mockContext).Return()
+		cli.On("ClientVersion").Return("1.47")
+		cli.On("Ping", mockContext).Return(types.Ping{}, nil)
+		cli.On("DaemonHost").Return(host)
+
+		cfg := testConfigBase
+		cfg.ExpectedDockerHost = "unix:///var/run/docker.sock"
+		_, err := newReaper(context.Background(), withConfig(cfg), withClient(cli), discardLogger)
+		require.ErrorIs(t, err, errUnexpectedDockerHost)
+	})
+}
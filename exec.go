@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/errdefs"
+)
+
+// activeExec returns true if c has active exec sessions, such as an engineer
+// debugging it, so must not be removed. Only running containers can have
+// active exec sessions, so others aren't inspected.
+func (r *reaper) activeExec(ctx context.Context, c types.Container) bool {
+	if !r.cfg.SkipActiveExec || c.State != "running" {
+		return false
+	}
+
+	execIDs, err := r.execIDs(ctx, c.ID)
+	switch {
+	case errdefs.IsNotFound(err):
+		// Already gone, removal will handle it.
+		return false
+	case err != nil:
+		// Keep it, as it may be in use.
+		r.logger.Warn("exec sessions unknown, skipping container", "id", c.ID, fieldError, err)
+		r.decide("container", c.ID, decisionSkipped, "exec sessions unknown")
+		return true
+	case len(execIDs) > 0:
+		r.logger.Info("skipping container with active exec sessions", "id", c.ID, "exec_ids", execIDs)
+		r.decide("container", c.ID, decisionSkipped, "active exec sessions")
+		return true
+	default:
+		return false
+	}
+}
+
+// execIDs returns the IDs of the exec sessions of the container id.
+func (r *reaper) execIDs(ctx context.Context, id string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.RequestTimeout)
+	defer cancel()
+
+	info, err := r.docker().ContainerInspect(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("container inspect: %w", err)
+	}
+
+	return info.ExecIDs, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSkipActiveExec(t *testing.T) {
+	since := time.Now()
+	created := since.Add(-time.Minute).Unix()
+	args := filterArgs(testLabels1)
+	cli := newListMockClient(map[*filters.Args][]types.Container{
+		&args: {
+			{ID: "exec", Created: created, State: "running"},
+			{ID: "idle", Created: created, State: "running"},
+			{ID: "gone", Created: created, State: "running"},
+			{ID: "error", Created: created, State: "running"},
+			{ID: "exited", Created: created, State: "exited"},
+		},
+	})
+	cli.On("ContainerInspect", mockContext, "exec").Return(types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{ExecIDs: []string{"exec1"}},
+	}, nil)
+	cli.On("ContainerInspect", mockContext, "idle").Return(types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{},
+	}, nil)
+	cli.On("ContainerInspect", mockContext, "gone").Return(types.ContainerJSON{}, errNotFound)
+	cli.On("ContainerInspect", mockContext, "error").Return(types.ContainerJSON{}, errors.New("timeout"))
+
+	var log safeBuffer
+	cfg := testConfigBase
+	cfg.SkipActiveExec = true
+	r := &reaper{
+		cfg:    &cfg,
+		client: cli,
+		logger: slog.New(slog.NewTextHandler(&log, nil)),
+	}
+
+	ids, _, err := r.affectedContainers(context.Background(), since, args, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"idle", "gone", "exited"}, ids)
+	require.Contains(t, log.String(), `level=INFO msg="skipping container with active exec sessions" id=exec exec_ids=[exec1]`)
+	require.Contains(t, log.String(), `level=WARN msg="exec sessions unknown, skipping container" id=error`)
+
+	// Containers which aren't running aren't inspected.
+	cli.AssertNotCalled(t, "ContainerInspect", mockContext, "exited")
+
+	t.Run("disabled", func(t *testing.T) {
+		cfg.SkipActiveExec = false
+		ids, _, err := r.affectedContainers(context.Background(), since, args, nil, nil)
+		require.NoError(t, err)
+		require.Equal(t, []string{"exec", "idle", "gone", "error", "exited"}, ids)
+	})
+}
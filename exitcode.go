@@ -0,0 +1,54 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+
+	"github.com/docker/docker/api/types"
+)
+
+// exitedStatus matches the exit code in the status of an exited container,
+// for example "Exited (137) 5 minutes ago".
+var exitedStatus = regexp.MustCompile(`^Exited \((-?\d+)\)`) //nolint:gochecknoglobals // Compiled once.
+
+// containerExitCode returns the exit code of c, parsed from its status.
+// It returns false if c hasn't exited or the code can't be determined.
+func containerExitCode(c types.Container) (int, bool) {
+	match := exitedStatus.FindStringSubmatch(c.Status)
+	if match == nil {
+		return 0, false
+	}
+
+	code, err := strconv.Atoi(match[1])
+	if err != nil {
+		return 0, false
+	}
+
+	return code, true
+}
+
+// reapExitCode returns true if c can be removed based on its exit code.
+// Containers which haven't exited can always be removed, exited ones only
+// if their exit code is configured, so failed containers can be inspected.
+func (r *reaper) reapExitCode(c types.Container) bool {
+	if len(r.cfg.ReapExitCodes) == 0 || c.State != "exited" {
+		return true
+	}
+
+	code, ok := containerExitCode(c)
+	if !ok {
+		// Keep it, as it may have failed.
+		r.logger.Warn("unknown exit code, skipping container", "id", c.ID, "status", c.Status)
+		r.decide("container", c.ID, decisionSkipped, "unknown exit code")
+		return false
+	}
+
+	if !slices.Contains(r.cfg.ReapExitCodes, code) {
+		r.logger.Debug("skipping container by exit code", "id", c.ID, "exit_code", code)
+		r.decide("container", c.ID, decisionSkipped, "exit code "+strconv.Itoa(code))
+		return false
+	}
+
+	return true
+}
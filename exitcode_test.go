@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_containerExitCode(t *testing.T) {
+	tests := map[string]struct {
+		status string
+		code   int
+		ok     bool
+	}{
+		"success":  {status: "Exited (0) 5 minutes ago", code: 0, ok: true},
+		"killed":   {status: "Exited (137) About an hour ago", code: 137, ok: true},
+		"negative": {status: "Exited (-1) 2 seconds ago", code: -1, ok: true},
+		"running":  {status: "Up 5 minutes"},
+		"created":  {status: "Created"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			code, ok := containerExitCode(types.Container{Status: tc.status})
+			require.Equal(t, tc.ok, ok)
+			require.Equal(t, tc.code, code)
+		})
+	}
+}
+
+func TestReapExitCodes(t *testing.T) {
+	since := time.Now()
+	created := since.Add(-time.Minute).Unix()
+	args := filterArgs(testLabels1)
+	cli := newListMockClient(map[*filters.Args][]types.Container{
+		&args: {
+			{ID: "success", Created: created, State: "exited", Status: "Exited (0) 1 minute ago"},
+			{ID: "failed", Created: created, State: "exited", Status: "Exited (1) 1 minute ago"},
+			{ID: "unknown", Created: created, State: "exited", Status: "Exited"},
+			{ID: "running", Created: created, State: "running", Status: "Up 1 minute"},
+		},
+	})
+
+	cfg := testConfigBase
+	cfg.ReapExitCodes = []int{0}
+	r := &reaper{
+		cfg:    &cfg,
+		client: cli,
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	ids, _, err := r.affectedContainers(context.Background(), since, args, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"success", "running"}, ids)
+
+	t.Run("disabled", func(t *testing.T) {
+		cfg.ReapExitCodes = nil
+		ids, _, err := r.affectedContainers(context.Background(), since, args, nil, nil)
+		require.NoError(t, err)
+		require.Equal(t, []string{"success", "failed", "unknown", "running"}, ids)
+	})
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/docker/docker/api/types/filters"
+)
+
+// errFilterTypeUnsupported is returned by addFilter if a filter uses a type
+// which isn't supported when listing any resource type.
+var errFilterTypeUnsupported = errors.New("filter type unsupported")
+
+// supportedFilterTypes are the filter types which clients can register,
+// by the resource type whose list supports them.
+var supportedFilterTypes = map[string][]string{ //nolint:gochecknoglobals // Constant lookup.
+	"container": {"label", "name", "id"},
+	"network":   {"label", "name", "id"},
+	"volume":    {"label", "name"},
+	"image":     {"label"},
+}
+
+// validateFilterTypes returns an error wrapping errFilterTypeUnsupported if
+// query uses a filter type which isn't supported by any resource type.
+func validateFilterTypes(query map[string][]string) error {
+	for filterType := range query {
+		supported := false
+		for _, types := range supportedFilterTypes {
+			if slices.Contains(types, filterType) {
+				supported = true
+				break
+			}
+		}
+
+		if !supported {
+			return fmt.Errorf("type %q: %w", filterType, errFilterTypeUnsupported)
+		}
+	}
+
+	return nil
+}
+
+// filterSupported returns true if all the filter types of args are supported
+// when listing resourceType. Resources of types which don't support a filter
+// type can't match it, and listing them would fail, so aren't listed.
+func (r *reaper) filterSupported(resourceType string, args filters.Args) bool {
+	for _, filterType := range args.Keys() {
+		if !slices.Contains(supportedFilterTypes[resourceType], filterType) {
+			r.logger.Debug("skipping listing, filter type unsupported", "resource", resourceType, "type", filterType)
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterTypes(t *testing.T) {
+	since := time.Now()
+	created := since.Add(-time.Minute)
+
+	tests := map[string]struct {
+		filterType string
+		value      string
+		volumes    bool
+	}{
+		"name": {filterType: "name", value: "legacy-", volumes: true},
+		"id":   {filterType: "id", value: "1234"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			args := filters.NewArgs(filters.Arg(tc.filterType, tc.value))
+
+			// Images don't support these filter types, so ImageList
+			// isn't mocked and would fail the test if called.
+			cli := &mockClient{}
+			cli.On("ContainerList", mockContext, container.ListOptions{All: true, Filters: args}).
+				Return([]types.Container{{ID: containerID1, Created: created.Unix()}}, nil).Once()
+			cli.On("NetworkList", mockContext, network.ListOptions{Filters: args}).
+				Return([]network.Summary{{ID: networkID1, Created: created}}, nil).Once()
+			if tc.volumes {
+				cli.On("VolumeList", mockContext, volume.ListOptions{Filters: args}).
+					Return(volume.ListResponse{Volumes: []*volume.Volume{
+						{Name: volumeName1, CreatedAt: created.Format(time.RFC3339)},
+					}}, nil).Once()
+			}
+
+			cfg := testConfigBase
+			r := &reaper{
+				cfg:     &cfg,
+				client:  cli,
+				logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+				filters: make(map[string]*filterEntry),
+			}
+			require.NoError(t, r.addFilter("client1", tc.filterType+"="+tc.value))
+
+			res, err := r.resources(since)
+			require.NoError(t, err)
+			cli.AssertExpectations(t)
+			require.Equal(t, []string{containerID1}, res.containers)
+			require.Equal(t, []string{networkID1}, res.networks)
+			if tc.volumes {
+				require.Equal(t, []string{volumeName1}, res.volumes)
+			} else {
+				require.Empty(t, res.volumes)
+			}
+			require.Empty(t, res.images)
+		})
+	}
+}
+
+func TestFilterTypeUnsupported(t *testing.T) {
+	require.ErrorIs(t, validateFilterTypes(map[string][]string{"dangling": {"true"}}), errFilterTypeUnsupported)
+	require.NoError(t, validateFilterTypes(map[string][]string{"label": {"a=b"}, "name": {"c"}}))
+
+	tc := newRunTest()
+	tc.connect = func(ctx context.Context, t *testing.T, addr string) {
+		t.Helper()
+		require.Equal(t, "NACK\n", testSend(ctx, t, addr, "dangling=true"))
+	}
+
+	log, err := testReaperRun(t, tc)
+	require.NoError(t, err)
+	require.Contains(t, log, `error="type \"dangling\": filter type unsupported"`)
+	require.Contains(t, log, "removed containers=2 networks=2 volumes=2 images=2")
+}
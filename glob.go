@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/docker/docker/api/types/filters"
+)
+
+// labelGlob is the suffix of a label filter value which matches any
+// label value starting with the value before it, for example
+// run.id=1234-* matches the label run.id=1234-abcd.
+const labelGlob = "*"
+
+// globLabels returns args with each label glob filter replaced by its key,
+// as Docker only matches exact label values, and whether args has any
+// label glob filters. Resources listed with the returned args must be
+// matched against the label filters of args in memory.
+func globLabels(args filters.Args) (filters.Args, bool) {
+	var glob bool
+	listArgs := args
+	for _, label := range args.Get("label") {
+		key, value, ok := strings.Cut(label, "=")
+		if !ok || !strings.HasSuffix(value, labelGlob) {
+			continue
+		}
+
+		if !glob {
+			glob = true
+			listArgs = args.Clone()
+		}
+		listArgs.Del("label", label)
+		listArgs.Add("label", key)
+	}
+
+	return listArgs, glob
+}
+
+// matchItems returns the items whose labels match all the label filters.
+func matchItems[T any](items []T, labels func(T) map[string]string, labelFilters []string) []T {
+	var matched []T
+	for _, item := range items {
+		if matchLabels(labels(item), labelFilters) {
+			matched = append(matched, item)
+		}
+	}
+
+	return matched
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_globLabels(t *testing.T) {
+	t.Run("exact", func(t *testing.T) {
+		args := filters.NewArgs(filters.Arg("label", "run=1234"), filters.Arg("label", "testing"))
+		listArgs, glob := globLabels(args)
+		require.False(t, glob)
+		require.Equal(t, args, listArgs)
+	})
+
+	t.Run("glob", func(t *testing.T) {
+		args := filters.NewArgs(filters.Arg("label", "run=1234-*"), filters.Arg("label", "testing=true"))
+		listArgs, glob := globLabels(args)
+		require.True(t, glob)
+		require.Equal(t, filters.NewArgs(filters.Arg("label", "run"), filters.Arg("label", "testing=true")), listArgs)
+
+		// The original args are unchanged.
+		require.ElementsMatch(t, []string{"run=1234-*", "testing=true"}, args.Get("label"))
+	})
+}
+
+func TestLabelGlob(t *testing.T) {
+	since := time.Now()
+	created := since.Add(-time.Minute).Unix()
+	match1 := types.Container{ID: "match1", Created: created, Labels: map[string]string{"run": "1234-abcd"}}
+	match2 := types.Container{ID: "match2", Created: created, Labels: map[string]string{"run": "1234-efgh"}}
+	other := types.Container{ID: "other", Created: created, Labels: map[string]string{"run": "5678-abcd"}}
+
+	// listed are the containers Docker returns for listArgs.
+	tests := map[string]struct {
+		filter   string
+		listArgs filters.Args
+		listed   []types.Container
+		expected []string
+	}{
+		"prefix": {
+			filter:   "run=1234-*",
+			listArgs: filters.NewArgs(filters.Arg("label", "run")),
+			listed:   []types.Container{match1, match2, other},
+			expected: []string{"match1", "match2"},
+		},
+		"exact": {
+			filter:   "run=1234-abcd",
+			listArgs: filters.NewArgs(filters.Arg("label", "run=1234-abcd")),
+			listed:   []types.Container{match1},
+			expected: []string{"match1"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cli := newListMockClient(map[*filters.Args][]types.Container{&tc.listArgs: tc.listed})
+
+			r, err := newReaper(context.Background(), discardLogger, testConfig, withClient(cli))
+			require.NoError(t, err)
+			t.Cleanup(func() { r.listener.Close() })
+
+			require.NoError(t, r.addFilter("client", "label="+tc.filter))
+			res, err := r.resources(since)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, res.containers)
+		})
+	}
+}
+
+func TestLabelGlobImagesUsePrune(t *testing.T) {
+	// Docker would match the glob literally, so the images matched in
+	// memory are removed individually instead of pruned.
+	since := time.Now()
+	created := since.Add(-time.Minute).Unix()
+	listArgs := filters.NewArgs(filters.Arg("label", "run"))
+	cli := &mockClient{}
+	cli.On("Ping", mockContext).Return(types.Ping{}, nil)
+	cli.On("NegotiateAPIVersion", mockContext).Return()
+	cli.On("ClientVersion").Return("1.47")
+	cli.On("ContainerList", mockContext, mock.Anything).Return([]types.Container{}, nil)
+	cli.On("NetworkList", mockContext, mock.Anything).Return([]network.Summary{}, nil)
+	cli.On("VolumeList", mockContext, mock.Anything).Return(volume.ListResponse{}, nil)
+	cli.On("ImageList", mockContext, image.ListOptions{Filters: listArgs}).Return([]image.Summary{
+		{ID: imageID1, Created: created, Labels: map[string]string{"run": "1234-abcd"}},
+		{ID: imageID2, Created: created, Labels: map[string]string{"run": "5678-abcd"}},
+	}, nil)
+	cli.On("ImageRemove", mockContext, imageID1, imageRemoveOptions).
+		Return([]image.DeleteResponse{{Deleted: imageID1}}, nil)
+
+	cfg := testConfigBase
+	cfg.ImagesUsePrune = true
+	r, err := newReaper(context.Background(), discardLogger, withConfig(cfg), withClient(cli))
+	require.NoError(t, err)
+	t.Cleanup(func() { r.listener.Close() })
+
+	require.NoError(t, r.addFilter("client", "label=run=1234-*"))
+	res, err := r.resources(since)
+	require.NoError(t, err)
+	require.Equal(t, []string{imageID1}, res.images)
+
+	require.NoError(t, r.prune(context.Background(), res))
+	cli.AssertNotCalled(t, "ImagesPrune", mock.Anything, mock.Anything)
+	cli.AssertCalled(t, "ImageRemove", mockContext, imageID1, imageRemoveOptions)
+	cli.AssertNotCalled(t, "ImageRemove", mockContext, imageID2, mock.Anything)
+}
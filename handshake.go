@@ -0,0 +1,20 @@
+package main
+
+import (
+	"sync"
+)
+
+// acquireHandshake blocks until a handshake slot is available, if the number
+// of concurrent handshakes is limited, and returns a function which releases
+// the slot. The returned function is safe to call multiple times.
+func (r *reaper) acquireHandshake() func() {
+	if r.handshakes == nil {
+		return func() {}
+	}
+
+	r.handshakes <- struct{}{}
+
+	return sync.OnceFunc(func() {
+		<-r.handshakes
+	})
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaxConcurrentHandshakes(t *testing.T) {
+	const slow = 5
+
+	cfg := testConfigBase
+	cfg.MaxConcurrentHandshakes = 2
+	tc := newRunTest()
+	tc.connect = func(ctx context.Context, t *testing.T, addr string) {
+		t.Helper()
+
+		// Slow clients which connect but don't send their filters.
+		var d net.Dialer
+		conns := make([]net.Conn, slow)
+		for i := range conns {
+			conn, err := d.DialContext(ctx, "tcp", addr)
+			require.NoError(t, err)
+			conns[i] = conn
+		}
+
+		// Queued clients must wait until the slow clients complete
+		// their handshake, so no response is expected yet.
+		ackCh := make(chan string, 1)
+		go func() {
+			ackCh <- testSend(ctx, t, addr, filterKey(filterArgs(testLabels1)))
+		}()
+
+		select {
+		case ack := <-ackCh:
+			t.Fatalf("unexpected response while queued: %q", ack)
+		case <-time.After(time.Millisecond * 100):
+		}
+
+		// Completing the slow handshakes releases the queued client.
+		for _, conn := range conns {
+			_, err := conn.Write([]byte(filterKey(filterArgs(testLabels2)) + "\n"))
+			require.NoError(t, err)
+		}
+
+		select {
+		case ack := <-ackCh:
+			require.Equal(t, "ACK\n", ack)
+		case <-ctx.Done():
+			t.Fatal("queued client not released")
+		}
+
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}
+
+	log, err := testReaperRun(t, tc, withConfig(cfg))
+	require.NoError(t, err)
+	require.Contains(t, log, "removed containers=2 networks=2 volumes=2 images=2")
+}
+
+func TestConnectionToken(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	t.Cleanup(cancel)
+
+	var log safeBuffer
+	logger := withLogger(slog.New(slog.NewTextHandler(&log, nil)))
+	cfg := testConfigBase
+	cfg.ConnectionToken = "secret"
+	r, err := newReaper(ctx, logger, withConfig(cfg), withClient(newMockClient(newRunTest())))
+	require.NoError(t, err)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- r.run(ctx)
+	}()
+
+	addr := r.listener.Addr().String()
+	clientCtx, clientCancel := context.WithTimeout(ctx, time.Millisecond*500)
+	t.Cleanup(clientCancel)
+
+	// A client sending the wrong token is disconnected without an ACK.
+	var d net.Dialer
+	conn, err := d.DialContext(clientCtx, "tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	_, err = conn.Write([]byte("wrong\n" + filterKey(filterArgs(testLabels2)) + "\n"))
+	require.NoError(t, err)
+	_, err = conn.Read(make([]byte, 4))
+	require.ErrorIs(t, err, io.EOF)
+
+	// A client sending the token first has its filter registered.
+	require.Equal(t, "ACK\n", testSend(clientCtx, t, addr, "secret\n"+filterKey(filterArgs(testLabels1))))
+
+	select {
+	case err = <-errCh:
+		require.NoError(t, err)
+	case <-ctx.Done():
+		t.Fatal("timeout", log.String())
+	}
+
+	data := log.String()
+	require.Contains(t, data, `msg="auth failed"`)
+	require.Contains(t, data, "removed containers=1 networks=1 volumes=1 images=1")
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// healthPath is the path of the health endpoint.
+const healthPath = "/healthz"
+
+// listenHealth starts listening for health requests if a health port is configured.
+func (r *reaper) listenHealth() error {
+	if r.cfg.HealthPort == 0 {
+		return nil
+	}
+
+	var err error
+	if r.healthListener, err = net.Listen("tcp", net.JoinHostPort(r.cfg.BindAddr, strconv.Itoa(int(r.cfg.HealthPort)))); err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+
+	return nil
+}
+
+// healthMux returns the handler of the health endpoint.
+func (r *reaper) healthMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc(healthPath, r.handleHealth)
+
+	return mux
+}
+
+// serveHealth serves health requests until ctx is done.
+func (r *reaper) serveHealth(ctx context.Context) {
+	srv := &http.Server{
+		Handler:           r.healthMux(),
+		ReadHeaderTimeout: r.cfg.RequestTimeout,
+	}
+
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	r.logger.Info("health endpoint started", fieldAddress, r.healthListener.Addr().String())
+	if err := srv.Serve(r.healthListener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		r.logger.Error("health serve", fieldError, err)
+	}
+}
+
+// handleHealth reports healthy while the reaper is accepting connections
+// and Docker is reachable, otherwise unavailable.
+func (r *reaper) handleHealth(w http.ResponseWriter, req *http.Request) {
+	select {
+	case <-r.shutdown:
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	default:
+	}
+
+	if err := r.ping(req.Context(), r.docker()); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/require"
+)
+
+// testHealth requests the health endpoint of srv and returns the status code and body.
+func testHealth(t *testing.T, srv *httptest.Server) (int, string) {
+	t.Helper()
+
+	resp, err := srv.Client().Get(srv.URL + healthPath)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return resp.StatusCode, string(body)
+}
+
+func TestHealth(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("shutdown", func(t *testing.T) {
+		cli := newMockClient(newRunTest())
+		r, err := newReaper(ctx, discardLogger, testConfig, withClient(cli))
+		require.NoError(t, err)
+		t.Cleanup(func() { r.listener.Close() })
+
+		srv := httptest.NewServer(http.HandlerFunc(r.handleHealth))
+		t.Cleanup(srv.Close)
+
+		code, body := testHealth(t, srv)
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, "ok\n", body)
+
+		r.shutdownListener()
+		code, body = testHealth(t, srv)
+		require.Equal(t, http.StatusServiceUnavailable, code)
+		require.Equal(t, "shutting down\n", body)
+	})
+
+	t.Run("docker-unreachable", func(t *testing.T) {
+		cli := &mockClient{}
+		cli.On("NegotiateAPIVersion", mockContext).Return()
+		cli.On("ClientVersion").Return("1.47")
+		cli.On("Ping", mockContext).Return(types.Ping{}, nil).Once()
+		cli.On("Ping", mockContext).Return(types.Ping{}, errors.New("connection refused"))
+		r, err := newReaper(ctx, discardLogger, testConfig, withClient(cli))
+		require.NoError(t, err)
+		t.Cleanup(func() { r.listener.Close() })
+
+		srv := httptest.NewServer(http.HandlerFunc(r.handleHealth))
+		t.Cleanup(srv.Close)
+
+		code, body := testHealth(t, srv)
+		require.Equal(t, http.StatusServiceUnavailable, code)
+		require.Equal(t, "ping: connection refused\n", body)
+	})
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/errdefs"
+)
+
+// errImageInUse is returned if an image is still referenced by a
+// container which isn't being pruned, so it can't be removed.
+var errImageInUse = errors.New("image in use")
+
+// passContainersKey is the context key of the containers being pruned.
+type passContainersKey struct{}
+
+// withPassContainers returns ctx with the containers of the prune pass,
+// so containers referencing an image can be removed before it.
+func withPassContainers(ctx context.Context, containers []string) context.Context {
+	ids := make(map[string]struct{}, len(containers))
+	for _, id := range containers {
+		ids[id] = struct{}{}
+	}
+
+	return context.WithValue(ctx, passContainersKey{}, ids)
+}
+
+// imageInUse returns true if err reports that an image is still
+// referenced by a container.
+func imageInUse(err error) bool {
+	return errdefs.IsConflict(err) && strings.Contains(err.Error(), "being used by")
+}
+
+// removeImageContainers removes the containers referencing image id, so it
+// can be removed, for example if their removal failed earlier in the pass
+// or they belong to a session pruned later. It returns the IDs removed, or
+// an error wrapping errImageInUse if a referencing container isn't part of
+// the prune pass in ctx.
+func (r *reaper) removeImageContainers(ctx context.Context, id string) ([]string, error) {
+	containers, err := r.docker().ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("ancestor", id)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("container list: %w", err)
+	}
+
+	pass, _ := ctx.Value(passContainersKey{}).(map[string]struct{})
+	for _, c := range containers {
+		if _, ok := pass[c.ID]; !ok {
+			return nil, fmt.Errorf("%w: container %s", errImageInUse, c.ID)
+		}
+	}
+
+	removed := make([]string, 0, len(containers))
+	for _, c := range containers {
+		r.logger.Debug("removing container referencing image", "id", c.ID, "image", id)
+		r.stopContainer(ctx, c.ID)
+		if err = r.docker().ContainerRemove(ctx, c.ID, r.containerRemoveOptions()); err != nil && !errdefs.IsNotFound(err) {
+			return removed, fmt.Errorf("container remove %s: %w", c.ID, err)
+		}
+		removed = append(removed, c.ID)
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/errdefs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImageConflict(t *testing.T) {
+	inUse := errdefs.Conflict(errors.New("conflict: unable to delete " + imageID1 + " (cannot be forced) - image is being used by running container " + containerID2))
+	ancestor := container.ListOptions{All: true, Filters: filters.NewArgs(filters.Arg("ancestor", imageID1))}
+
+	cli := &mockClient{}
+	cli.On("ImageRemove", mockContext, imageID1, imageRemoveOptions).Return([]image.DeleteResponse(nil), inUse).Once()
+	cli.On("ImageRemove", mockContext, imageID1, imageRemoveOptions).Return([]image.DeleteResponse{{Deleted: imageID1}}, nil).Once()
+	cli.On("ContainerList", mockContext, ancestor).Return([]types.Container{{ID: containerID2}}, nil)
+	cli.On("ContainerRemove", mockContext, containerID2, containerRemoveOptions).Return(nil).Once()
+	cli.On("ContainerRemove", mockContext, containerID2, containerRemoveOptions).Return(errdefs.NotFound(errors.New("no such container"))).Once()
+
+	var log safeBuffer
+	cfg := testConfigBase
+	cfg.SessionDeadline = time.Second
+	r := &reaper{
+		cfg:    &cfg,
+		client: cli,
+		logger: slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	}
+
+	// The image of the first session is used by a container of the second,
+	// which is removed first so the image can be.
+	session1 := &resources{images: []string{imageID1}, filters: []filters.Args{filterArgs(testLabels1)}}
+	session2 := &resources{containers: []string{containerID2}, filters: []filters.Args{filterArgs(testLabels2)}}
+	res := &resources{
+		containers: []string{containerID2},
+		images:     []string{imageID1},
+		sessions:   []*resources{session1, session2},
+	}
+	require.NoError(t, r.prune(context.Background(), res))
+	cli.AssertExpectations(t)
+
+	data := log.String()
+	require.Contains(t, data, `msg="removing container referencing image" id=`+containerID2+" image="+imageID1)
+	require.Contains(t, data, "removed containers=1 networks=0 volumes=0 images=1")
+	require.NotContains(t, data, "level=ERROR")
+
+	t.Run("not-pruned", func(t *testing.T) {
+		cli := &mockClient{}
+		cli.On("ImageRemove", mockContext, imageID1, imageRemoveOptions).Return([]image.DeleteResponse(nil), inUse)
+		cli.On("ContainerList", mockContext, ancestor).Return([]types.Container{{ID: containerID2}}, nil)
+		r.client = cli
+
+		// The referencing container isn't being pruned, so it's kept.
+		err := r.prune(context.Background(), &resources{images: []string{imageID1}})
+		var rerr *removeError
+		require.ErrorAs(t, err, &rerr)
+		require.ErrorIs(t, rerr.left[imageID1], errImageInUse)
+		cli.AssertNumberOfCalls(t, "ImageRemove", 1)
+		cli.AssertNotCalled(t, "ContainerRemove", mockContext, containerID2, containerRemoveOptions)
+	})
+}
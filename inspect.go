@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// inspectChanged re-evaluates a container whose listed creation time
+// suggests it changed after since, using the precise creation time from
+// inspect, as the listed time is truncated to the second and hosts with VM
+// clock drift can report false changes. It returns true if the container
+// is still considered changed, including if it can't be inspected.
+func (r *reaper) inspectChanged(ctx context.Context, id string, since time.Time) bool {
+	created, err := r.containerCreated(ctx, id)
+	if err != nil {
+		r.logger.Warn("change inspect", fieldError, err, "id", id)
+		return true
+	}
+
+	changed := created.After(since)
+	r.logger.Debug("change inspected", "id", id, "created", created, "changed", changed, "since", since)
+
+	return changed
+}
+
+// containerCreated returns the precise creation time of the container id.
+func (r *reaper) containerCreated(ctx context.Context, id string) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.RequestTimeout)
+	defer cancel()
+
+	info, err := r.docker().ContainerInspect(ctx, id)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("container inspect: %w", err)
+	}
+
+	if info.ContainerJSONBase == nil {
+		return time.Time{}, fmt.Errorf("container inspect: no details for %s", id)
+	}
+
+	created, err := time.Parse(time.RFC3339Nano, info.Created)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parse created: %w", err)
+	}
+
+	return created, nil
+}
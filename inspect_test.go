@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspectChanges(t *testing.T) {
+	since := time.Now()
+	args := filterArgs(testLabels1)
+	inspected := func(created time.Time) types.ContainerJSON {
+		return types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{Created: created.Format(time.RFC3339Nano)}}
+	}
+
+	// All the listed creation times suggest a change.
+	cli := &mockClient{}
+	cli.On("ContainerList", mockContext, mock.Anything).Return([]types.Container{
+		{ID: containerID1, Created: since.Unix() + 1},
+		{ID: containerID2, Created: since.Unix() + 1},
+		{ID: "broken", Created: since.Unix() + 1},
+	}, nil)
+	cli.On("ContainerInspect", mockContext, containerID1).Return(inspected(since.Add(-time.Second)), nil)
+	cli.On("ContainerInspect", mockContext, containerID2).Return(inspected(since.Add(time.Millisecond)), nil)
+	cli.On("ContainerInspect", mockContext, "broken").Return(types.ContainerJSON{}, errors.New("inspect error"))
+
+	var log safeBuffer
+	cfg := testConfigBase
+	cfg.InspectChanges = true
+	r := &reaper{
+		cfg:    &cfg,
+		client: cli,
+		logger: slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	}
+
+	// Inspect resolves the first as created before since, the others are
+	// still changes.
+	containers, _, err := r.affectedContainers(context.Background(), since, args, nil, nil)
+	require.ErrorIs(t, err, errChangesDetected)
+	require.Equal(t, []string{containerID1}, containers)
+	require.Contains(t, err.Error(), "container "+containerID2+": changes detected")
+	require.Contains(t, err.Error(), "container broken: changes detected")
+	require.Contains(t, log.String(), `msg="change inspected" id=`+containerID1)
+	require.Contains(t, log.String(), `level=WARN msg="change inspect" error="container inspect: inspect error" id=broken`)
+
+	t.Run("disabled", func(t *testing.T) {
+		cfg.InspectChanges = false
+		containers, _, err := r.affectedContainers(context.Background(), since, args, nil, nil)
+		require.ErrorIs(t, err, errChangesDetected)
+		require.Empty(t, containers)
+		cli.AssertNumberOfCalls(t, "ContainerInspect", 3)
+	})
+}
@@ -5,21 +5,40 @@ import (
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/system"
 	"github.com/docker/docker/api/types/volume"
+	bkclient "github.com/moby/buildkit/client"
 )
 
 // dockerClient is an interface that represents the reapers required Docker methods.
 type dockerClient interface {
+	BuildCachePrune(ctx context.Context, opts types.BuildCachePruneOptions) (*types.BuildCachePruneReport, error)
+	ClientVersion() string
+	Close() error
+	ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error)
 	ContainerList(ctx context.Context, options container.ListOptions) ([]types.Container, error)
 	ContainerRemove(ctx context.Context, containerID string, options container.RemoveOptions) error
+	ContainerStop(ctx context.Context, containerID string, options container.StopOptions) error
+	DaemonHost() string
+	DiskUsage(ctx context.Context, options types.DiskUsageOptions) (types.DiskUsage, error)
 	ImageList(ctx context.Context, options image.ListOptions) ([]image.Summary, error)
 	ImageRemove(ctx context.Context, imageID string, options image.RemoveOptions) ([]image.DeleteResponse, error)
+	ImagesPrune(ctx context.Context, pruneFilters filters.Args) (image.PruneReport, error)
+	Info(ctx context.Context) (system.Info, error)
 	NetworkList(ctx context.Context, options network.ListOptions) ([]network.Summary, error)
+	NetworkInspect(ctx context.Context, networkID string, options network.InspectOptions) (network.Inspect, error)
 	NetworkRemove(ctx context.Context, networkID string) error
 	VolumeList(ctx context.Context, options volume.ListOptions) (volume.ListResponse, error)
 	VolumeRemove(ctx context.Context, volumeID string, force bool) error
 	Ping(ctx context.Context) (types.Ping, error)
 	NegotiateAPIVersion(ctx context.Context)
 }
+
+// buildkitClient is an interface that represents the reapers required BuildKit methods.
+type buildkitClient interface {
+	Prune(ctx context.Context, ch chan bkclient.UsageInfo, opts ...bkclient.PruneOption) error
+	Close() error
+}
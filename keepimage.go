@@ -0,0 +1,39 @@
+package main
+
+import (
+	"github.com/docker/docker/api/types"
+)
+
+// containerImage returns the image c was created from, preferring the
+// image ID so differently tagged references to one image are grouped.
+func containerImage(c types.Container) string {
+	if c.ImageID != "" {
+		return c.ImageID
+	}
+
+	return c.Image
+}
+
+// keepOnePerImage splits containers into the newest container of each
+// distinct image, which are kept so their image stays referenced, and
+// the rest which can be removed. Both retain their relative order.
+func keepOnePerImage(containers []types.Container) (remove, keep []types.Container) {
+	newest := make(map[string]int, len(containers))
+	for i, c := range containers {
+		image := containerImage(c)
+		if j, ok := newest[image]; !ok || c.Created > containers[j].Created {
+			newest[image] = i
+		}
+	}
+
+	for i, c := range containers {
+		if newest[containerImage(c)] == i {
+			keep = append(keep, c)
+			continue
+		}
+
+		remove = append(remove, c)
+	}
+
+	return remove, keep
+}
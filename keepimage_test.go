@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeepOnePerImage(t *testing.T) {
+	containers := []types.Container{
+		{ID: "a-old", ImageID: "sha256:a", Created: 1},
+		{ID: "b-only", Image: "b:latest", Created: 1},
+		{ID: "a-new", ImageID: "sha256:a", Created: 3},
+		{ID: "a-mid", ImageID: "sha256:a", Created: 2},
+		{ID: "c-first", ImageID: "sha256:c", Created: 1},
+		{ID: "c-second", ImageID: "sha256:c", Created: 1},
+	}
+
+	remove, keep := keepOnePerImage(containers)
+	ids := func(containers []types.Container) []string {
+		ids := make([]string, len(containers))
+		for i, c := range containers {
+			ids[i] = c.ID
+		}
+		return ids
+	}
+	require.Equal(t, []string{"a-old", "a-mid", "c-second"}, ids(remove))
+	require.Equal(t, []string{"b-only", "a-new", "c-first"}, ids(keep))
+}
+
+func TestKeepOnePerImageResources(t *testing.T) {
+	since := time.Now()
+	created := since.Add(-time.Minute)
+	args := filterArgs(testLabels1)
+
+	cli := &mockClient{}
+	cli.On("ContainerList", mockContext, container.ListOptions{All: true, Filters: args}).Return([]types.Container{
+		{ID: "a-old", ImageID: "sha256:a", Created: created.Add(-time.Second).Unix()},
+		{ID: "a-new", ImageID: "sha256:a", Created: created.Unix()},
+		{ID: "b-only", ImageID: "sha256:b", Created: created.Unix()},
+	}, nil)
+	cli.On("NetworkList", mockContext, mock.Anything).Return([]network.Summary{}, nil)
+	cli.On("VolumeList", mockContext, mock.Anything).Return(volume.ListResponse{}, nil)
+	cli.On("ImageList", mockContext, image.ListOptions{Filters: args}).Return([]image.Summary{
+		{ID: "sha256:a", Created: created.Unix()},
+		{ID: "sha256:b", Created: created.Unix()},
+		{ID: "sha256:unused", Created: created.Unix()},
+	}, nil)
+
+	cfg := testConfigBase
+	cfg.KeepOnePerImage = true
+	r := &reaper{
+		cfg:    &cfg,
+		client: cli,
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	res, err := r.affectedResources(context.Background(), since, args)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a-old"}, res.containers)
+	require.Equal(t, []string{"sha256:unused"}, res.images)
+
+	t.Run("disabled", func(t *testing.T) {
+		cfg.KeepOnePerImage = false
+		res, err := r.affectedResources(context.Background(), since, args)
+		require.NoError(t, err)
+		require.Equal(t, []string{"a-old", "a-new", "b-only"}, res.containers)
+		require.Equal(t, []string{"sha256:a", "sha256:b", "sha256:unused"}, res.images)
+	})
+}
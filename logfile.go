@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// rotatingFile is a log file which is rotated to a single backup, with the
+// suffix .1, once writing to it would exceed its maximum size.
+// Safe for concurrent use.
+type rotatingFile struct {
+	path string
+
+	// maxSize is the maximum size in bytes, if zero it's never rotated.
+	maxSize uint64
+
+	file *os.File
+	size uint64
+	mtx  sync.Mutex
+}
+
+// openRotatingFile opens the log file at path for appending, creating it
+// if needed, which is rotated once it would exceed maxSize bytes.
+func openRotatingFile(path string, maxSize uint64) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// open opens the file for appending, recording its current size.
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644) //nolint:gosec // Logs are intended to be read by other tools.
+	if err != nil {
+		return fmt.Errorf("open: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("stat: %w", err)
+	}
+
+	f.file = file
+	f.size = uint64(info.Size()) //nolint:gosec // Sizes are never negative.
+	return nil
+}
+
+// Write implements io.Writer, rotating the file first if writing p
+// would exceed the maximum size.
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if f.maxSize > 0 && f.size > 0 && f.size+uint64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += uint64(n) //nolint:gosec // Write never returns a negative count.
+	if err != nil {
+		return n, fmt.Errorf("write: %w", err)
+	}
+
+	return n, nil
+}
+
+// rotate replaces the backup with the current file and opens a new one.
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("close: %w", err)
+	}
+
+	if err := os.Rename(f.path, f.path+".1"); err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+
+	return f.open()
+}
+
+// Close closes the file.
+func (f *rotatingFile) Close() error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("close: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_rotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ryuk.log")
+	f, err := openRotatingFile(path, 10)
+	require.NoError(t, err)
+	t.Cleanup(func() { f.Close() })
+
+	write := func(s string) {
+		t.Helper()
+
+		n, err := f.Write([]byte(s))
+		require.NoError(t, err)
+		require.Equal(t, len(s), n)
+	}
+
+	// Writes are appended until the maximum size would be exceeded.
+	write("first\n")
+	write("sec\n")
+	write("third\n")
+	write("fourth\n")
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "fourth\n", string(data))
+
+	data, err = os.ReadFile(path + ".1")
+	require.NoError(t, err)
+	require.Equal(t, "third\n", string(data))
+
+	t.Run("reopen", func(t *testing.T) {
+		// The size of an existing file is included.
+		f, err := openRotatingFile(path, 10)
+		require.NoError(t, err)
+		t.Cleanup(func() { f.Close() })
+
+		_, err = f.Write([]byte("fifth\n"))
+		require.NoError(t, err)
+
+		data, err := os.ReadFile(path + ".1")
+		require.NoError(t, err)
+		require.Equal(t, "fourth\n", string(data))
+	})
+}
+
+func TestLogFile(t *testing.T) {
+	cfg := testConfigBase
+	cfg.LogFile = filepath.Join(t.TempDir(), "ryuk.log")
+	r, err := newReaper(context.Background(), withConfig(cfg), withClient(newListMockClient(nil)))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		r.listener.Close()
+		r.logFile.Close()
+	})
+
+	data, err := os.ReadFile(cfg.LogFile)
+	require.NoError(t, err)
+	require.Contains(t, string(data), "level=INFO msg=Started address="+r.listener.Addr().String())
+}
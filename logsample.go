@@ -0,0 +1,44 @@
+package main
+
+import (
+	"log/slog"
+)
+
+// logSampler limits a high volume debug log message to the first
+// entries, reporting how many were omitted once done.
+// It is not safe for concurrent use.
+type logSampler struct {
+	logger *slog.Logger
+	msg    string
+	limit  int
+	count  int
+}
+
+// newLogSampler returns a new logSampler for msg using the configured
+// sample limit. If the limit is zero every entry is logged.
+func (r *reaper) newLogSampler(msg string) *logSampler {
+	return &logSampler{
+		logger: r.logger,
+		msg:    msg,
+		limit:  r.cfg.LogSample,
+	}
+}
+
+// log logs msg with args at debug level if the limit has not been reached.
+func (s *logSampler) log(args ...any) {
+	s.count++
+	if s.limit > 0 && s.count > s.limit {
+		return
+	}
+
+	s.logger.Debug(s.msg, args...)
+}
+
+// done logs the number of omitted entries, if any.
+func (s *logSampler) done() {
+	if s.limit <= 0 || s.count <= s.limit {
+		return
+	}
+
+	s.logger.Debug(s.msg, "omitted", s.count-s.limit)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogSample(t *testing.T) {
+	since := time.Now()
+	args := filterArgs(testLabels1)
+	containers := make([]types.Container, 10)
+	for i := range containers {
+		containers[i] = types.Container{ID: "id" + strconv.Itoa(i), Created: since.Add(-time.Minute).Unix()}
+	}
+
+	tests := map[string]struct {
+		sample  int
+		found   int
+		omitted string
+	}{
+		"disabled": {sample: 0, found: 10},
+		"limited":  {sample: 3, found: 3, omitted: `msg="found container" omitted=7`},
+		"under":    {sample: 20, found: 10},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var log safeBuffer
+			cfg := testConfigBase
+			cfg.LogSample = tc.sample
+			r := &reaper{
+				cfg:    &cfg,
+				client: newListMockClient(map[*filters.Args][]types.Container{&args: containers}),
+				logger: slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{Level: slog.LevelDebug})),
+			}
+
+			ids, _, err := r.affectedContainers(context.Background(), since, args, nil, nil)
+			require.NoError(t, err)
+			require.Len(t, ids, len(containers))
+
+			data := log.String()
+			require.Equal(t, tc.found, strings.Count(data, `msg="found container" id=`))
+			if tc.omitted != "" {
+				require.Contains(t, data, tc.omitted)
+			} else {
+				require.NotContains(t, data, "omitted")
+			}
+		})
+	}
+}
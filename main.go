@@ -3,23 +3,56 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 )
 
-// run creates and runs a reaper which is cancelled when a signal is received.
-func run() error {
-	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
-	defer stop()
+// filterFlags are the filters of a repeatable command line flag.
+type filterFlags []string
 
-	r, err := newReaper(ctx)
+// String implements flag.Value.
+func (f *filterFlags) String() string {
+	return strings.Join(*f, " ")
+}
+
+// Set implements flag.Value.
+func (f *filterFlags) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// run creates and runs a reaper which is cancelled when a signal is received,
+// and forced to shutdown if a second signal is received. If batch filters
+// are specified their resources are reaped once instead.
+func run(batch []string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	r, err := newReaper(ctx, withBatch(batch))
 	if err != nil {
 		return fmt.Errorf("new reaper: %w", err)
 	}
 
+	go r.handleSignals(signals, cancel)
+
+	if len(batch) > 0 {
+		if err = r.reapOnce(ctx); err != nil {
+			return fmt.Errorf("reap: %w", err)
+		}
+
+		return nil
+	}
+
 	if err = r.run(ctx); err != nil {
 		return fmt.Errorf("run: %w", err)
 	}
@@ -27,9 +60,30 @@ func run() error {
 	return nil
 }
 
+// exitCode returns the process exit code for err, so callers can
+// distinguish failure classes, for example to retry on connectivity.
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, errLoadConfig):
+		return exitConfig
+	case errors.Is(err, errPing):
+		return exitConnectivity
+	case errors.Is(err, errListen):
+		return exitListen
+	case errors.Is(err, errNoConnection):
+		return exitNoConnection
+	default:
+		return exitFailure
+	}
+}
+
 func main() {
-	if err := run(); err != nil {
+	var batch filterFlags
+	flag.Var(&batch, "reap", "filter, such as label=key=value, to reap the resources of once and exit, instead of listening for clients; can be repeated")
+	flag.Parse()
+
+	if err := run(batch); err != nil {
 		slog.Error("run", fieldError, err)
-		os.Exit(1)
+		os.Exit(exitCode(err))
 	}
 }
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_exitCode(t *testing.T) {
+	other := errors.New("other")
+	tests := map[string]struct {
+		err  error
+		want int
+	}{
+		"config":        {fmt.Errorf("%w: %w", errLoadConfig, other), exitConfig},
+		"ping":          {fmt.Errorf("%w: %w", errPing, other), exitConnectivity},
+		"listen":        {fmt.Errorf("%w: %w", errListen, other), exitListen},
+		"no-connection": {fmt.Errorf("run: prune wait: %w", errNoConnection), exitNoConnection},
+		"prune":         {fmt.Errorf("run: prune: %w", other), exitFailure},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.want, exitCode(fmt.Errorf("new reaper: %w", tc.err)))
+		})
+	}
+
+	t.Run("new-reaper", func(t *testing.T) {
+		tc := newRunTest()
+		tc.pingErr = errors.New("connection refused")
+		_, err := newReaper(context.Background(), discardLogger, withClient(newMockClient(tc)), testConfig)
+		require.Equal(t, exitConnectivity, exitCode(err))
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		t.Cleanup(func() { listener.Close() })
+
+		cfg := testConfigBase
+		cfg.BindAddr = "127.0.0.1"
+		cfg.Port = uint16(listener.Addr().(*net.TCPAddr).Port) //nolint:forcetypeassert,gosec // Always a TCP port.
+		_, err = newReaper(context.Background(), discardLogger, withClient(newMockClient(newRunTest())), withConfig(cfg))
+		require.Equal(t, exitListen, exitCode(err))
+
+		// The health and admin listeners fail like the client one.
+		cfg.Port = 0
+		cfg.HealthPort = uint16(listener.Addr().(*net.TCPAddr).Port) //nolint:forcetypeassert,gosec // Always a TCP port.
+		_, err = newReaper(context.Background(), discardLogger, withClient(newMockClient(newRunTest())), withConfig(cfg))
+		require.Equal(t, exitListen, exitCode(err))
+
+		cfg.HealthPort = 0
+		cfg.AdminPort = uint16(listener.Addr().(*net.TCPAddr).Port) //nolint:forcetypeassert,gosec // Always a TCP port.
+		_, err = newReaper(context.Background(), discardLogger, withClient(newMockClient(newRunTest())), withConfig(cfg))
+		require.Equal(t, exitListen, exitCode(err))
+
+		// Invalid TLS and filter schema files are config errors.
+		cfg = testConfigBase
+		cfg.TLSCertFile = filepath.Join(t.TempDir(), "missing.pem")
+		cfg.TLSKeyFile = cfg.TLSCertFile
+		_, err = newReaper(context.Background(), discardLogger, withClient(newMockClient(newRunTest())), withConfig(cfg))
+		require.Equal(t, exitConfig, exitCode(err))
+
+		cfg = testConfigBase
+		cfg.FilterSchema = filepath.Join(t.TempDir(), "missing.json")
+		_, err = newReaper(context.Background(), discardLogger, withClient(newMockClient(newRunTest())), withConfig(cfg))
+		require.Equal(t, exitConfig, exitCode(err))
+
+		t.Setenv("RYUK_CONNECTION_TIMEOUT", "-1s")
+		_, err = newReaper(context.Background(), discardLogger, withClient(newMockClient(newRunTest())))
+		require.Equal(t, exitConfig, exitCode(err))
+	})
+}
+
+func Test_filterFlags(t *testing.T) {
+	var f filterFlags
+	require.NoError(t, f.Set("label=a=1"))
+	require.NoError(t, f.Set("label=b"))
+	require.Equal(t, filterFlags{"label=a=1", "label=b"}, f)
+	require.Equal(t, "label=a=1 label=b", f.String())
+}
@@ -5,9 +5,12 @@ import (
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/system"
 	"github.com/docker/docker/api/types/volume"
+	bkclient "github.com/moby/buildkit/client"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -17,6 +20,16 @@ type mockClient struct {
 	mock.Mock
 }
 
+func (c *mockClient) BuildCachePrune(ctx context.Context, opts types.BuildCachePruneOptions) (*types.BuildCachePruneReport, error) {
+	args := c.Called(ctx, opts)
+	return args.Get(0).(*types.BuildCachePruneReport), args.Error(1)
+}
+
+func (c *mockClient) ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error) {
+	args := c.Called(ctx, containerID)
+	return args.Get(0).(types.ContainerJSON), args.Error(1)
+}
+
 func (c *mockClient) ContainerList(ctx context.Context, options container.ListOptions) ([]types.Container, error) {
 	args := c.Called(ctx, options)
 	return args.Get(0).([]types.Container), args.Error(1)
@@ -27,6 +40,31 @@ func (c *mockClient) ContainerRemove(ctx context.Context, containerID string, op
 	return args.Error(0)
 }
 
+func (c *mockClient) ContainerStop(ctx context.Context, containerID string, options container.StopOptions) error {
+	args := c.Called(ctx, containerID, options)
+	return args.Error(0)
+}
+
+func (c *mockClient) ClientVersion() string {
+	args := c.Called()
+	return args.String(0)
+}
+
+func (c *mockClient) Close() error {
+	args := c.Called()
+	return args.Error(0)
+}
+
+func (c *mockClient) DaemonHost() string {
+	args := c.Called()
+	return args.String(0)
+}
+
+func (c *mockClient) DiskUsage(ctx context.Context, options types.DiskUsageOptions) (types.DiskUsage, error) {
+	args := c.Called(ctx, options)
+	return args.Get(0).(types.DiskUsage), args.Error(1)
+}
+
 func (c *mockClient) ImageList(ctx context.Context, options image.ListOptions) ([]image.Summary, error) {
 	args := c.Called(ctx, options)
 	return args.Get(0).([]image.Summary), args.Error(1)
@@ -37,11 +75,21 @@ func (c *mockClient) ImageRemove(ctx context.Context, imageID string, options im
 	return args.Get(0).([]image.DeleteResponse), args.Error(1)
 }
 
+func (c *mockClient) ImagesPrune(ctx context.Context, pruneFilters filters.Args) (image.PruneReport, error) {
+	args := c.Called(ctx, pruneFilters)
+	return args.Get(0).(image.PruneReport), args.Error(1)
+}
+
 func (c *mockClient) NetworkList(ctx context.Context, options network.ListOptions) ([]network.Summary, error) {
 	args := c.Called(ctx, options)
 	return args.Get(0).([]network.Summary), args.Error(1)
 }
 
+func (c *mockClient) NetworkInspect(ctx context.Context, networkID string, options network.InspectOptions) (network.Inspect, error) {
+	args := c.Called(ctx, networkID, options)
+	return args.Get(0).(network.Inspect), args.Error(1)
+}
+
 func (c *mockClient) NetworkRemove(ctx context.Context, networkID string) error {
 	args := c.Called(ctx, networkID)
 	return args.Error(0)
@@ -62,6 +110,27 @@ func (c *mockClient) Ping(ctx context.Context) (types.Ping, error) {
 	return args.Get(0).(types.Ping), args.Error(1)
 }
 
+func (c *mockClient) Info(ctx context.Context) (system.Info, error) {
+	args := c.Called(ctx)
+	return args.Get(0).(system.Info), args.Error(1)
+}
+
 func (c *mockClient) NegotiateAPIVersion(ctx context.Context) {
 	c.Called(ctx)
 }
+
+var _ buildkitClient = (*mockBuildkitClient)(nil)
+
+type mockBuildkitClient struct {
+	mock.Mock
+}
+
+func (c *mockBuildkitClient) Prune(ctx context.Context, ch chan bkclient.UsageInfo, opts ...bkclient.PruneOption) error {
+	args := c.Called(ctx, ch, opts)
+	return args.Error(0)
+}
+
+func (c *mockBuildkitClient) Close() error {
+	args := c.Called()
+	return args.Error(0)
+}
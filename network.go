@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+)
+
+// errNetworkInUse is returned by the network remove function if the
+// network still has containers attached, so it's retried.
+var errNetworkInUse = errors.New("network in use")
+
+// removeNetwork removes network id. If containers are still attached,
+// which is common while a compose stack's containers are slow to stop,
+// they're logged and an error wrapping errNetworkInUse is returned so
+// removal is retried.
+func (r *reaper) removeNetwork(ctx context.Context, id string) error {
+	err := r.docker().NetworkRemove(ctx, id)
+	if err == nil || !strings.Contains(err.Error(), "active endpoints") {
+		return err //nolint:wrapcheck // Wrapped by action.
+	}
+
+	report, inspectErr := r.docker().NetworkInspect(ctx, id, network.InspectOptions{})
+	if inspectErr != nil {
+		r.logger.Debug("network in use", "id", id, fieldError, inspectErr)
+	} else {
+		r.logger.Debug("network in use", "id", id, "containers", slices.Sorted(maps.Keys(report.Containers)))
+	}
+
+	return fmt.Errorf("%w: %w", errNetworkInUse, err)
+}
+
+// sharedNetworkContainer returns the ID of a container attached to the
+// network which doesn't match any session filter, or an empty string if
+// there is none. session caches the containers matching any filter and
+// is loaded on first use.
+func (r *reaper) sharedNetworkContainer(ctx context.Context, networkID string, session *map[string]struct{}) (string, error) {
+	report, err := r.docker().NetworkInspect(ctx, networkID, network.InspectOptions{})
+	if err != nil {
+		return "", fmt.Errorf("network inspect: %w", err)
+	}
+
+	if len(report.Containers) == 0 {
+		return "", nil
+	}
+
+	if *session == nil {
+		if *session, err = r.sessionContainers(ctx); err != nil {
+			return "", err
+		}
+	}
+
+	for id := range report.Containers {
+		if _, ok := (*session)[id]; !ok {
+			return id, nil
+		}
+	}
+
+	return "", nil
+}
+
+// sessionContainers returns the set of containers matching any filter.
+func (r *reaper) sessionContainers(ctx context.Context) (map[string]struct{}, error) {
+	ids := make(map[string]struct{})
+	for _, args := range r.filterArgs() {
+		listArgs, glob := globLabels(args)
+		containers, err := r.docker().ContainerList(ctx, container.ListOptions{All: true, Filters: listArgs})
+		if err != nil {
+			return nil, fmt.Errorf("container list: %w", err)
+		}
+
+		if glob {
+			containers = matchItems(containers, func(c types.Container) map[string]string {
+				return c.Labels
+			}, args.Get("label"))
+		}
+
+		for _, c := range containers {
+			ids[c.ID] = struct{}{}
+		}
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSkipSharedNetworks(t *testing.T) {
+	since := time.Now()
+	created := since.Add(-time.Minute)
+	args := filterArgs(testLabels1)
+
+	cli := &mockClient{}
+	cli.On("ContainerList", mockContext, container.ListOptions{All: true, Filters: args}).
+		Return([]types.Container{{ID: "session1"}, {ID: "session2"}}, nil).Once()
+	cli.On("NetworkList", mockContext, network.ListOptions{Filters: args}).Return([]network.Summary{
+		{ID: "exclusive", Created: created},
+		{ID: "shared", Created: created},
+		{ID: "empty", Created: created},
+		{ID: "broken", Created: created},
+	}, nil)
+	cli.On("NetworkInspect", mockContext, "exclusive", network.InspectOptions{}).Return(network.Inspect{
+		Containers: map[string]network.EndpointResource{"session1": {}, "session2": {}},
+	}, nil)
+	cli.On("NetworkInspect", mockContext, "shared", network.InspectOptions{}).Return(network.Inspect{
+		Containers: map[string]network.EndpointResource{"session1": {}, "monitor": {}},
+	}, nil)
+	cli.On("NetworkInspect", mockContext, "empty", network.InspectOptions{}).Return(network.Inspect{}, nil)
+	cli.On("NetworkInspect", mockContext, "broken", network.InspectOptions{}).Return(network.Inspect{}, errors.New("inspect error"))
+
+	var log safeBuffer
+	cfg := testConfigBase
+	cfg.SkipSharedNetworks = true
+	r := &reaper{
+		cfg:     &cfg,
+		client:  cli,
+		filters: make(map[string]*filterEntry),
+		logger:  slog.New(slog.NewTextHandler(&log, nil)),
+	}
+	require.NoError(t, r.addFilter("client1", filterKey(args)))
+
+	networks, err := r.affectedNetworks(context.Background(), since, args, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"exclusive", "empty", "broken"}, networks)
+
+	data := log.String()
+	require.Contains(t, data, `msg="skipping shared network" id=shared container=monitor`)
+	require.Contains(t, data, `msg="shared network check" error="network inspect: inspect error" network=broken`)
+	cli.AssertExpectations(t)
+
+	t.Run("disabled", func(t *testing.T) {
+		cfg := testConfigBase
+		r := &reaper{cfg: &cfg, client: cli, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+		networks, err := r.affectedNetworks(context.Background(), since, args, nil)
+		require.NoError(t, err)
+		require.Equal(t, []string{"exclusive", "shared", "empty", "broken"}, networks)
+		cli.AssertNumberOfCalls(t, "NetworkInspect", 4)
+	})
+}
+
+func TestNetworkInUse(t *testing.T) {
+	orig := sleepContext
+	t.Cleanup(func() { sleepContext = orig })
+	sleepContext = func(context.Context, time.Duration) error { return nil }
+
+	inUse := errors.New("error while removing network: network test id " + networkID1 + " has active endpoints")
+	cli := &mockClient{}
+	cli.On("NetworkRemove", mockContext, networkID1).Return(inUse).Twice()
+	cli.On("NetworkRemove", mockContext, networkID1).Return(nil).Once()
+	cli.On("NetworkInspect", mockContext, networkID1, network.InspectOptions{}).Return(network.Inspect{
+		Containers: map[string]network.EndpointResource{containerID2: {}, containerID1: {}},
+	}, nil)
+
+	var log safeBuffer
+	cfg := testConfigBase
+	cfg.RemoveRetries = 3
+	cfg.CircuitThreshold = 1
+	r := &reaper{
+		cfg:    &cfg,
+		client: cli,
+		logger: slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	}
+
+	// Removal is retried until the containers are detached.
+	var counts removeCounts
+	errs := r.removeResources(context.Background(), &resources{networks: []string{networkID1}}, &counts, nil)
+	require.NoError(t, errors.Join(errs...))
+	require.EqualValues(t, 1, counts.networks.Load())
+	cli.AssertExpectations(t)
+
+	data := log.String()
+	require.Contains(t, data, `msg="network in use" id=`+networkID1+` containers="[`+containerID1+` `+containerID2+`]"`)
+	require.Contains(t, data, `msg="network in use, will retry" resource=network id=`+networkID1+` attempt=2`)
+	require.NotContains(t, data, "level=ERROR")
+	require.NotContains(t, data, "circuit")
+
+	t.Run("still-attached", func(t *testing.T) {
+		cli := &mockClient{}
+		cli.On("NetworkRemove", mockContext, networkID1).Return(inUse)
+		cli.On("NetworkInspect", mockContext, networkID1, network.InspectOptions{}).Return(network.Inspect{}, errors.New("inspect error"))
+		r.client = cli
+
+		var counts removeCounts
+		errs := r.removeResources(context.Background(), &resources{networks: []string{networkID1}}, &counts, nil)
+		var rerr *removeError
+		require.ErrorAs(t, errors.Join(errs...), &rerr)
+		require.ErrorIs(t, rerr.left[networkID1], errNetworkInUse)
+		cli.AssertNumberOfCalls(t, "NetworkRemove", cfg.RemoveRetries)
+	})
+}
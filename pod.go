@@ -0,0 +1,52 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/docker/docker/api/types"
+)
+
+const (
+	// podLabel is the label which identifies the pod a container belongs to.
+	podLabel = "io.kubernetes.pod.uid"
+
+	// podTypeLabel is the label which identifies the role of a container
+	// within its pod.
+	podTypeLabel = "io.kubernetes.docker.type"
+
+	// podTypeSandbox is the podTypeLabel value of a pod's infra container,
+	// which its sibling containers depend on.
+	podTypeSandbox = "podsandbox"
+)
+
+// podSandbox returns true if c is the infra container of a pod.
+func podSandbox(c types.Container) bool {
+	return c.Labels[podTypeLabel] == podTypeSandbox
+}
+
+// sortPodContainers sorts containers so that containers in the same pod
+// are grouped together and every pod's sandbox container is ordered after
+// all other containers, ensuring children are removed before their parent.
+// Containers not in a pod retain their relative order.
+func sortPodContainers(containers []types.Container) {
+	slices.SortStableFunc(containers, func(a, b types.Container) int {
+		if c := compareBool(podSandbox(a), podSandbox(b)); c != 0 {
+			return c
+		}
+
+		return cmp.Compare(a.Labels[podLabel], b.Labels[podLabel])
+	})
+}
+
+// compareBool compares a and b ordering false before true.
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	default:
+		return -1
+	}
+}
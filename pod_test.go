@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/stretchr/testify/require"
+)
+
+// podContainer returns a container in the given pod, which is the
+// pod's sandbox if sandbox is true.
+func podContainer(id, pod string, sandbox bool) types.Container {
+	labels := map[string]string{podLabel: pod}
+	if sandbox {
+		labels[podTypeLabel] = podTypeSandbox
+	}
+
+	return types.Container{ID: id, Labels: labels}
+}
+
+func TestPodContainersRemoveOrder(t *testing.T) {
+	since := time.Now()
+	args := filterArgs(testLabels1)
+	containers := []types.Container{
+		podContainer("pod1-sandbox", "pod1", true),
+		podContainer("pod2-app", "pod2", false),
+		{ID: "standalone"},
+		podContainer("pod2-sandbox", "pod2", true),
+		podContainer("pod1-app", "pod1", false),
+		podContainer("pod1-sidecar", "pod1", false),
+	}
+	for i := range containers {
+		containers[i].Created = since.Add(-time.Minute).Unix()
+	}
+
+	cfg := testConfigBase
+	r := &reaper{
+		cfg:    &cfg,
+		client: newListMockClient(map[*filters.Args][]types.Container{&args: containers}),
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	ids, _, err := r.affectedContainers(context.Background(), since, args, nil, nil)
+	require.NoError(t, err)
+
+	var removed []string
+	var count atomic.Int64
+	require.NoError(t, r.remove(context.Background(), "container", ids, &count, func(_ context.Context, id string) error {
+		removed = append(removed, id)
+		return nil
+	}))
+
+	require.Equal(t, []string{
+		"standalone",
+		"pod1-app",
+		"pod1-sidecar",
+		"pod2-app",
+		"pod1-sandbox",
+		"pod2-sandbox",
+	}, removed)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// progress periodically logs the progress of removing resources of a
+// single type, so large removals can be seen not to be hung.
+// It isn't safe for concurrent use.
+type progress struct {
+	logger   *slog.Logger
+	interval time.Duration
+
+	// last is when progress was last logged.
+	last time.Time
+
+	// removed is the number of resources removed.
+	removed int
+
+	// total is the number of resources to remove.
+	total int
+}
+
+// newProgress returns a progress which logs to logger at most once every
+// interval while removing total resources. If interval is zero
+// progress is never logged.
+func newProgress(logger *slog.Logger, interval time.Duration, total int) *progress {
+	return &progress{
+		logger:   logger,
+		interval: interval,
+		last:     time.Now(),
+		total:    total,
+	}
+}
+
+// done records a resource as removed, logging progress if the interval
+// has passed since it was last logged and resources remain.
+func (p *progress) done() {
+	p.removed++
+	if p.interval <= 0 || p.removed >= p.total || time.Since(p.last) < p.interval {
+		return
+	}
+
+	p.last = time.Now()
+	p.logger.Info("progress", "removed", p.removed, "remaining", p.total-p.removed)
+}
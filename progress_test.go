@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgress(t *testing.T) {
+	const resources = 5
+
+	tests := map[string]struct {
+		interval time.Duration
+		lines    int
+	}{
+		"disabled": {},
+		"enabled":  {interval: time.Millisecond, lines: resources - 1},
+	}
+
+	ids := make([]string, resources)
+	for i := range ids {
+		ids[i] = strconv.Itoa(i)
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var log safeBuffer
+			cfg := testConfigBase
+			cfg.ProgressInterval = tc.interval
+			r := &reaper{
+				cfg:    &cfg,
+				logger: slog.New(slog.NewTextHandler(&log, nil)),
+			}
+
+			var count atomic.Int64
+			require.NoError(t, r.remove(context.Background(), "container", ids, &count, func(context.Context, string) error {
+				time.Sleep(time.Millisecond * 2)
+				return nil
+			}))
+			require.EqualValues(t, resources, count.Load())
+
+			// Progress is logged after each removal which exceeds the
+			// interval, except the last as nothing remains.
+			require.Equal(t, tc.lines, strings.Count(log.String(), "msg=progress"))
+			for i := 1; i <= tc.lines; i++ {
+				require.Contains(t, log.String(), fmt.Sprintf("level=INFO msg=progress resource=container removed=%d remaining=%d", i, resources-i))
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/docker/docker/api/types/filters"
+)
+
+// pruneCommand is the command used by clients to request an immediate prune
+// of the filters they registered, after which any further lines are rejected.
+const pruneCommand = "PRUNE"
+
+// pruneRequest is a request from a client to prune its filters.
+type pruneRequest struct {
+	// addr is the address of the client.
+	addr string
+
+	// keys are the keys of the filters to prune.
+	keys []string
+
+	// expired is whether the filters exceeded the session TTL, so are
+	// removed once pruned even if their clients are still connected.
+	expired bool
+}
+
+// requestPrune sends a prune request for the filters registered only by the
+// client at addr to pruneWait. Filters shared with other clients are skipped,
+// as their resources may still be in use.
+func (r *reaper) requestPrune(logger *slog.Logger, addr string) {
+	req := pruneRequest{addr: addr}
+
+	r.mtx.Lock()
+	for key, entry := range r.filters {
+		if _, ok := entry.clients[addr]; !ok {
+			continue
+		}
+
+		if len(entry.clients) > 1 {
+			logger.Info("skipping shared filter", "key", key, fieldClients, len(entry.clients))
+			continue
+		}
+
+		req.keys = append(req.keys, key)
+	}
+	r.mtx.Unlock()
+
+	select {
+	case r.pruneRequests <- req:
+	case <-r.shutdown:
+		// The filters will be pruned by the shutdown prune.
+		logger.Warn("prune command ignored, shutting down")
+	}
+}
+
+// pruneClient runs a prune of the filters in req, without shutting down.
+// Once all resources are removed the filters are deleted, otherwise they
+// are left for the final prune to retry. In dry run mode nothing is
+// removed, so they are left for the final prune to report.
+func (r *reaper) pruneClient(req pruneRequest) {
+	go func() {
+		// Wait for any in progress prune to complete.
+		r.pruneMtx.Lock()
+		defer r.pruneMtx.Unlock()
+
+		logger := r.logger.With(fieldAddress, req.addr)
+		if r.holding() {
+			return
+		}
+
+		logger.Info("client prune started", "filters", len(req.keys))
+		var active []filters.Args
+		if r.cfg.ReferenceCounting {
+			active = r.activeFilterArgsExcept(req.keys)
+		}
+
+		since := time.Now().Add(r.cfg.RetryOffset)
+		res, err := r.resourcesFor(since, r.filterArgsFor(req.keys), active)
+		switch {
+		case err == nil:
+		case onlyChanges(err):
+			// Resources which changed are excluded, so prune the rest.
+			logger.Warn("client prune resources", fieldError, err)
+		default:
+			// The exclusions may be incomplete, so leave the filters for
+			// the final prune to retry.
+			logger.Error("client prune resources", fieldError, err)
+			return
+		}
+
+		r.deferImages(res)
+		counts := removeCounts{ids: r.newRemovedIDs()}
+		errs, perr := r.removePass(context.Background(), res, &counts, newGroupSummary(res.groups))
+		if perr != nil {
+			// Nothing was removed, so leave the filters for the final prune.
+			logger.Error("client prune", fieldError, perr)
+			return
+		}
+
+		r.notifyWebhook(res.filters, &counts)
+		removed := counts.logAttrs(r.cfg.ReportSizes)
+		if err := errors.Join(errs...); err != nil {
+			logger.Error("client prune", append(removed, fieldError, err)...)
+			return
+		}
+
+		if err != nil {
+			// Resources which changed weren't removed, so leave the
+			// filters for the final prune to retry.
+			logger.Info("client prune partially completed", removed...)
+			return
+		}
+
+		if r.cfg.DryRun {
+			// Nothing was removed, so keep the filters for the final
+			// prune to report and audit their resources too.
+			logger.Info("client prune completed", append(removed, "dry_run", true)...)
+			return
+		}
+
+		if req.expired {
+			r.removeFilters(req.keys)
+		} else {
+			r.deleteFilters(req.addr, req.keys)
+		}
+
+		// The client is done, so its bind paths are no longer used.
+		if err := r.removeBindPaths(func(addr string) bool { return addr == req.addr }); err != nil {
+			logger.Error("client bind paths", fieldError, err)
+		}
+		logger.Info("client prune completed", removed...)
+	}()
+}
+
+// filterArgsFor returns the filter args of the filters with keys.
+// Safe to call concurrently.
+func (r *reaper) filterArgsFor(keys []string) []filters.Args {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	args := make([]filters.Args, 0, len(keys))
+	for _, key := range keys {
+		if entry, ok := r.filters[key]; ok {
+			args = append(args, entry.args)
+		}
+	}
+
+	return args
+}
+
+// activeFilterArgsExcept returns the filter args of the filters, other than
+// those with keys, which are still registered by a connected client.
+// Safe to call concurrently.
+func (r *reaper) activeFilterArgsExcept(keys []string) []filters.Args {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	var active []filters.Args
+	for key, entry := range r.filters {
+		if len(entry.clients) > 0 && !slices.Contains(keys, key) {
+			active = append(active, entry.args)
+		}
+	}
+
+	return active
+}
+
+// deleteFilters deletes the filters with keys registered by the client at
+// addr, unless they were registered by another client since they were pruned.
+// Safe to call concurrently.
+func (r *reaper) deleteFilters(addr string, keys []string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	for _, key := range keys {
+		entry, ok := r.filters[key]
+		if !ok {
+			continue
+		}
+
+		delete(entry.clients, addr)
+		if len(entry.clients) == 0 {
+			delete(r.filters, key)
+		}
+	}
+}
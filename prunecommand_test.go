@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPruneCommand(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	t.Cleanup(cancel)
+
+	var log safeBuffer
+	logger := withLogger(slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+	})))
+	cli := newMockClient(newRunTest())
+	cfg := testConfigBase
+	cfg.ReconnectionTimeout = time.Minute
+	r, err := newReaper(ctx, logger, withClient(cli), withConfig(cfg))
+	require.NoError(t, err)
+
+	runCtx, runCancel := context.WithCancel(ctx)
+	t.Cleanup(runCancel)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- r.run(runCtx)
+	}()
+
+	// A second client stays connected, which must still be served.
+	addr := r.listener.Addr().String()
+	clientCtx, clientCancel := context.WithCancel(ctx)
+	t.Cleanup(clientCancel)
+	testConnect(clientCtx, t, addr, testLabels2)
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	start := time.Now()
+	_, err = conn.Write([]byte(filterKey(filterArgs(testLabels1)) + "\n" + pruneCommand + "\n"))
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	for range 2 {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		require.Equal(t, "ACK\n", line)
+	}
+
+	// Filters sent after the prune command are rejected.
+	_, err = conn.Write([]byte(filterKey(filterArgs(testLabels2)) + "\n"))
+	require.NoError(t, err)
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "NACK\n", line)
+	require.NoError(t, conn.Close())
+
+	require.Eventually(t, func() bool {
+		return strings.Contains(log.String(), "client prune completed")
+	}, time.Second, time.Millisecond*10, log.String())
+	require.Less(t, time.Since(start), cfg.ReconnectionTimeout)
+	require.Contains(t, log.String(), "containers=1 networks=1 volumes=1 images=1")
+	require.Contains(t, log.String(), `level=WARN msg="message after prune command rejected"`)
+	cli.AssertCalled(t, "ContainerRemove", mockContext, containerID1, containerRemoveOptions)
+	cli.AssertNotCalled(t, "ContainerRemove", mockContext, containerID2, containerRemoveOptions)
+
+	// Other clients can still connect.
+	testConnect(clientCtx, t, addr, testLabels2)
+
+	// Shutdown once all clients disconnect, rather than waiting
+	// for the reconnection timeout.
+	clientCancel()
+	require.Eventually(t, func() bool {
+		return strings.Contains(log.String(), "clients=0")
+	}, time.Second, time.Millisecond*10, log.String())
+	runCancel()
+	select {
+	case err = <-errCh:
+		require.NoError(t, err)
+	case <-ctx.Done():
+		t.Fatal("timeout", log.String())
+	}
+
+	// The pruned filter isn't pruned again.
+	var removed int
+	for _, call := range cli.Calls {
+		if call.Method == "ContainerRemove" && call.Arguments.String(1) == containerID1 {
+			removed++
+		}
+	}
+	require.Equal(t, 1, removed)
+}
+
+func TestPruneClientDryRun(t *testing.T) {
+	// Nothing is removed, so the filters are kept for the final prune,
+	// including those which exceeded the session TTL.
+	for name, expired := range map[string]bool{"command": false, "expired": true} {
+		t.Run(name, func(t *testing.T) {
+			var log safeBuffer
+			cli := newMockClient(newRunTest())
+			cfg := testConfigBase
+			cfg.DryRun = true
+			r, err := newReaper(context.Background(),
+				withLogger(slog.New(slog.NewTextHandler(&log, nil))),
+				withClient(cli),
+				withConfig(cfg),
+			)
+			require.NoError(t, err)
+			t.Cleanup(func() { r.listener.Close() })
+
+			key := filterKey(filterArgs(testLabels1))
+			require.NoError(t, r.addFilter("client1", key))
+			r.pruneClient(pruneRequest{addr: "client1", keys: []string{key}, expired: expired})
+			require.Eventually(t, func() bool {
+				return strings.Contains(log.String(), "client prune completed")
+			}, time.Second, time.Millisecond*10, log.String())
+
+			require.Contains(t, log.String(), "dry_run=true")
+			require.Len(t, r.filterArgs(), 1)
+			cli.AssertNotCalled(t, "ContainerRemove", mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
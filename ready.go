@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// startedMessage returns the message and attributes of the log line which
+// signals the reaper is ready, for the listening address addr.
+//
+// The message, in uppercase, is matched verbatim by different Testcontainers
+// libraries to detect readiness, so it is important to keep it as is to not
+// break the current behavior of the libraries.
+func startedMessage(addr string) (string, []any) {
+	return "Started", []any{fieldAddress, addr}
+}
+
+// readyLine returns the machine parseable line which signals the reaper is
+// ready, for the listening address addr. Unlike the started log line, it is
+// independent of the log format.
+func readyLine(addr string) string {
+	return "RYUK_READY=" + addr + "\n"
+}
+
+// withReadyWriter returns a reaperOption that sets the writer the ready line
+// is written to if enabled.
+// Default: os.Stderr.
+func withReadyWriter(w io.Writer) reaperOption {
+	return func(r *reaper) error {
+		r.readyWriter = w
+		return nil
+	}
+}
+
+// signalReady logs the started message and, if enabled, writes the ready
+// line, for the listening address addr.
+func (r *reaper) signalReady(addr string) {
+	msg, attrs := startedMessage(addr)
+	r.logger.Info(msg, attrs...)
+
+	if r.cfg.EmitReadyEnv {
+		if _, err := fmt.Fprint(r.readyWriter, readyLine(addr)); err != nil {
+			r.logger.Error("ready write", fieldError, err)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_startedMessage(t *testing.T) {
+	var log bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&log, nil))
+	msg, attrs := startedMessage("127.0.0.1:8080")
+	logger.Info(msg, attrs...)
+
+	require.Contains(t, log.String(), "level=INFO msg=Started address=127.0.0.1:8080\n")
+	require.Equal(t, "RYUK_READY=127.0.0.1:8080\n", readyLine("127.0.0.1:8080"))
+}
+
+func TestEmitReadyEnv(t *testing.T) {
+	tests := map[string]struct {
+		emit  bool
+		ready bool
+	}{
+		"disabled": {},
+		"enabled":  {emit: true, ready: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var log safeBuffer
+			var ready bytes.Buffer
+			cfg := testConfigBase
+			cfg.EmitReadyEnv = tc.emit
+			r, err := newReaper(context.Background(),
+				withConfig(cfg),
+				withClient(newListMockClient(nil)),
+				withLogger(slog.New(slog.NewTextHandler(&log, nil))),
+				withReadyWriter(&ready),
+			)
+			require.NoError(t, err)
+			t.Cleanup(func() { r.listener.Close() })
+
+			addr := r.listener.Addr().String()
+			require.Contains(t, log.String(), "level=INFO msg=Started address="+addr+"\n")
+			if tc.ready {
+				require.Equal(t, "RYUK_READY="+addr+"\n", ready.String())
+			} else {
+				require.Empty(t, ready.String())
+			}
+		})
+	}
+}
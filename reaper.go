@@ -3,22 +3,39 @@ package main
 import (
 	"bufio"
 	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log/slog"
+	"maps"
+	"math"
+	"math/rand/v2"
 	"net"
 	"net/url"
 	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/versions"
 	"github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/errdefs"
+	bkclient "github.com/moby/buildkit/client"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 //nolint:gochecknoglobals // Reusable options are fine as globals.
@@ -26,8 +43,34 @@ var (
 	// errChangesDetected is returned when changes are detected.
 	errChangesDetected = errors.New("changes detected")
 
-	// containerRemoveOptions are the options we use to remove a container.
-	containerRemoveOptions = container.RemoveOptions{RemoveVolumes: true, Force: true}
+	// errAlreadyRemoved is returned by a remove function if the resource
+	// was already removed as a side effect of removing another resource.
+	errAlreadyRemoved = errors.New("already removed")
+
+	// errLoadConfig is returned by newReaper if the config is invalid.
+	errLoadConfig = errors.New("load config")
+
+	// errPing is returned by newReaper if the Docker daemon can't be reached.
+	errPing = errors.New("ping")
+
+	// errListen is returned by newReaper if the listener can't be bound.
+	errListen = errors.New("listen")
+
+	// errNoConnection is returned by pruneWait if no client connected
+	// within the connection timeout and a first connection is required.
+	errNoConnection = errors.New("no client connected")
+
+	// errTooManyFilters is returned by addFilter if registering a new
+	// filter would exceed the maximum number of filters.
+	errTooManyFilters = errors.New("too many filters")
+
+	// errTooManyRemovals is returned by prune if the resources to remove
+	// exceed the maximum number of removals, so nothing is removed.
+	errTooManyRemovals = errors.New("too many removals")
+
+	// errImageUntagged is returned by the image remove function if the image
+	// was only untagged, so still exists, and untagged images aren't counted.
+	errImageUntagged = errors.New("image untagged")
 
 	// imageRemoveOptions are the options we use to remove an image.
 	imageRemoveOptions = image.RemoveOptions{PruneChildren: true}
@@ -37,20 +80,85 @@ var (
 
 	// ackResponse is the response we send to the client to acknowledge a filter.
 	ackResponse = []byte("ACK\n")
+
+	// nackResponse is the response we send to the client if a filter
+	// is rejected by the filter schema.
+	nackResponse = []byte("NACK\n")
+
+	// memoryCheckInterval is the interval between checks of the memory used
+	// when a maximum is configured.
+	memoryCheckInterval = time.Second
+
+	// sessionTTLCheckInterval is the maximum interval between checks
+	// for filters which have exceeded the session TTL.
+	sessionTTLCheckInterval = time.Second
+
+	// defaultStopTimeout is Docker's default stop timeout, which bounds
+	// the stop of a container using its own stop timeout.
+	defaultStopTimeout = time.Second * 10
+
+	// removalPollInterval is the interval between checks that a resource
+	// whose removal was already in progress is gone.
+	removalPollInterval = time.Millisecond * 100
+
+	// maintenanceCheckInterval is the maximum interval between checks
+	// that a maintenance window has passed.
+	maintenanceCheckInterval = time.Minute
+
+	// maxRemoveBackoff is the maximum delay between removal attempts.
+	maxRemoveBackoff = time.Minute
+
+	// reconnectInterval is the delay between attempts to reconnect to
+	// Docker after a list failed with a connection error.
+	reconnectInterval = time.Second
+
+	// sleepContext pauses between removal attempts, chunks of removals,
+	// reconnection attempts, before probing the daemon and before removing
+	// deferred images, replaced by tests.
+	sleepContext = waitContext
 )
 
 // reaper listens for connections and prunes resources based on the filters received
 // once a prune condition is met.
 type reaper struct {
-	client       dockerClient
-	listener     net.Listener
-	cfg          *config
-	connected    chan string
-	disconnected chan string
-	shutdown     chan struct{}
-	filters      map[string]filters.Args
-	logger       *slog.Logger
-	mtx          sync.Mutex
+	client         dockerClient
+	newClient      func() (dockerClient, error)
+	shadow         dockerClient
+	buildkit       buildkitClient
+	tlsConfig      *tls.Config
+	filterSchema   *filterSchema
+	listener       net.Listener
+	healthListener net.Listener
+	adminListener  net.Listener
+	readyWriter    io.Writer
+	logFile        *rotatingFile
+	spanExporter   sdktrace.SpanExporter
+	tracerProvider *sdktrace.TracerProvider
+	batch          []string
+	cfg            *config
+	connected      chan string
+	disconnected   chan string
+	shutdown       chan struct{}
+	memoryLimit    chan struct{}
+	forced         chan struct{}
+	manualPrune    chan struct{}
+	decisions      *decisionLog
+	deferredImages *resources
+	pruneRequests  chan pruneRequest
+	handshakes     chan struct{}
+	filters        map[string]*filterEntry
+	bindPaths      map[string]string
+	logger         *slog.Logger
+	mtx            sync.Mutex
+	pruneMtx       sync.Mutex
+	shutdownOnce   sync.Once
+	forceOnce      sync.Once
+	circuit        circuitBreaker
+	auditMtx       sync.Mutex
+	webhooks       sync.WaitGroup
+	clients        atomic.Int64
+	lastPrune      *pruneStatus
+	statusMtx      sync.Mutex
 }
 
 // reaperOption is a function that sets an option on a reaper.
@@ -78,7 +186,7 @@ func withLogger(logger *slog.Logger) reaperOption {
 }
 
 // withClient returns a reaperOption that sets the Docker client.
-// Default: A docker client created with options from the environment.
+// Default: A docker client created by newDockerClient.
 func withClient(client dockerClient) reaperOption {
 	return func(r *reaper) error {
 		r.client = client
@@ -86,19 +194,57 @@ func withClient(client dockerClient) reaperOption {
 	}
 }
 
+// withNewClient returns a reaperOption that sets the function used to
+// create the Docker client if not set, and to recreate it on reconnect.
+// Default: newDockerClient.
+func withNewClient(fn func() (dockerClient, error)) reaperOption {
+	return func(r *reaper) error {
+		r.newClient = fn
+		return nil
+	}
+}
+
+// newDockerClient returns a new Docker client configured from the
+// environment, with the host and TLS settings overridden by the
+// configuration if set.
+func (r *reaper) newDockerClient() (dockerClient, error) {
+	return client.NewClientWithOpts(r.dockerClientOpts()...) //nolint:wrapcheck // Wrapped by caller.
+}
+
+// dockerClientOpts returns the options used to create the Docker client.
+func (r *reaper) dockerClientOpts() []client.Opt {
+	opts := []client.Opt{client.FromEnv}
+	if r.cfg.DockerHost != "" {
+		opts = append(opts, client.WithHost(r.cfg.DockerHost))
+	}
+
+	if r.cfg.DockerTLSCert != "" || r.cfg.DockerTLSKey != "" || r.cfg.DockerTLSCA != "" {
+		opts = append(opts, client.WithTLSClientConfig(r.cfg.DockerTLSCA, r.cfg.DockerTLSCert, r.cfg.DockerTLSKey))
+	}
+
+	return opts
+}
+
 // newReaper creates a new reaper with the specified options.
 // Default options are used if not specified, see the individual
 // options for details.
 func newReaper(ctx context.Context, options ...reaperOption) (*reaper, error) {
 	logLevel := &slog.LevelVar{}
+	defaultLogger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		Level: logLevel,
+	}))
 	r := &reaper{
-		filters:      make(map[string]filters.Args),
-		connected:    make(chan string), // Must be unbuffered to ensure correct behaviour.
-		disconnected: make(chan string),
-		shutdown:     make(chan struct{}),
-		logger: slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
-			Level: logLevel,
-		})),
+		filters:       make(map[string]*filterEntry),
+		bindPaths:     make(map[string]string),
+		connected:     make(chan string), // Must be unbuffered to ensure correct behaviour.
+		disconnected:  make(chan string),
+		shutdown:      make(chan struct{}),
+		memoryLimit:   make(chan struct{}),
+		forced:        make(chan struct{}),
+		manualPrune:   make(chan struct{}, 1),
+		pruneRequests: make(chan pruneRequest),
+		readyWriter:   os.Stderr,
+		logger:        defaultLogger,
 	}
 
 	for _, option := range options {
@@ -108,55 +254,201 @@ func newReaper(ctx context.Context, options ...reaperOption) (*reaper, error) {
 	}
 
 	var err error
+	if r.cfg == nil {
+		// Default configuration loaded from the environment.
+		if r.cfg, err = loadConfig(); err != nil {
+			return nil, fmt.Errorf("%w: %w", errLoadConfig, err)
+		}
+	}
+
+	if r.cfg.LogFile != "" && r.logger == defaultLogger {
+		// The default logger writes to the log file instead of stdout.
+		if r.logFile, err = openRotatingFile(r.cfg.LogFile, r.cfg.LogMaxSize); err != nil {
+			return nil, fmt.Errorf("log file: %w", err)
+		}
+
+		r.logger = slog.New(slog.NewTextHandler(r.logFile, &slog.HandlerOptions{
+			Level: logLevel,
+		}))
+	}
+
+	if err = r.initTracing(ctx); err != nil {
+		return nil, fmt.Errorf("tracing: %w", err)
+	}
+
+	if r.newClient == nil {
+		r.newClient = r.newDockerClient
+	}
+
 	if r.client == nil {
-		// Default client configured from the environment.
-		if r.client, err = client.NewClientWithOpts(client.FromEnv); err != nil {
+		// Default client configured from the configuration and environment.
+		if r.client, err = r.newClient(); err != nil {
 			return nil, fmt.Errorf("new client: %w", err)
 		}
 	}
 
 	r.client.NegotiateAPIVersion(ctx)
 
-	if r.cfg == nil {
-		// Default configuration loaded from the environment.
-		if r.cfg, err = loadConfig(); err != nil {
-			return nil, fmt.Errorf("load config: %w", err)
+	if r.shadow == nil && r.cfg.ShadowDockerHost != "" {
+		if r.shadow, err = r.newShadowClient(); err != nil {
+			return nil, fmt.Errorf("new shadow client: %w", err)
 		}
 	}
 
+	if r.shadow != nil {
+		r.shadow.NegotiateAPIVersion(ctx)
+	}
+
 	if r.cfg.Verbose {
 		logLevel.Set(slog.LevelDebug)
 	}
 
+	if r.cfg.MaxConcurrentHandshakes > 0 {
+		r.handshakes = make(chan struct{}, r.cfg.MaxConcurrentHandshakes)
+	}
+
+	if r.tlsConfig == nil {
+		if err = r.loadTLSConfig(); err != nil {
+			return nil, fmt.Errorf("%w: tls config: %w", errLoadConfig, err)
+		}
+	}
+
+	if err = r.loadFilterSchema(); err != nil {
+		return nil, fmt.Errorf("%w: filter schema: %w", errLoadConfig, err)
+	}
+
+	r.decisions = newDecisionLog(r.cfg.DecisionLogSize)
+
+	if r.buildkit == nil && r.cfg.BuildkitAddr != "" {
+		// Connections are established lazily, so an unreachable
+		// daemon is reported when pruning.
+		if r.buildkit, err = bkclient.New(ctx, r.cfg.BuildkitAddr); err != nil {
+			return nil, fmt.Errorf("new buildkit client: %w", err)
+		}
+	}
+
 	pingCtx, cancel := context.WithTimeout(ctx, r.cfg.RequestTimeout)
 	defer cancel()
 
 	if _, err = r.client.Ping(pingCtx); err != nil {
-		return nil, fmt.Errorf("ping: %w", err)
+		return nil, fmt.Errorf("%w: %w", errPing, err)
+	}
+
+	if err = r.checkEnvironment(ctx); err != nil {
+		return nil, fmt.Errorf("check environment: %w", err)
 	}
 
-	r.logger.LogAttrs(ctx, slog.LevelInfo, "starting", r.cfg.LogAttrs()...)
-	if r.listener, err = net.Listen("tcp", fmt.Sprintf(":%d", r.cfg.Port)); err != nil {
-		return nil, fmt.Errorf("listen: %w", err)
+	// The negotiated version is only known once the daemon has been pinged.
+	apiVersion := r.client.ClientVersion()
+	r.logger.LogAttrs(ctx, slog.LevelInfo, "starting", append(r.cfg.LogAttrs(), slog.String("api_version", apiVersion))...)
+	if versions.LessThan(apiVersion, minAPIVersion) {
+		r.logger.Warn("docker api version below minimum, image label filters may be unreliable", "api_version", apiVersion, "min_api_version", minAPIVersion)
+	}
+	if err = r.cfg.consistent(); err != nil {
+		r.logger.Warn("config", fieldError, err)
+	}
+	for name, replacement := range r.cfg.deprecated() {
+		r.logger.Warn("deprecated option", "name", name, "replacement", replacement)
+	}
+
+	if len(r.batch) > 0 {
+		// Batch filters are reaped once, so there are no clients to listen for.
+		if err = r.addBatchFilters(); err != nil {
+			return nil, fmt.Errorf("batch: %w", err)
+		}
+
+		return r, nil
+	}
+
+	if err = r.listenHealth(); err != nil {
+		return nil, fmt.Errorf("%w: health: %w", errListen, err)
 	}
 
-	// This log message, in uppercase, is in use in different Testcontainers libraries,
-	// so it is important to keep it as is to not break the current behavior of the libraries.
-	r.logger.Info("Started", fieldAddress, r.listener.Addr().String())
+	if err = r.listenAdmin(); err != nil {
+		r.closeListeners()
+		return nil, fmt.Errorf("%w: admin: %w", errListen, err)
+	}
+
+	if r.listener, err = net.Listen("tcp", net.JoinHostPort(r.cfg.BindAddr, strconv.Itoa(int(r.cfg.Port)))); err != nil {
+		r.closeListeners()
+		return nil, fmt.Errorf("%w: %w", errListen, err)
+	}
+
+	r.signalReady(r.listener.Addr().String())
 
 	return r, nil
 }
 
+// closeListeners closes the health and admin listeners which are open,
+// so they aren't leaked if newReaper fails after opening them.
+func (r *reaper) closeListeners() {
+	for _, listener := range []net.Listener{r.healthListener, r.adminListener} {
+		if listener != nil {
+			listener.Close()
+		}
+	}
+}
+
 // run starts the reaper which prunes resources when:
 //   - Signalled by the context
 //   - No connections are received within the connection timeout
 //   - A connection is received and no further connections are received within the reconnection timeout
 func (r *reaper) run(ctx context.Context) error {
+	if r.logFile != nil {
+		// Registered first so it's closed after the last log.
+		defer r.logFile.Close()
+	}
+
+	// Registered before everything but the log file so spans are exported last.
+	defer r.shutdownTracing()
+
+	if r.cfg.SelfRemove {
+		// Registered before everything else so it runs last.
+		defer r.removeSelf()
+	}
+
 	defer r.logger.Info("done")
+	defer r.closeBuildkit()
+
+	// Wait for webhook deliveries started by prunes.
+	defer r.webhooks.Wait()
 
 	// Process incoming connections.
 	go r.processClients()
 
+	if r.healthListener != nil {
+		healthCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		go r.serveHealth(healthCtx)
+	}
+
+	if r.adminListener != nil {
+		adminCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		go r.serveAdmin(adminCtx)
+	}
+
+	if r.cfg.MaxMemory > 0 {
+		monitorCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		go r.monitorMemory(monitorCtx)
+	}
+
+	if r.cfg.PingInterval > 0 {
+		monitorCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		go r.monitorDocker(monitorCtx)
+	}
+
+	if r.cfg.ManualPrune {
+		stop := notifyManualPrune(r.manualPrune)
+		defer stop()
+	}
+
 	// Wait for all tasks to complete.
 	if err := r.pruner(ctx); err != nil {
 		if errors.Is(err, context.Canceled) {
@@ -177,11 +469,229 @@ func (r *reaper) pruner(ctx context.Context) error {
 		errs = append(errs, fmt.Errorf("prune wait: %w", err))
 	}
 
-	if err = r.prune(resources); err != nil { //nolint:contextcheck // Prune needs its own context to ensure clean up completes.
+	// Prune needs its own context to ensure clean up completes, but a
+	// prune already running is abandoned if shutdown is forced.
+	pruneCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	select {
+	case <-r.forced:
+		// Shutdown was forced while waiting, which forced this prune.
+	default:
+		go func() {
+			select {
+			case <-r.forced:
+				cancel()
+			case <-pruneCtx.Done():
+			}
+		}()
+	}
+
+	r.waitRelease(pruneCtx)
+	r.compareShadow(resources)
+
+	// Wait for any in progress manual prune to complete.
+	r.pruneMtx.Lock()
+	defer r.pruneMtx.Unlock()
+
+	// A forced prune is bounded so the reaper can't be stuck retrying.
+	var forced *forcedPruneError
+	if errors.As(err, &forced) {
+		var cancelForced context.CancelFunc
+		pruneCtx, cancelForced = context.WithTimeout(pruneCtx, r.cfg.ShutdownTimeout)
+		defer cancelForced()
+	}
+
+	images := r.takeDeferredImages(resources)
+	if err = r.prune(pruneCtx, resources); err != nil { //nolint:contextcheck // Prune needs its own context to ensure clean up completes.
 		errs = append(errs, fmt.Errorf("prune: %w", err))
 	}
 
-	return errors.Join(errs...)
+	if err = r.pruneDeferredImages(pruneCtx, images); err != nil { //nolint:contextcheck // Prune needs its own context to ensure clean up completes.
+		errs = append(errs, fmt.Errorf("prune deferred images: %w", err))
+	}
+
+	err = errors.Join(errs...)
+	if err != nil && r.cfg.ShutdownBestEffortOK && errors.As(err, &forced) {
+		// Incomplete clean up after a forced prune is accepted.
+		r.logger.Error("forced prune incomplete", fieldError, err)
+		return nil
+	}
+
+	return err
+}
+
+// handleSignals cancels the run context with cancel when the first signal
+// is received on signals and forces shutdown when a second is received,
+// as orchestrators escalate signals when shutdown is slow.
+func (r *reaper) handleSignals(signals <-chan os.Signal, cancel context.CancelFunc) {
+	if _, ok := <-signals; !ok {
+		return
+	}
+	cancel()
+
+	if sig, ok := <-signals; ok {
+		r.logger.Warn("second signal received, forcing shutdown", "signal", sig)
+		r.forceShutdown()
+	}
+}
+
+// forceShutdown abandons waiting for clients and changes to settle and
+// any prune in progress, so the reaper exits after a best effort prune.
+// Safe to call concurrently, only the first call has any effect.
+func (r *reaper) forceShutdown() {
+	r.forceOnce.Do(func() {
+		close(r.forced)
+	})
+}
+
+// forcedPruneError is returned by pruneWait when a best effort prune
+// was forced as changes were still detected at the shutdown timeout.
+type forcedPruneError struct {
+	err error
+}
+
+// Error implements error.
+func (e *forcedPruneError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *forcedPruneError) Unwrap() error {
+	return e.err
+}
+
+// pruneNow runs a best effort prune of the resources matching the current
+// filters, without shutting down. It is ignored if a prune is already in progress.
+func (r *reaper) pruneNow() {
+	if !r.pruneMtx.TryLock() {
+		r.logger.Warn("manual prune ignored, prune in progress")
+		return
+	}
+
+	go func() {
+		defer r.pruneMtx.Unlock()
+
+		r.logger.Info("manual prune started")
+		resources, err := r.resources(time.Now().Add(r.cfg.RetryOffset))
+		if err != nil {
+			// Resources which changed are excluded, so prune the rest.
+			r.logger.Warn("manual prune resources", fieldError, err)
+		}
+
+		r.deferImages(resources)
+		if err = r.prune(context.Background(), resources); err != nil {
+			r.logger.Error("manual prune", fieldError, err)
+			return
+		}
+
+		r.logger.Info("manual prune completed")
+	}()
+}
+
+// monitorMemory checks the memory used by the process and closes memoryLimit
+// if it exceeds the configured maximum so a prune is triggered before the
+// reaper is killed by the OOM killer, which would leak resources.
+func (r *reaper) monitorMemory(ctx context.Context) {
+	ticker := time.NewTicker(memoryCheckInterval)
+	defer ticker.Stop()
+
+	var stats runtime.MemStats
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			runtime.ReadMemStats(&stats)
+			if stats.Sys <= r.cfg.MaxMemory {
+				continue
+			}
+
+			r.logger.Warn("memory limit exceeded", "memory", stats.Sys, "max_memory", r.cfg.MaxMemory)
+			close(r.memoryLimit)
+			return
+		}
+	}
+}
+
+// docker returns the current Docker client.
+// Safe to call concurrently.
+func (r *reaper) docker() dockerClient {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	return r.client
+}
+
+// monitorDocker periodically pings Docker and recreates the client if
+// the ping fails, so prunes work after a daemon restart.
+func (r *reaper) monitorDocker(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := r.ping(ctx, r.docker())
+			if err == nil {
+				continue
+			}
+
+			r.logger.Warn("reconnecting docker client", fieldError, err)
+			if err = r.reconnect(ctx); err != nil {
+				r.logger.Error("docker reconnect", fieldError, err)
+				continue
+			}
+
+			r.logger.Info("docker client reconnected")
+		}
+	}
+}
+
+// ping pings Docker using cli with the request timeout.
+func (r *reaper) ping(ctx context.Context, cli dockerClient) error {
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.RequestTimeout)
+	defer cancel()
+
+	if _, err := cli.Ping(ctx); err != nil {
+		return fmt.Errorf("ping: %w", err)
+	}
+
+	return nil
+}
+
+// reconnect creates a new Docker client and replaces the current one
+// if it can successfully ping Docker. The client which isn't kept is
+// closed so its connections aren't leaked.
+func (r *reaper) reconnect(ctx context.Context) error {
+	cli, err := r.newClient()
+	if err != nil {
+		return fmt.Errorf("new client: %w", err)
+	}
+
+	cli.NegotiateAPIVersion(ctx)
+	if err = r.ping(ctx, cli); err != nil {
+		r.closeClient(cli)
+		return err
+	}
+
+	r.mtx.Lock()
+	old := r.client
+	r.client = cli
+	r.mtx.Unlock()
+
+	r.closeClient(old)
+
+	return nil
+}
+
+// closeClient closes cli, logging any error.
+func (r *reaper) closeClient(cli dockerClient) {
+	if err := cli.Close(); err != nil {
+		r.logger.Warn("docker client close", fieldError, err)
+	}
 }
 
 // processClients listens for incoming connections and processes them.
@@ -224,24 +734,96 @@ func (r *reaper) handle(conn net.Conn) {
 	addr := conn.RemoteAddr().String()
 	defer func() {
 		conn.Close()
+		r.releaseFilters(addr)
 		r.disconnected <- addr
 	}()
 
 	logger := r.logger.With(fieldAddress, addr)
 
-	// Read filters from the client and add them to our list.
+	// Connections are queued until a handshake slot is available, which
+	// is held until the first message is received.
+	release := r.acquireHandshake()
+	defer release()
+
+	if r.cfg.ClientReadTimeout > 0 {
+		// Clients must send their first message within the timeout,
+		// after which the connection can be idle for the session.
+		if err := conn.SetReadDeadline(time.Now().Add(r.cfg.ClientReadTimeout)); err != nil {
+			logger.Error("set read deadline", fieldError, err)
+			return
+		}
+	}
+
+	sconn, err := r.serverConn(conn)
+	if err != nil {
+		logger.Error("server conn", fieldError, err)
+		return
+	}
+	conn = sconn
+
 	scanner := bufio.NewScanner(conn)
+	if r.cfg.ConnectionToken != "" && !r.authenticate(scanner) {
+		logger.Warn("auth failed")
+		return
+	}
+
+	// Read filters from the client and add them to our list, until the
+	// client sends the prune command. The filters to prune are then
+	// committed, so any further lines are rejected until the client
+	// closes the connection.
+	first := true
+	pruned := false
 	for scanner.Scan() {
 		msg := scanner.Text()
+		release()
+		if first && r.cfg.ClientReadTimeout > 0 {
+			if err := conn.SetReadDeadline(time.Time{}); err != nil {
+				logger.Error("clear read deadline", fieldError, err)
+				return
+			}
+		}
+		first = false
 
-		switch msg {
-		case "":
+		switch {
+		case msg == "":
 			logger.Warn("empty filter received")
 			continue
+		case pruned:
+			logger.Warn("message after prune command rejected", "message", msg)
+			if _, err := conn.Write(nackResponse); err != nil {
+				logger.Error("ack write", fieldError, err)
+			}
+		case strings.HasPrefix(msg, bindPathCommand):
+			response := ackResponse
+			if err := r.addBindPath(addr, strings.TrimPrefix(msg, bindPathCommand)); err != nil {
+				// The client is told the path won't be removed.
+				logger.Error("add bind path", fieldError, err)
+				response = nackResponse
+			}
+
+			if _, err := conn.Write(response); err != nil {
+				logger.Error("ack write", fieldError, err)
+			}
+		case msg == pruneCommand:
+			if _, err := conn.Write(ackResponse); err != nil {
+				logger.Error("ack write", fieldError, err)
+			}
+
+			r.requestPrune(logger, addr)
+			pruned = true
 		default:
-			if err := r.addFilter(msg); err != nil {
+			if err := r.addFilter(addr, msg); err != nil {
 				logger.Error("add filter", fieldError, err)
-				if _, err = conn.Write(ackResponse); err != nil {
+				if errors.Is(err, errTooManyFilters) {
+					// Protect the reaper by dropping the client.
+					return
+				}
+
+				response := ackResponse
+				if r.cfg.StrictFilters || errors.Is(err, errFilterNotAllowed) || errors.Is(err, errFilterTypeUnsupported) {
+					response = nackResponse
+				}
+				if _, err = conn.Write(response); err != nil {
 					logger.Error("ack write", fieldError, err)
 				}
 				continue
@@ -254,28 +836,65 @@ func (r *reaper) handle(conn net.Conn) {
 	}
 
 	if err := scanner.Err(); err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			logger.Warn("client read timeout, dropping client", "timeout", r.cfg.ClientReadTimeout)
+			return
+		}
 		logger.Error("scan", fieldError, err)
 	}
 }
 
+// authenticate returns true if the first line read by scanner is the
+// configured connection token, compared in constant time.
+func (r *reaper) authenticate(scanner *bufio.Scanner) bool {
+	if !scanner.Scan() {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare(scanner.Bytes(), []byte(r.cfg.ConnectionToken)) == 1
+}
+
 // resources represents the resources to prune.
 type resources struct {
 	containers []string
 	networks   []string
 	volumes    []string
 	images     []string
+
+	// filters are the filters the resources were listed with.
+	filters []filters.Args
+
+	// groups is the summary group label value of each resource,
+	// only populated if a summary group label is configured.
+	groups map[string]string
+
+	// sizes is the size in bytes of each resource, only populated
+	// if sizes are reported.
+	sizes map[string]int64
+
+	// sessions are the resources of each filter, only populated
+	// if a session deadline is configured.
+	sessions []*resources
+
+	// sessionIDs is the session ID of each resource, from the session
+	// ID label filter it was listed with, if any.
+	sessionIDs map[string]string
+
+	// changedSessions are the session IDs of the filters for which
+	// changes were detected.
+	changedSessions []string
+
+	// since is the time resources created after were excluded as changes.
+	since time.Time
 }
 
 // shutdownListener ensures that the listener is shutdown and no new clients
-// are accepted.
+// are accepted. Safe to call concurrently, only the first call has any effect.
 func (r *reaper) shutdownListener() {
-	select {
-	case <-r.shutdown:
-		return // Already shutdown.
-	default:
+	r.shutdownOnce.Do(func() {
 		close(r.shutdown)
 		r.listener.Close()
-	}
+	})
 }
 
 // pruneWait waits for a prune condition to be met and returns the resources to prune.
@@ -284,24 +903,66 @@ func (r *reaper) pruneWait(ctx context.Context) (*resources, error) {
 	defer r.shutdownListener()
 
 	clients := 0
+	// seen is the set of distinct client addresses which have connected.
+	seen := make(map[string]struct{})
+	_, span := r.startSpan(ctx, "pruneWait")
+	defer func() {
+		span.SetAttributes(attrClients.Int(len(seen)))
+		span.End()
+	}()
 	pruneCheck := time.NewTicker(r.cfg.ConnectionTimeout)
+	start := time.Now()
+	// nextCheck is when the next prune check is due, zero if none is scheduled.
+	nextCheck := start.Add(r.cfg.ConnectionTimeout)
+	resetCheck := func(d time.Duration) {
+		pruneCheck.Reset(d)
+		nextCheck = time.Now().Add(d)
+	}
+	var heartbeat <-chan time.Time
+	if r.cfg.HeartbeatInterval > 0 {
+		ticker := time.NewTicker(r.cfg.HeartbeatInterval)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+	var sessionTTL <-chan time.Time
+	if r.cfg.SessionTTL > 0 {
+		ticker := time.NewTicker(min(r.cfg.SessionTTL, sessionTTLCheckInterval))
+		defer ticker.Stop()
+		sessionTTL = ticker.C
+	}
 	done := ctx.Done()
+	memoryLimit := r.memoryLimit
+	forced := r.forced
 	var shutdownDeadline time.Time
+	// graced is set once the reconnection grace has been waited since
+	// the last client disconnected.
+	var graced bool
 	for {
 		select {
 		case addr := <-r.connected:
 			clients++
+			r.clients.Store(int64(clients))
+			seen[addr] = struct{}{}
+			graced = false
 			r.logger.Info("client connected", fieldAddress, addr, fieldClients, clients)
 			if clients == 1 {
 				pruneCheck.Stop()
+				nextCheck = time.Time{}
 			}
 		case addr := <-r.disconnected:
 			clients--
+			r.clients.Store(int64(clients))
 			r.logger.Info("client disconnected", fieldAddress, addr, fieldClients, clients)
 			if clients == 0 {
+				if len(seen) < r.cfg.MinClients && done != nil {
+					// Not all expected clients have connected yet.
+					r.logger.Info("waiting for minimum clients", "seen", len(seen), "min_clients", r.cfg.MinClients)
+					continue
+				}
+
 				// No clients connected, trigger prune check overriding
 				// any timeout set by shutdown signal.
-				pruneCheck.Reset(r.cfg.ReconnectionTimeout)
+				resetCheck(r.cfg.ReconnectionTimeout)
 			}
 		case <-done:
 			r.logger.Info("signal received", fieldClients, clients, "shutdown_timeout", r.cfg.ShutdownTimeout)
@@ -316,26 +977,75 @@ func (r *reaper) pruneWait(ctx context.Context) (*resources, error) {
 				timeout = time.Nanosecond
 			}
 
-			pruneCheck.Reset(timeout)
+			resetCheck(timeout)
+			done = nil
+		case now := <-heartbeat:
+			attrs := []any{fieldClients, clients, "elapsed", now.Sub(start).Round(time.Millisecond)}
+			if !nextCheck.IsZero() {
+				attrs = append(attrs, "next_check", nextCheck.Sub(now).Round(time.Millisecond))
+			}
+			r.logger.Info("idle", attrs...)
+		case <-r.manualPrune:
+			r.pruneNow()
+		case req := <-r.pruneRequests:
+			r.pruneClient(req)
+		case now := <-sessionTTL:
+			r.checkSessionTTL(now)
+		case <-memoryLimit:
+			r.logger.Warn("memory limit exceeded, forcing prune", fieldClients, clients)
+			// Shutdown and force an immediate best effort prune, without
+			// waiting for clients or changes to settle.
+			r.shutdownListener()
+			shutdownDeadline = time.Now()
+			resetCheck(time.Nanosecond)
+			done = nil
+			memoryLimit = nil
+		case <-forced:
+			r.logger.Warn("forced shutdown, forcing prune", fieldClients, clients)
+			// Abandon waiting for clients or changes to settle.
+			r.shutdownListener()
+			shutdownDeadline = time.Now()
+			resetCheck(time.Nanosecond)
 			done = nil
+			forced = nil
 		case now := <-pruneCheck.C:
-			level := slog.LevelInfo
-			if clients > 0 {
-				level = slog.LevelWarn
+			if r.cfg.RequireFirstConnection && len(seen) == 0 && shutdownDeadline.IsZero() {
+				r.logger.Error("no client connected", "connection_timeout", r.cfg.ConnectionTimeout)
+				return &resources{}, errNoConnection
 			}
-			r.logger.Log(context.Background(), level, "prune check", fieldClients, clients) //nolint:contextcheck // Ensure log is written.
 
-			resources, err := r.resources(now.Add(r.cfg.RetryOffset)) //nolint:contextcheck // Needs its own context to ensure clean up completes.
+			if r.cfg.ReconnectionGrace > 0 && !graced && len(seen) > 0 && shutdownDeadline.IsZero() {
+				// Wait a little longer in case a client is about to reconnect.
+				r.logger.Info("reconnection grace", "grace", r.cfg.ReconnectionGrace)
+				graced = true
+				resetCheck(r.cfg.ReconnectionGrace)
+				continue
+			}
+
+			if wait := r.maintenanceWait(now, shutdownDeadline); wait > 0 {
+				r.logger.Warn("maintenance window, deferring prune", "until", r.cfg.MaintenanceUntil, "recheck", wait)
+				resetCheck(wait)
+				continue
+			}
+
+			level := slog.LevelInfo
+			if clients > 0 {
+				level = slog.LevelWarn
+			}
+			r.logger.Log(context.Background(), level, "prune check", fieldClients, clients) //nolint:contextcheck // Ensure log is written.
+
+			resources, err := r.resources(now.Add(r.cfg.RetryOffset)) //nolint:contextcheck // Needs its own context to ensure clean up completes.
 			if err != nil {
 				if errors.Is(err, errChangesDetected) {
 					if shutdownDeadline.IsZero() || now.Before(shutdownDeadline) {
-						r.logger.Warn("change detected, waiting again", fieldError, err)
-						pruneCheck.Reset(r.cfg.ChangesRetryInterval)
+						r.logger.Warn("change detected, waiting again", fieldError, err, "session", resources.changedSessions)
+						resetCheck(r.cfg.ChangesRetryInterval)
 						continue
 					}
 
 					// Still changes detected after shutdown timeout, force best effort prune.
-					r.logger.Warn("shutdown timeout reached, forcing prune", fieldError, err)
+					r.logger.Warn("shutdown timeout reached, forcing prune", fieldError, err, "session", resources.changedSessions)
+					return resources, &forcedPruneError{err: fmt.Errorf("resources: %w", err)}
 				}
 
 				return resources, fmt.Errorf("resources: %w", err)
@@ -349,11 +1059,190 @@ func (r *reaper) pruneWait(ctx context.Context) (*resources, error) {
 // resources returns the resources that match the collected filters
 // for which there are no changes detected.
 func (r *reaper) resources(since time.Time) (*resources, error) {
-	var ret resources
+	filterArgs := r.filterArgs()
+	var active []filters.Args
+	if r.cfg.ReferenceCounting {
+		filterArgs, active = r.partitionFilterArgs()
+	}
+
+	return r.resourcesFor(since, filterArgs, active)
+}
+
+// resourcesFor returns the resources that match filterArgs for which
+// there are no changes detected, excluding those which match a filter in
+// active or belong to the reaper itself if configured. It's the shared
+// entry point of every listing, so all the exclusions apply to each prune.
+func (r *reaper) resourcesFor(since time.Time, filterArgs, active []filters.Args) (*resources, error) {
+	// Resources are listed once for all filters if combined listing is
+	// enabled, and volume sizes are requested once if reported.
+	ctx := r.withVolumeSizes(r.withCombinedLists(context.Background()))
+	ret := resources{groups: r.newGroups(), sizes: r.newSizes(), sessionIDs: make(map[string]string), since: since}
 	var errs []error
+
+	// Resources matched by a subsumed filter are listed by the other.
+	if deduped := dropSubsumed(filterArgs); len(deduped) < len(filterArgs) {
+		r.logger.Debug("dropped subsumed filters", "count", len(filterArgs)-len(deduped))
+		filterArgs = deduped
+	}
+	ret.filters = filterArgs
+
+	ctx, span := r.startSpan(ctx, "resources", attrFilters.Int(len(filterArgs)))
+	defer func() {
+		span.SetAttributes(resourceCountAttrs(&ret)...)
+		span.End()
+	}()
+
 	// We combine errors so we can do best effort removal.
-	for _, args := range r.filterArgs() {
-		containers, err := r.affectedContainers(since, args)
+	for _, args := range filterArgs {
+		res, err := r.sessionResources(ctx, since, args)
+		if err != nil {
+			errs = append(errs, err)
+			if session := r.sessionID(args); session != "" && errors.Is(err, errChangesDetected) {
+				ret.changedSessions = append(ret.changedSessions, session)
+			}
+		}
+
+		ret.containers = append(ret.containers, res.containers...)
+		ret.networks = append(ret.networks, res.networks...)
+		ret.volumes = append(ret.volumes, res.volumes...)
+		ret.images = append(ret.images, res.images...)
+		maps.Copy(ret.groups, res.groups)
+		maps.Copy(ret.sizes, res.sizes)
+		maps.Copy(ret.sessionIDs, res.sessionIDs)
+		if r.cfg.SessionDeadline > 0 {
+			ret.sessions = append(ret.sessions, res)
+		}
+	}
+
+	if len(active) > 0 {
+		if err := r.excludeActive(ctx, since, active, &ret); err != nil {
+			errs = append(errs, fmt.Errorf("exclude active: %w", err))
+		}
+	}
+
+	if r.cfg.ProtectSelf {
+		if err := r.excludeSelf(&ret); err != nil {
+			errs = append(errs, fmt.Errorf("exclude self: %w", err))
+		}
+	}
+
+	return &ret, errors.Join(errs...)
+}
+
+// sessionResources returns the resources that match args, the filter of a
+// single session, bounded by the session deadline if configured.
+func (r *reaper) sessionResources(ctx context.Context, since time.Time, args filters.Args) (*resources, error) {
+	if r.cfg.SessionDeadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.cfg.SessionDeadline)
+		defer cancel()
+	}
+
+	res, err := r.affectedResources(ctx, since, args)
+	if ctx.Err() != nil {
+		r.logger.Warn("session deadline exceeded listing", "filter", filterKey(args), "deadline", r.cfg.SessionDeadline)
+	}
+	res.filters = []filters.Args{args}
+	recordSessionIDs(res, r.sessionID(args))
+
+	return res, err
+}
+
+// excludeActive removes any resources from res which match a filter in active,
+// which are the filters still referenced by a connected client.
+// If active resources can't be determined an error is returned and res is cleared
+// as it's not safe to remove resources which may still be in use.
+func (r *reaper) excludeActive(ctx context.Context, since time.Time, active []filters.Args, res *resources) error {
+	inUse := make(map[string]struct{})
+	for _, args := range active {
+		owned, err := r.affectedResources(ctx, since, args)
+		if err != nil && !onlyChanges(err) {
+			*res = resources{}
+			return err
+		}
+
+		for _, ids := range [][]string{owned.containers, owned.networks, owned.volumes, owned.images} {
+			for _, id := range ids {
+				inUse[id] = struct{}{}
+			}
+		}
+	}
+
+	r.exclude(res, inUse, "skipping resource referenced by connected client")
+
+	return nil
+}
+
+// exclude removes the resources whose ID is in ids from res and its
+// sessions, logging each one skipped with msg.
+func (r *reaper) exclude(res *resources, ids map[string]struct{}, msg string) {
+	keep := func(resourceType string, list []string) []string {
+		return slices.DeleteFunc(list, func(id string) bool {
+			if _, ok := ids[id]; ok {
+				r.logger.Info(msg, "resource", resourceType, "id", id)
+				return true
+			}
+			return false
+		})
+	}
+
+	res.containers = keep("container", res.containers)
+	res.networks = keep("network", res.networks)
+	res.volumes = keep("volume", res.volumes)
+	res.images = keep("image", res.images)
+
+	// Skips were logged above, so just drop them from each session.
+	drop := func(id string) bool {
+		_, ok := ids[id]
+		return ok
+	}
+	for _, session := range res.sessions {
+		session.containers = slices.DeleteFunc(session.containers, drop)
+		session.networks = slices.DeleteFunc(session.networks, drop)
+		session.volumes = slices.DeleteFunc(session.volumes, drop)
+		session.images = slices.DeleteFunc(session.images, drop)
+	}
+}
+
+// onlyChanges returns true if err is made up only of errChangesDetected errors.
+func onlyChanges(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, errChangesDetected) {
+		if joined, ok := err.(interface{ Unwrap() []error }); ok { //nolint:errorlint // We need to check every joined error.
+			for _, e := range joined.Unwrap() {
+				if !onlyChanges(e) {
+					return false
+				}
+			}
+
+			return true
+		}
+
+		if wrapped := errors.Unwrap(err); wrapped != nil {
+			return onlyChanges(wrapped)
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// affectedResources returns the resources that match args for which
+// there are no changes detected. Listing is bounded by ctx.
+func (r *reaper) affectedResources(ctx context.Context, since time.Time, args filters.Args) (*resources, error) {
+	ret := resources{groups: r.newGroups(), sizes: r.newSizes()}
+	var errs []error
+
+	// We combine errors so we can do best effort removal.
+	var keptImages []string
+	if r.cfg.PruneContainers && r.filterSupported("container", args) {
+		var containers []string
+		var err error
+		containers, keptImages, err = r.affectedContainers(ctx, since, args, ret.groups, ret.sizes)
 		if err != nil {
 			if !errors.Is(err, errChangesDetected) {
 				r.logger.Error("affected containers", fieldError, err)
@@ -361,9 +1250,11 @@ func (r *reaper) resources(since time.Time) (*resources, error) {
 			errs = append(errs, fmt.Errorf("affected containers: %w", err))
 		}
 
-		ret.containers = append(ret.containers, containers...)
+		ret.containers = containers
+	}
 
-		networks, err := r.affectedNetworks(since, args)
+	if r.cfg.PruneNetworks && r.filterSupported("network", args) {
+		networks, err := r.affectedNetworks(ctx, since, args, ret.groups)
 		if err != nil {
 			if !errors.Is(err, errChangesDetected) {
 				r.logger.Error("affected networks", fieldError, err)
@@ -371,9 +1262,11 @@ func (r *reaper) resources(since time.Time) (*resources, error) {
 			errs = append(errs, fmt.Errorf("affected networks: %w", err))
 		}
 
-		ret.networks = append(ret.networks, networks...)
+		ret.networks = networks
+	}
 
-		volumes, err := r.affectedVolumes(since, args)
+	if r.cfg.PruneVolumes && r.filterSupported("volume", args) {
+		volumes, err := r.affectedVolumes(ctx, since, args, ret.groups, ret.sizes)
 		if err != nil {
 			if !errors.Is(err, errChangesDetected) {
 				r.logger.Error("affected volumes", fieldError, err)
@@ -381,9 +1274,11 @@ func (r *reaper) resources(since time.Time) (*resources, error) {
 			errs = append(errs, fmt.Errorf("affected volumes: %w", err))
 		}
 
-		ret.volumes = append(ret.volumes, volumes...)
+		ret.volumes = volumes
+	}
 
-		images, err := r.affectedImages(since, args)
+	if r.cfg.PruneImages && r.filterSupported("image", args) {
+		images, err := r.affectedImages(ctx, since, args, ret.groups, ret.sizes)
 		if err != nil {
 			if !errors.Is(err, errChangesDetected) {
 				r.logger.Error("affected images", fieldError, err)
@@ -391,7 +1286,14 @@ func (r *reaper) resources(since time.Time) (*resources, error) {
 			errs = append(errs, fmt.Errorf("affected images: %w", err))
 		}
 
-		ret.images = append(ret.images, images...)
+		// Images of kept containers are still in use.
+		ret.images = slices.DeleteFunc(images, func(id string) bool {
+			if slices.Contains(keptImages, id) {
+				r.decide("image", id, decisionSkipped, "image of kept container")
+				return true
+			}
+			return false
+		})
 	}
 
 	return &ret, errors.Join(errs...)
@@ -400,31 +1302,68 @@ func (r *reaper) resources(since time.Time) (*resources, error) {
 // affectedContainers returns a slice of container IDs that match the filters.
 // If a matching container was created after since, an error is returned and
 // the container is not included in the list.
-func (r *reaper) affectedContainers(since time.Time, args filters.Args) ([]string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), r.cfg.RequestTimeout)
+// If keeping one container per image, the IDs of the images of the
+// containers kept are also returned.
+func (r *reaper) affectedContainers(ctx context.Context, since time.Time, args filters.Args, groups map[string]string, sizes map[string]int64) ([]string, []string, error) {
+	ctx, span := r.startSpan(ctx, "affectedContainers", attrResourceType.String("container"))
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.listTimeout(r.cfg.ListTimeoutContainers))
 	defer cancel()
 
-	// List all containers including stopped ones.
-	options := container.ListOptions{All: true, Filters: args}
+	if r.cfg.ScopeNetwork != "" {
+		// Only consider containers attached to the scope network.
+		args = args.Clone()
+		args.Add("network", r.cfg.ScopeNetwork)
+	}
+
+	if len(r.cfg.ContainerHealth) > 0 {
+		// Only consider containers with one of the health statuses.
+		args = args.Clone()
+		for _, health := range r.cfg.ContainerHealth {
+			args.Add("health", health)
+		}
+	}
+
+	// List all containers including stopped ones, with their
+	// writable layer size if sizes are reported.
+	options := container.ListOptions{All: true, Size: sizes != nil, Filters: args}
 	r.logger.Debug("listing containers", "filter", options)
-	containers, err := r.client.ContainerList(ctx, options)
+	containers, err := listCombined(ctx, r, "container", args, func(c types.Container) map[string]string {
+		return c.Labels
+	}, func(ctx context.Context, cli dockerClient, args filters.Args) ([]types.Container, error) {
+		options := options
+		options.Filters = args
+		return cli.ContainerList(ctx, options) //nolint:wrapcheck // Wrapped by caller.
+	})
 	if err != nil {
-		return nil, fmt.Errorf("container list: %w", err)
+		return nil, nil, fmt.Errorf("container list: %w", err)
 	}
 
 	var errChanges []error
-	containerIDs := make([]string, 0, len(containers))
+	affected := make([]types.Container, 0, len(containers))
+	sample := r.newLogSampler("found container")
+	defer sample.done()
+
 	for _, container := range containers {
-		if container.Labels[ryukLabel] == "true" {
+		if container.Labels[r.cfg.ryukLabel()] == "true" {
 			// Ignore reaper containers.
 			r.logger.Debug("skipping reaper container", "id", container.ID)
+			r.decide("container", container.ID, decisionSkipped, "reaper container")
+			continue
+		}
+
+		if r.kept("container", container.ID, container.Labels) || r.excluded("container", container.ID, container.Labels) || !r.reapExitCode(container) {
 			continue
 		}
 
 		created := time.Unix(container.Created, 0)
 		changed := created.After(since)
+		if changed && r.cfg.InspectChanges {
+			changed = r.inspectChanged(ctx, container.ID, since)
+		}
 
-		r.logger.Debug("found container",
+		sample.log(
 			"id", container.ID,
 			"image", container.Image,
 			"names", container.Names,
@@ -440,34 +1379,107 @@ func (r *reaper) affectedContainers(since time.Time, args filters.Args) ([]strin
 			// Its not safe to remove a container which was created after
 			// the prune was initiated, as this may lead to unexpected behaviour.
 			errChanges = append(errChanges, fmt.Errorf("container %s: %w", container.ID, errChangesDetected))
+			r.decide("container", container.ID, decisionSkipped, decisionReasonChanged)
 			continue
 		}
 
-		containerIDs = append(containerIDs, container.ID)
+		if r.activeExec(ctx, container) {
+			continue
+		}
+
+		r.recordGroup(groups, container.ID, container.Labels)
+		recordSize(sizes, container.ID, container.SizeRw)
+		affected = append(affected, container)
+		r.decide("container", container.ID, decisionMatched, "")
+	}
+
+	var keptImages []string
+	if r.cfg.KeepOnePerImage {
+		var kept []types.Container
+		affected, kept = keepOnePerImage(affected)
+		for _, container := range kept {
+			r.logger.Info("keeping newest container of image", "id", container.ID, "image", container.Image)
+			r.decide("container", container.ID, decisionSkipped, "newest container of image")
+			if container.ImageID != "" {
+				keptImages = append(keptImages, container.ImageID)
+			}
+		}
+	}
+
+	// Remove pod children before their sandbox.
+	sortPodContainers(affected)
+	containerIDs := make([]string, len(affected))
+	for i, container := range affected {
+		containerIDs[i] = container.ID
+	}
+	span.SetAttributes(attrCount.Int(len(containerIDs)))
+
+	return containerIDs, keptImages, errors.Join(errChanges...)
+}
+
+// kept returns true if the resource is pinned by the configured keep label,
+// so must not be removed.
+func (r *reaper) kept(resourceType, id string, labels map[string]string) bool {
+	if r.cfg.KeepLabel == "" || labels[r.cfg.KeepLabel] != "true" {
+		return false
+	}
+
+	r.logger.Debug("skipping kept resource", "resource", resourceType, "id", id)
+	r.decide(resourceType, id, decisionSkipped, "keep label")
+	return true
+}
+
+// excluded returns true if the resource has any of the configured exclude
+// labels, so must not be removed even though it matches a filter.
+func (r *reaper) excluded(resourceType, id string, labels map[string]string) bool {
+	for _, label := range r.cfg.ExcludeLabels {
+		if matchLabels(labels, []string{label}) {
+			r.logger.Debug("skipping excluded resource", "resource", resourceType, "id", id, "label", label)
+			r.decide(resourceType, id, decisionSkipped, "exclude label "+label)
+			return true
+		}
 	}
 
-	return containerIDs, errors.Join(errChanges...)
+	return false
 }
 
 // affectedNetworks returns a list of network IDs that match the filters.
 // If a matching network was created after since, an error is returned and
 // the network is not included in the list.
-func (r *reaper) affectedNetworks(since time.Time, args filters.Args) ([]string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), r.cfg.RequestTimeout)
+func (r *reaper) affectedNetworks(ctx context.Context, since time.Time, args filters.Args, groups map[string]string) ([]string, error) {
+	ctx, span := r.startSpan(ctx, "affectedNetworks", attrResourceType.String("network"))
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.listTimeout(r.cfg.ListTimeoutNetworks))
 	defer cancel()
 
 	options := network.ListOptions{Filters: args}
 	r.logger.Debug("listing networks", "options", options)
-	report, err := r.client.NetworkList(ctx, options)
+	report, err := listCombined(ctx, r, "network", args, func(n network.Summary) map[string]string {
+		return n.Labels
+	}, func(ctx context.Context, cli dockerClient, args filters.Args) ([]network.Summary, error) {
+		options := options
+		options.Filters = args
+		return cli.NetworkList(ctx, options) //nolint:wrapcheck // Wrapped by caller.
+	})
 	if err != nil {
 		return nil, fmt.Errorf("network list: %w", err)
 	}
 
 	var errChanges []error
 	networks := make([]string, 0, len(report))
+	// session is the set of containers matching any filter, loaded on demand.
+	var session map[string]struct{}
+	sample := r.newLogSampler("found network")
+	defer sample.done()
+
 	for _, network := range report {
+		if r.kept("network", network.ID, network.Labels) || r.excluded("network", network.ID, network.Labels) {
+			continue
+		}
+
 		changed := network.Created.After(since)
-		r.logger.Debug("found network",
+		sample.log(
 			"id", network.ID,
 			"created", network.Created,
 			"changed", changed,
@@ -478,32 +1490,70 @@ func (r *reaper) affectedNetworks(since time.Time, args filters.Args) ([]string,
 			// Its not safe to remove a network which was created after
 			// the prune was initiated, as this may lead to unexpected behaviour.
 			errChanges = append(errChanges, fmt.Errorf("network %s: %w", network.ID, errChangesDetected))
+			r.decide("network", network.ID, decisionSkipped, decisionReasonChanged)
 			continue
 		}
 
+		if r.cfg.SkipSharedNetworks {
+			shared, serr := r.sharedNetworkContainer(ctx, network.ID, &session)
+			if serr != nil {
+				// Best effort, log and attempt removal.
+				r.logger.Error("shared network check", fieldError, serr, "network", network.ID)
+			} else if shared != "" {
+				r.logger.Info("skipping shared network", "id", network.ID, "container", shared)
+				r.decide("network", network.ID, decisionSkipped, "shared network")
+				continue
+			}
+		}
+
+		r.recordGroup(groups, network.ID, network.Labels)
 		networks = append(networks, network.ID)
+		r.decide("network", network.ID, decisionMatched, "")
 	}
 
+	span.SetAttributes(attrCount.Int(len(networks)))
 	return networks, errors.Join(errChanges...)
 }
 
 // affectedVolumes returns a list of volume names that match the filters.
 // If a matching volume was created after since, an error is returned and
 // the volume is not included in the list.
-func (r *reaper) affectedVolumes(since time.Time, args filters.Args) ([]string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), r.cfg.RequestTimeout)
+func (r *reaper) affectedVolumes(ctx context.Context, since time.Time, args filters.Args, groups map[string]string, sizes map[string]int64) ([]string, error) {
+	ctx, span := r.startSpan(ctx, "affectedVolumes", attrResourceType.String("volume"))
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.listTimeout(r.cfg.ListTimeoutVolumes))
 	defer cancel()
 
 	options := volume.ListOptions{Filters: args}
 	r.logger.Debug("listing volumes", "filter", options)
-	report, err := r.client.VolumeList(ctx, options)
+	listed, err := listCombined(ctx, r, "volume", args, func(v *volume.Volume) map[string]string {
+		return v.Labels
+	}, func(ctx context.Context, cli dockerClient, args filters.Args) ([]*volume.Volume, error) {
+		options := options
+		options.Filters = args
+		report, err := cli.VolumeList(ctx, options)
+		return report.Volumes, err //nolint:wrapcheck // Wrapped by caller.
+	})
 	if err != nil {
 		return nil, fmt.Errorf("volume list: %w", err)
 	}
 
+	var volumeSizes map[string]int64
+	if sizes != nil {
+		volumeSizes = r.volumeSizesOnce(ctx)
+	}
+
 	var errChanges []error
-	volumes := make([]string, 0, len(report.Volumes))
-	for _, volume := range report.Volumes {
+	volumes := make([]string, 0, len(listed))
+	sample := r.newLogSampler("found volume")
+	defer sample.done()
+
+	for _, volume := range listed {
+		if r.kept("volume", volume.Name, volume.Labels) || r.excluded("volume", volume.Name, volume.Labels) {
+			continue
+		}
+
 		created, perr := time.Parse(time.RFC3339, volume.CreatedAt)
 		if perr != nil {
 			// Best effort, log and continue.
@@ -512,7 +1562,7 @@ func (r *reaper) affectedVolumes(since time.Time, args filters.Args) ([]string,
 		}
 
 		changed := created.After(since)
-		r.logger.Debug("found volume",
+		sample.log(
 			"name", volume.Name,
 			"created", created,
 			"changed", changed,
@@ -523,35 +1573,61 @@ func (r *reaper) affectedVolumes(since time.Time, args filters.Args) ([]string,
 			// Its not safe to remove a volume which was created after
 			// the prune was initiated, as this may lead to unexpected behaviour.
 			errChanges = append(errChanges, fmt.Errorf("volume %s: %w", volume.Name, errChangesDetected))
+			r.decide("volume", volume.Name, decisionSkipped, decisionReasonChanged)
 			continue
 		}
 
+		r.recordGroup(groups, volume.Name, volume.Labels)
+		if size, ok := volumeSizes[volume.Name]; ok {
+			recordSize(sizes, volume.Name, size)
+		}
 		volumes = append(volumes, volume.Name)
+		r.decide("volume", volume.Name, decisionMatched, "")
 	}
 
+	span.SetAttributes(attrCount.Int(len(volumes)))
 	return volumes, errors.Join(errChanges...)
 }
 
 // affectedImages returns a list of image IDs that match the filters.
 // If a matching image was created after since, an error is returned and
 // the image is not included in the list.
-func (r *reaper) affectedImages(since time.Time, args filters.Args) ([]string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), r.cfg.RequestTimeout)
+func (r *reaper) affectedImages(ctx context.Context, since time.Time, args filters.Args, groups map[string]string, sizes map[string]int64) ([]string, error) {
+	ctx, span := r.startSpan(ctx, "affectedImages", attrResourceType.String("image"))
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.listTimeout(r.cfg.ListTimeoutImages))
 	defer cancel()
 
+	// since includes the retry offset, so replace it with the image one.
+	since = since.Add(r.cfg.retryOffset(r.cfg.ImageRetryOffset) - r.cfg.RetryOffset)
+
 	options := image.ListOptions{Filters: args}
 	r.logger.Debug("listing images", "filter", options)
-	report, err := r.client.ImageList(ctx, options)
+	report, err := listCombined(ctx, r, "image", args, func(i image.Summary) map[string]string {
+		return i.Labels
+	}, func(ctx context.Context, cli dockerClient, args filters.Args) ([]image.Summary, error) {
+		options := options
+		options.Filters = args
+		return cli.ImageList(ctx, options) //nolint:wrapcheck // Wrapped by caller.
+	})
 	if err != nil {
 		return nil, fmt.Errorf("image list: %w", err)
 	}
 
 	var errChanges []error
 	images := make([]string, 0, len(report))
+	sample := r.newLogSampler("found image")
+	defer sample.done()
+
 	for _, image := range report {
+		if r.kept("image", image.ID, image.Labels) || r.excluded("image", image.ID, image.Labels) {
+			continue
+		}
+
 		created := time.Unix(image.Created, 0)
 		changed := created.After(since)
-		r.logger.Debug("found image",
+		sample.log(
 			"id", image.ID,
 			"created", created,
 			"changed", changed,
@@ -562,21 +1638,54 @@ func (r *reaper) affectedImages(since time.Time, args filters.Args) ([]string, e
 			// Its not safe to remove an image which was created after
 			// the prune was initiated, as this may lead to unexpected behaviour.
 			errChanges = append(errChanges, fmt.Errorf("image %s: %w", image.ID, errChangesDetected))
+			r.decide("image", image.ID, decisionSkipped, decisionReasonChanged)
 			continue
 		}
 
+		r.recordGroup(groups, image.ID, image.Labels)
+		recordSize(sizes, image.ID, image.Size)
 		images = append(images, image.ID)
+		r.decide("image", image.ID, decisionMatched, "")
 	}
 
+	span.SetAttributes(attrCount.Int(len(images)))
 	return images, errors.Join(errChanges...)
 }
 
-// addFilter adds a filter to prune.
+// filterEntry is a registered filter.
+type filterEntry struct {
+	// args are the filter arguments.
+	args filters.Args
+
+	// clients is the set of addresses of connected clients
+	// which registered this filter.
+	clients map[string]struct{}
+
+	// added is when the filter was first registered.
+	added time.Time
+
+	// expired is set once the filter has exceeded the session TTL.
+	expired bool
+}
+
+// addFilter adds a filter to prune, registered by the client at addr.
 // Safe to call concurrently.
-func (r *reaper) addFilter(msg string) error {
-	query, err := url.ParseQuery(msg)
+func (r *reaper) addFilter(addr, msg string) error {
+	query, err := parseFilter(msg)
 	if err != nil {
-		return fmt.Errorf("parse query: %w", err)
+		return err
+	}
+
+	if err = validateFilterTypes(query); err != nil {
+		return err
+	}
+
+	if err = r.filterSchema.validate(query); err != nil {
+		return err
+	}
+
+	if err = validateRequiredLabels(query, r.cfg.RequiredFilterLabels); err != nil {
+		return err
 	}
 
 	args := filters.NewArgs()
@@ -588,27 +1697,97 @@ func (r *reaper) addFilter(msg string) error {
 	}
 
 	// We can't use msg as it could be in any order.
-	data, err := args.MarshalJSON()
-	if err != nil {
-		return fmt.Errorf("marshal json: %w", err)
-	}
+	key := filterKey(args)
 
-	key := string(data)
+	// Registered below, audited once the lock is released.
+	defer func() {
+		if err == nil {
+			r.audit(auditEvent{
+				Time:    time.Now(),
+				Event:   auditFilterRegistered,
+				Address: addr,
+				Filters: query,
+			})
+		}
+	}()
 
 	r.mtx.Lock()
 	defer r.mtx.Unlock()
 
-	if _, ok := r.filters[key]; ok {
+	if entry, ok := r.filters[key]; ok {
 		r.logger.Debug("filter already exists", "key", key)
+		entry.clients[addr] = struct{}{}
 		return nil
 	}
 
+	if r.cfg.MaxFilters > 0 && len(r.filters) >= r.cfg.MaxFilters {
+		err = fmt.Errorf("%w: limit %d", errTooManyFilters, r.cfg.MaxFilters)
+		return err
+	}
+
 	r.logger.Debug("adding filter", "args", args, "key", key)
-	r.filters[key] = args
+	r.filters[key] = &filterEntry{
+		args:    args,
+		clients: map[string]struct{}{addr: {}},
+		added:   time.Now(),
+	}
 
 	return nil
 }
 
+// parseFilter parses msg, a filter in the query form such as
+// label=k=v&label=k2=v2, or if it starts with { the JSON form used
+// by Docker such as {"label":["k=v","k2=v2"]}.
+func parseFilter(msg string) (url.Values, error) {
+	if !strings.HasPrefix(msg, "{") {
+		query, err := url.ParseQuery(msg)
+		if err != nil {
+			return nil, fmt.Errorf("parse query: %w", err)
+		}
+
+		return query, nil
+	}
+
+	args, err := filters.FromJSON(msg)
+	if err != nil {
+		return nil, fmt.Errorf("parse json: %w", err)
+	}
+
+	query := make(url.Values, args.Len())
+	for _, filterType := range args.Keys() {
+		values := args.Get(filterType)
+		slices.Sort(values)
+		query[filterType] = values
+	}
+
+	return query, nil
+}
+
+// releaseFilters removes the client at addr from all filters it registered.
+// Safe to call concurrently.
+func (r *reaper) releaseFilters(addr string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	for _, entry := range r.filters {
+		delete(entry.clients, addr)
+	}
+}
+
+// filterKey returns a canonical key for args which is independent of
+// the order the filters were specified in.
+func filterKey(args filters.Args) string {
+	values := make(url.Values, args.Len())
+	for _, filterType := range args.Keys() {
+		filterValues := args.Get(filterType)
+		slices.Sort(filterValues)
+		values[filterType] = filterValues
+	}
+
+	// Encode sorts by filter type.
+	return values.Encode()
+}
+
 // filterArgs returns a slice of filter.Args to check against.
 // Safe to call concurrently.
 func (r *reaper) filterArgs() []filters.Args {
@@ -616,47 +1795,610 @@ func (r *reaper) filterArgs() []filters.Args {
 	defer r.mtx.Unlock()
 
 	filters := make([]filters.Args, 0, len(r.filters))
-	for _, args := range r.filters {
-		filters = append(filters, args)
+	for _, entry := range r.filters {
+		filters = append(filters, entry.args)
 	}
 
 	return filters
 }
 
-// prune removes the specified resources.
-func (r *reaper) prune(resources *resources) error {
-	var containers, networks, volumes, images int
-	var errs []error
+// partitionFilterArgs returns the filter args which are no longer referenced by
+// any connected client and those which are still active.
+// Safe to call concurrently.
+func (r *reaper) partitionFilterArgs() (inactive, active []filters.Args) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
 
-	// Containers must be removed first.
-	errs = append(errs, r.remove("container", resources.containers, &containers, func(ctx context.Context, id string) error {
-		return r.client.ContainerRemove(ctx, id, containerRemoveOptions)
-	}))
+	for _, entry := range r.filters {
+		if len(entry.clients) > 0 {
+			active = append(active, entry.args)
+			continue
+		}
 
-	// Networks.
-	errs = append(errs, r.remove("network", resources.networks, &networks, func(ctx context.Context, id string) error {
-		return r.client.NetworkRemove(ctx, id)
-	}))
+		inactive = append(inactive, entry.args)
+	}
 
-	// Volumes.
-	errs = append(errs, r.remove("volume", resources.volumes, &volumes, func(ctx context.Context, id string) error {
-		return r.client.VolumeRemove(ctx, id, volumeRemoveForce)
-	}))
+	return inactive, active
+}
 
-	// Images.
-	errs = append(errs, r.remove("image", resources.images, &images, func(ctx context.Context, id string) error {
-		_, err := r.client.ImageRemove(ctx, id, imageRemoveOptions)
-		return err //nolint:wrapcheck // Wrapped by action.
-	}))
+// maintenanceWait returns how long to wait before checking again if now is
+// within the configured maintenance window, otherwise zero. The window is
+// ignored once the shutdown deadline, if set, is reached.
+func (r *reaper) maintenanceWait(now, shutdownDeadline time.Time) time.Duration {
+	if !now.Before(r.cfg.MaintenanceUntil) {
+		return 0
+	}
+
+	wait := min(r.cfg.MaintenanceUntil.Sub(now), maintenanceCheckInterval)
+	if !shutdownDeadline.IsZero() {
+		if !now.Before(shutdownDeadline) {
+			r.logger.Warn("shutdown timeout reached, ignoring maintenance window", "until", r.cfg.MaintenanceUntil)
+			return 0
+		}
+		wait = min(wait, shutdownDeadline.Sub(now))
+	}
 
-	r.logger.Info("removed", "containers", containers, "networks", networks, "volumes", volumes, "images", images)
+	return wait
+}
+
+// holding returns true if the hold file is configured and exists,
+// in which case no resources should be removed.
+func (r *reaper) holding() bool {
+	if r.cfg.HoldFile == "" {
+		return false
+	}
+
+	if _, err := os.Stat(r.cfg.HoldFile); err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			r.logger.Error("hold file", "file", r.cfg.HoldFile, fieldError, err)
+		}
+		return false
+	}
+
+	r.logger.Warn("holding, skipping prune", "file", r.cfg.HoldFile)
+
+	return true
+}
+
+// stopContainer stops the container, if stopping is configured, so it can
+// shut down cleanly before it's removed. The configured stop signal and
+// timeout are used if set, otherwise the container's own. The stop is
+// bounded by its own timeout, so returns true if the container was stopped.
+// Failures are logged as removal will force the container to stop.
+func (r *reaper) stopContainer(ctx context.Context, id string) bool {
+	if r.cfg.StopSignal == "" && r.cfg.StopTimeout == 0 {
+		return false
+	}
+
+	options := container.StopOptions{Signal: r.cfg.StopSignal}
+	timeout := defaultStopTimeout
+	if r.cfg.StopTimeout > 0 {
+		seconds := int(math.Ceil(r.cfg.StopTimeout.Seconds()))
+		options.Timeout = &seconds
+		timeout = r.cfg.StopTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout+r.cfg.RequestTimeout)
+	defer cancel()
+
+	if err := r.docker().ContainerStop(ctx, id, options); err != nil {
+		if !errdefs.IsNotFound(err) {
+			r.logger.Warn("container stop", fieldError, err, "id", id, "signal", r.cfg.StopSignal)
+		}
+	}
+
+	return true
+}
+
+// canPruneImages returns true if the images of resources can be removed
+// with prune calls, which remove every image matching their filters, so is
+// only the case if Docker can apply the filters and all the configured
+// exclusions too.
+func (r *reaper) canPruneImages(resources *resources) bool {
+	if r.cfg.ReferenceCounting || r.cfg.ProtectSelf {
+		return false
+	}
+
+	for _, label := range r.cfg.ExcludeLabels {
+		if strings.HasSuffix(label, labelGlob) {
+			// Docker doesn't support label globs.
+			return false
+		}
+	}
+
+	for _, args := range resources.filters {
+		if _, glob := globLabels(args); glob {
+			// Docker would match the glob literally.
+			return false
+		}
+	}
+
+	return true
+}
+
+// pruneImages removes images using a single prune call for each of the
+// filters the resources were listed with, instead of removing each image.
+// Images created after the resources were listed and those with the keep
+// or an exclude label are excluded by the prune filters.
+// The number of images deleted and the space reclaimed are added to counts.
+func (r *reaper) pruneImages(ctx context.Context, resources *resources, counts *removeCounts) error {
+	if len(resources.images) == 0 {
+		return nil
+	}
+
+	// resources.since includes the retry offset, so replace it with the image one.
+	until := resources.since.Add(r.cfg.retryOffset(r.cfg.ImageRetryOffset) - r.cfg.RetryOffset)
+
+	var errs []error
+	for _, args := range resources.filters {
+		if !r.filterSupported("image", args) {
+			continue
+		}
+
+		// Include tagged images, not just dangling ones.
+		args = args.Clone()
+		args.Add("dangling", "false")
+		args.Add("until", strconv.FormatInt(until.Unix(), 10))
+		if r.cfg.KeepLabel != "" {
+			args.Add("label!", r.cfg.KeepLabel+"=true")
+		}
+		for _, label := range r.cfg.ExcludeLabels {
+			args.Add("label!", label)
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, r.cfg.RequestTimeout)
+		report, err := r.docker().ImagesPrune(ctx, args)
+		cancel()
+		if err != nil {
+			r.logger.Error("images prune", fieldError, err)
+			errs = append(errs, fmt.Errorf("images prune: %w", err))
+			continue
+		}
+
+		for _, item := range report.ImagesDeleted {
+			if item.Deleted != "" {
+				counts.images.Add(1)
+				counts.ids.add("image", item.Deleted)
+			}
+		}
+		counts.imageBytes.Add(int64(report.SpaceReclaimed)) //nolint:gosec // Can't realistically overflow.
+	}
 
 	return errors.Join(errs...)
 }
 
+// logAccounting logs how the matched resources of resourceType were handled,
+// where skipped resources were already removed by something else and failed
+// resources are those left by the removeErrors in errs.
+func (r *reaper) logAccounting(resourceType string, matched []string, removed int64, errs []error) {
+	unique := int64(len(slices.Compact(slices.Sorted(slices.Values(matched)))))
+
+	var failed int64
+	for _, err := range errs {
+		var rerr *removeError
+		if errors.As(err, &rerr) && rerr.resourceType == resourceType {
+			failed += int64(len(rerr.left))
+		}
+	}
+
+	r.logger.Info("prune accounting",
+		"resource", resourceType,
+		"matched", unique,
+		"removed", removed,
+		"skipped", max(unique-removed-failed, 0),
+		"failed", failed,
+	)
+}
+
+// removeCounts are the number of resources removed of each type.
+type removeCounts struct {
+	containers atomic.Int64
+	networks   atomic.Int64
+	volumes    atomic.Int64
+	images     atomic.Int64
+
+	// The bytes reclaimed by removing each type, only
+	// tracked if sizes are reported.
+	containerBytes atomic.Int64
+	volumeBytes    atomic.Int64
+	imageBytes     atomic.Int64
+
+	// ids are the IDs of the resources removed, only
+	// tracked if a webhook is configured.
+	ids *removedIDs
+}
+
+// logAttrs returns the counts as log attributes, including the
+// bytes reclaimed, in total and by type, if reportSizes is set.
+func (c *removeCounts) logAttrs(reportSizes bool) []any {
+	attrs := []any{
+		"containers", c.containers.Load(),
+		"networks", c.networks.Load(),
+		"volumes", c.volumes.Load(),
+		"images", c.images.Load(),
+	}
+	if reportSizes {
+		containers, volumes, images := c.containerBytes.Load(), c.volumeBytes.Load(), c.imageBytes.Load()
+		attrs = append(attrs,
+			slog.Group("reclaimed_bytes",
+				"total", containers+volumes+images,
+				"containers", containers,
+				"volumes", volumes,
+				"images", images,
+			),
+		)
+	}
+
+	return attrs
+}
+
+// prune removes the specified resources, bounded by ctx.
+// Resources which were not removed before ctx is done are logged as abandoned.
+func (r *reaper) prune(ctx context.Context, resources *resources) error {
+	if r.holding() {
+		return nil
+	}
+
+	ctx, span := r.startSpan(ctx, "prune", resourceCountAttrs(resources)...)
+	defer span.End()
+
+	counts := removeCounts{ids: r.newRemovedIDs()}
+	summary := newGroupSummary(resources.groups)
+	errs, err := r.removePass(ctx, resources, &counts, summary)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	removed := counts.logAttrs(r.cfg.ReportSizes)
+	if r.cfg.PruneBuildCache && !r.cfg.DryRun {
+		freed, err := r.pruneBuildCache(time.Now().Add(r.cfg.RetryOffset))
+		if err != nil {
+			r.logger.Error("build cache prune", fieldError, err)
+			errs = append(errs, err)
+		}
+		removed = append(removed, "build_cache_bytes", freed)
+	}
+	if r.cfg.DryRun {
+		removed = append(removed, "dry_run", true)
+	}
+	r.logger.Info("removed", removed...)
+	r.recordPrune(&counts)
+	r.logAccounting("container", resources.containers, counts.containers.Load(), errs)
+	r.logAccounting("network", resources.networks, counts.networks.Load(), errs)
+	r.logAccounting("volume", resources.volumes, counts.volumes.Load(), errs)
+	r.logAccounting("image", resources.images, counts.images.Load(), errs)
+	summary.log(r.logger, r.cfg.SummaryGroupLabel)
+	r.notifyWebhook(resources.filters, &counts)
+
+	if r.cfg.DryRun {
+		r.logger.Info("skipping buildkit and bind path cleanup", "dry_run", true)
+	} else {
+		r.pruneBuildkit()
+
+		if err := r.removeBindPaths(r.bindPathReleased); err != nil {
+			errs = append(errs, fmt.Errorf("remove bind paths: %w", err))
+		}
+	}
+
+	if err := r.writeOrphans(errs); err != nil {
+		errs = append(errs, fmt.Errorf("write orphans: %w", err))
+	}
+
+	span.SetAttributes(attrRemoved.Int64(counts.containers.Load() + counts.networks.Load() + counts.volumes.Load() + counts.images.Load()))
+	err = errors.Join(errs...)
+	if err != nil {
+		span.RecordError(err)
+	}
+
+	return err
+}
+
+// removePass removes resources as one pass, adding the number removed of
+// each type to counts. It's the shared entry point of every prune, so the
+// maximum removals limit and circuit breaker apply to each. If the limit is
+// exceeded nothing is removed and an error wrapping errTooManyRemovals is
+// returned, otherwise the errors of any removals which failed.
+// Removal is bounded by ctx.
+func (r *reaper) removePass(ctx context.Context, resources *resources, counts *removeCounts, summary *groupSummary) ([]error, error) {
+	if err := r.checkMaxRemovals(resources); err != nil {
+		return nil, err
+	}
+
+	r.circuit.reset()
+	ctx = withPassContainers(ctx, resources.containers)
+
+	var errs []error
+	if len(resources.sessions) > 0 {
+		for _, session := range resources.sessions {
+			errs = append(errs, r.pruneSession(ctx, session, counts, summary)...)
+		}
+	} else {
+		errs = r.removeResources(ctx, resources, counts, summary)
+	}
+	r.logAbandoned(ctx, errs)
+
+	return errs, nil
+}
+
+// checkMaxRemovals returns errTooManyRemovals if the total number of
+// resources exceeds the configured maximum removals, logging the counts.
+func (r *reaper) checkMaxRemovals(resources *resources) error {
+	if r.cfg.MaxRemovals <= 0 {
+		return nil
+	}
+
+	total := len(resources.containers) + len(resources.networks) + len(resources.volumes) + len(resources.images)
+	if total <= r.cfg.MaxRemovals {
+		return nil
+	}
+
+	r.logger.Error("too many removals, refusing to prune",
+		"limit", r.cfg.MaxRemovals,
+		"containers", len(resources.containers),
+		"networks", len(resources.networks),
+		"volumes", len(resources.volumes),
+		"images", len(resources.images),
+	)
+
+	return fmt.Errorf("%w: %d over limit %d", errTooManyRemovals, total, r.cfg.MaxRemovals)
+}
+
+// logAbandoned logs the resources left by the removeErrors in errs
+// as abandoned, if removal was stopped because ctx is done.
+func (r *reaper) logAbandoned(ctx context.Context, errs []error) {
+	if ctx.Err() == nil {
+		return
+	}
+
+	for _, err := range errs {
+		var rerr *removeError
+		if errors.As(err, &rerr) {
+			r.logger.Warn("abandoned", "resource", rerr.resourceType, "ids", slices.Sorted(maps.Keys(rerr.left)), fieldError, ctx.Err())
+		}
+	}
+}
+
+// pruneSession removes the resources of a single session bounded by
+// the session deadline. If the deadline is exceeded the resources
+// left are logged so the next session can be processed.
+func (r *reaper) pruneSession(ctx context.Context, session *resources, counts *removeCounts, summary *groupSummary) []error {
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.SessionDeadline)
+	defer cancel()
+
+	errs := r.removeResources(ctx, session, counts, summary)
+	if ctx.Err() == nil {
+		return errs
+	}
+
+	left := make(map[string]int)
+	for _, err := range errs {
+		var rerr *removeError
+		if errors.As(err, &rerr) {
+			left[rerr.resourceType] += len(rerr.left)
+		}
+	}
+
+	r.logger.Warn("session deadline exceeded removing",
+		"filter", filterKey(session.filters[0]),
+		"deadline", r.cfg.SessionDeadline,
+		"containers", left["container"],
+		"networks", left["network"],
+		"volumes", left["volume"],
+		"images", left["image"],
+	)
+
+	return errs
+}
+
+// removeResources removes resources, in the configured prune order, adding
+// the number removed of each type to counts. Removal is bounded by ctx.
+func (r *reaper) removeResources(ctx context.Context, resources *resources, counts *removeCounts, summary *groupSummary) []error {
+	ctx = withSessionIDs(ctx, resources.sessionIDs)
+	steps := map[string]func() error{
+		"container": func() error {
+			if !r.cfg.PruneContainers {
+				return nil
+			}
+
+			return r.remove(ctx, "container", resources.containers, &counts.containers, counts.ids.track("container", summary.track("container", trackSize(resources.sizes, &counts.containerBytes, r.dryRun("container", func(itemCtx context.Context, id string) error {
+				if r.stopContainer(ctx, id) {
+					// The stop used up some of the request timeout, so
+					// give the removal its own.
+					var cancel context.CancelFunc
+					itemCtx, cancel = context.WithTimeout(ctx, r.cfg.RequestTimeout)
+					defer cancel()
+				}
+
+				return r.docker().ContainerRemove(itemCtx, id, r.containerRemoveOptions())
+			})))))
+		},
+		"network": func() error {
+			if !r.cfg.PruneNetworks {
+				return nil
+			}
+
+			return r.remove(ctx, "network", resources.networks, &counts.networks, counts.ids.track("network", summary.track("network", r.dryRun("network", r.removeNetwork))))
+		},
+		"volume": func() error {
+			if !r.cfg.PruneVolumes {
+				return nil
+			}
+
+			return r.remove(ctx, "volume", resources.volumes, &counts.volumes, counts.ids.track("volume", summary.track("volume", trackSize(resources.sizes, &counts.volumeBytes, r.dryRun("volume", func(ctx context.Context, id string) error {
+				return r.docker().VolumeRemove(ctx, id, volumeRemoveForce)
+			})))))
+		},
+		"image": func() error {
+			switch {
+			case !r.cfg.PruneImages:
+				// Images are kept.
+				return nil
+			case r.cfg.ImagesUsePrune && !r.cfg.DryRun && r.canPruneImages(resources):
+				return r.pruneImages(ctx, resources, counts)
+			}
+
+			return r.removeImages(ctx, resources, counts, summary)
+		},
+	}
+
+	// Resources are removed in the configured order, by default containers
+	// first as the other resources may be in use by them.
+	var errs []error
+	for _, resourceType := range r.cfg.pruneOrder() {
+		errs = append(errs, steps[resourceType]())
+	}
+
+	return errs
+}
+
+// containerRemoveOptions returns the options we use to remove a container,
+// which also removes its anonymous volumes unless configured not to.
+func (r *reaper) containerRemoveOptions() container.RemoveOptions {
+	return container.RemoveOptions{RemoveVolumes: r.cfg.RemoveContainerVolumes, Force: true}
+}
+
+// removeImages removes the images of resources one at a time, adding the
+// number removed to counts. Removal is bounded by ctx.
+func (r *reaper) removeImages(ctx context.Context, resources *resources, counts *removeCounts, summary *groupSummary) error {
+	// Removing an image also deletes its children, which may be later in
+	// the list, so track deleted images to avoid removing them again.
+	deleted := make(map[string]struct{})
+	var deletedMtx sync.Mutex
+	return r.remove(ctx, "image", resources.images, &counts.images, counts.ids.track("image", summary.track("image", trackSize(resources.sizes, &counts.imageBytes, r.dryRun("image", func(ctx context.Context, id string) error {
+		deletedMtx.Lock()
+		_, ok := deleted[id]
+		deletedMtx.Unlock()
+		if ok {
+			return errAlreadyRemoved
+		}
+
+		report, err := r.docker().ImageRemove(ctx, id, imageRemoveOptions)
+		if imageInUse(err) {
+			// Remove the referencing containers first, then retry.
+			removed, rerr := r.removeImageContainers(ctx, id)
+			counts.containers.Add(int64(len(removed)))
+			for _, cid := range removed {
+				counts.ids.add("container", cid)
+			}
+			if rerr != nil {
+				return fmt.Errorf("%w: %w", err, rerr)
+			}
+
+			report, err = r.docker().ImageRemove(ctx, id, imageRemoveOptions)
+		}
+		if err != nil {
+			return err //nolint:wrapcheck // Wrapped by action.
+		}
+
+		var untagged []string
+		var deletedCount int
+		deletedMtx.Lock()
+		for _, item := range report {
+			if item.Untagged != "" {
+				untagged = append(untagged, item.Untagged)
+			}
+			if item.Deleted != "" {
+				deleted[item.Deleted] = struct{}{}
+				deletedCount++
+			}
+		}
+		deletedMtx.Unlock()
+
+		if deletedCount == 0 && len(untagged) > 0 {
+			// Other tags still reference the image, so it wasn't deleted.
+			r.logger.Info("image untagged", "id", id, "untagged", untagged)
+			if !r.cfg.CountUntaggedImages {
+				return errImageUntagged
+			}
+			return nil
+		}
+
+		r.logger.Debug("image deleted", "id", id, "deleted", deletedCount, "untagged", untagged)
+		return nil
+	})))))
+}
+
+// dryRun returns fn, unless dry run is enabled in which case it returns
+// a function which logs the resource that would be removed instead.
+func (r *reaper) dryRun(resourceType string, fn func(ctx context.Context, id string) error) func(ctx context.Context, id string) error {
+	if !r.cfg.DryRun {
+		return fn
+	}
+
+	return func(_ context.Context, id string) error {
+		r.logger.Info("would remove", "resource", resourceType, "id", id, "dry_run", true)
+		return nil
+	}
+}
+
+// orphan is a resource which could not be removed.
+type orphan struct {
+	ID    string `json:"id"`
+	Error string `json:"error"`
+}
+
+// writeOrphans writes the resources which could not be removed, as reported
+// by the removeErrors in errs, to the configured orphans file if any.
+func (r *reaper) writeOrphans(errs []error) error {
+	if r.cfg.OrphansFile == "" {
+		return nil
+	}
+
+	orphans := make(map[string][]orphan)
+	for _, err := range errs {
+		var rerr *removeError
+		if !errors.As(err, &rerr) {
+			continue
+		}
+
+		for id, lastErr := range rerr.left {
+			o := orphan{ID: id}
+			if lastErr != nil {
+				o.Error = lastErr.Error()
+			}
+			orphans[rerr.resourceType] = append(orphans[rerr.resourceType], o)
+		}
+	}
+
+	if len(orphans) == 0 {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(orphans, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+
+	if err = os.WriteFile(r.cfg.OrphansFile, data, 0o644); err != nil { //nolint:gosec // Orphans are intended to be read by other tools.
+		return fmt.Errorf("write file: %w", err)
+	}
+
+	r.logger.Warn("orphans written", "file", r.cfg.OrphansFile)
+
+	return nil
+}
+
+// removeError is returned by remove when some resources could not be removed.
+type removeError struct {
+	// resourceType is the type of the resources which were not removed.
+	resourceType string
+
+	// left is the last error for each resource ID which was not removed.
+	left map[string]error
+}
+
+// Error implements error.
+func (e *removeError) Error() string {
+	return fmt.Sprintf("%s left %d items", e.resourceType, len(e.left))
+}
+
 // remove calls fn for each resource in resources and retries if necessary.
-// Count is incremented for each resource that is successfully removed.
-func (r *reaper) remove(resourceType string, resources []string, count *int, fn func(ctx context.Context, id string) error) error {
+// Count is atomically incremented for each resource that is successfully
+// removed, so it is exact even if shared between concurrent calls.
+// Once ctx is done the remaining resources are left, with ctx's error
+// if they weren't attempted.
+func (r *reaper) remove(ctx context.Context, resourceType string, resources []string, count *atomic.Int64, fn func(ctx context.Context, id string) error) error {
 	logger := r.logger.With("resource", resourceType)
 	logger.Debug("removing", "count", len(resources))
 
@@ -664,39 +2406,97 @@ func (r *reaper) remove(resourceType string, resources []string, count *int, fn
 		return nil
 	}
 
-	todo := make(map[string]struct{}, len(resources))
+	// todo tracks the resources left to remove and their last error,
+	// order is the unique resources in the order they should be removed.
+	todo := make(map[string]error, len(resources))
+	order := make([]string, 0, len(resources))
 	for _, id := range resources {
-		todo[id] = struct{}{}
+		if _, ok := todo[id]; !ok {
+			todo[id] = nil
+			order = append(order, id)
+		}
 	}
 
+	start := count.Load()
+	ctx, span := r.startSpan(ctx, "remove", attrResourceType.String(resourceType), attrCount.Int(len(order)))
+	defer func() {
+		span.SetAttributes(attrRemoved.Int64(count.Load() - start))
+		span.End()
+	}()
+
+	// workers bounds the number of concurrent removals. With a single
+	// worker resources are removed one at a time in order.
+	workers := make(chan struct{}, max(r.cfg.RemoveConcurrency, 1))
+	prog := newProgress(logger, r.cfg.ProgressInterval, len(order))
+	var mtx sync.Mutex // Protects todo, retry and prog.
 	for attempt := 1; attempt <= r.cfg.RemoveRetries; attempt++ {
 		var retry bool
-		for id := range todo {
-			itemLogger := logger.With("id", id, "attempt", attempt)
-
-			ctx, cancel := context.WithTimeout(context.Background(), r.cfg.RequestTimeout)
-			defer cancel()
+		var wg sync.WaitGroup
+		var chunk int
+		for _, id := range order {
+			mtx.Lock()
+			_, ok := todo[id]
+			mtx.Unlock()
+			if !ok {
+				continue
+			}
 
-			itemLogger.Debug("remove")
-			if err := fn(ctx, id); err != nil {
-				if errdefs.IsNotFound(err) {
-					// Already removed.
-					itemLogger.Debug("not found")
-					continue
+			if r.cfg.RemoveChunkSize > 0 && chunk == r.cfg.RemoveChunkSize {
+				// Finish the chunk and pause to spread the load on the daemon.
+				wg.Wait()
+				logger.Debug("chunk done", "size", chunk, "pause", r.cfg.RemoveChunkPause)
+				if err := sleepContext(ctx, r.cfg.RemoveChunkPause); err != nil {
+					return r.removeAborted(resourceType, todo, err)
 				}
+				chunk = 0
+			}
+			chunk++
 
-				itemLogger.Error("remove", fieldError, err)
-				retry = true
-				continue
+			workers <- struct{}{}
+			err := ctx.Err()
+			if err == nil {
+				err = r.circuit.aborted()
 			}
+			if err != nil {
+				<-workers
+				wg.Wait()
+				return r.removeAborted(resourceType, todo, err)
+			}
+
+			wg.Add(1)
+			go func() {
+				defer func() {
+					<-workers
+					wg.Done()
+				}()
+
+				err := r.removeItem(ctx, sessionLogger(ctx, logger.With("id", id, "attempt", attempt), id), resourceType, id, count, fn)
 
-			delete(todo, id)
-			*count++
+				mtx.Lock()
+				defer mtx.Unlock()
+
+				if err != nil {
+					todo[id] = err
+					retry = true
+					return
+				}
+
+				delete(todo, id)
+				prog.done()
+			}()
 		}
+		wg.Wait()
 
 		if retry {
+			if err := r.circuit.aborted(); err != nil {
+				return r.removeAborted(resourceType, todo, err)
+			}
+
 			if attempt < r.cfg.RemoveRetries {
-				time.Sleep(time.Second)
+				// Abort promptly if cancelled, such as when shutdown is forced.
+				if err := sleepContext(ctx, r.removeBackoff(attempt)); err != nil {
+					return r.removeAborted(resourceType, todo, err)
+				}
 			}
 			continue
 		}
@@ -706,5 +2506,140 @@ func (r *reaper) remove(resourceType string, resources []string, count *int, fn
 	}
 
 	// Some items were not removed.
-	return fmt.Errorf("%s left %d items", resourceType, len(todo))
+	return &removeError{resourceType: resourceType, left: todo}
+}
+
+// waitContext pauses for d, returning ctx's error if ctx is done first.
+func waitContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err() //nolint:wrapcheck // Nothing to add.
+	case <-timer.C:
+		return nil
+	}
+}
+
+// removeBackoff returns the delay after the failed removal attempt. The
+// configured backoff doubles each attempt, up to maxRemoveBackoff, and
+// is reduced by a random jitter of up to half so retries are spread out.
+func (r *reaper) removeBackoff(attempt int) time.Duration {
+	backoff := r.cfg.RemoveBackoff
+	if backoff <= 0 {
+		return 0
+	}
+
+	for range attempt - 1 {
+		if backoff >= maxRemoveBackoff {
+			break
+		}
+		backoff *= 2
+	}
+	backoff = min(backoff, maxRemoveBackoff)
+
+	half := backoff / 2
+	return backoff - rand.N(half+1) //nolint:gosec // Jitter doesn't need a secure source.
+}
+
+// removeItem calls fn to remove resource id, incrementing count if it
+// was removed. It returns nil if the resource no longer needs removing,
+// otherwise the error from fn.
+func (r *reaper) removeItem(ctx context.Context, logger *slog.Logger, resourceType, id string, count *atomic.Int64, fn func(ctx context.Context, id string) error) error {
+	if err := r.circuitWait(ctx); err != nil {
+		return err
+	}
+
+	itemCtx, cancel := context.WithTimeout(ctx, r.cfg.RequestTimeout)
+	defer cancel()
+
+	logger.Debug("remove")
+	err := fn(itemCtx, id)
+	if removalInProgress(err) && r.cfg.RemovalInProgressWait > 0 {
+		err = r.awaitRemoval(ctx, logger, id, fn)
+	}
+
+	switch {
+	case err == nil:
+		count.Add(1)
+		r.decide(resourceType, id, decisionRemoved, "")
+	case errors.Is(err, errAlreadyRemoved):
+		logger.Debug("already removed")
+		r.decide(resourceType, id, decisionSkipped, "already removed")
+	case errdefs.IsNotFound(err):
+		// Already removed.
+		logger.Debug("not found")
+		r.decide(resourceType, id, decisionSkipped, "not found")
+	case errors.Is(err, errImageUntagged):
+		// Handled, but not counted as it still exists.
+		r.decide(resourceType, id, decisionSkipped, "image untagged")
+	case errors.Is(err, errNetworkInUse):
+		// Expected while attached containers stop, so retried without
+		// counting towards the circuit breaker.
+		logger.Debug("network in use, will retry")
+		r.decide(resourceType, id, decisionFailed, err.Error())
+		return err
+	default:
+		logger.Error("remove", fieldError, err)
+		r.circuit.record(err)
+		r.decide(resourceType, id, decisionFailed, err.Error())
+		return err
+	}
+	r.circuit.record(nil)
+
+	return nil
+}
+
+// removalInProgress returns true if err reports that the resource is already
+// being removed, for example by another reaper.
+func removalInProgress(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "already in progress")
+}
+
+// awaitRemoval polls fn until resource id, whose removal is already in progress,
+// is gone or the configured wait is exceeded. It returns nil if the resource is
+// gone, errAlreadyRemoved if its removal is still in progress so it's skipped
+// rather than reported as a failure, otherwise the error returned by fn.
+func (r *reaper) awaitRemoval(ctx context.Context, logger *slog.Logger, id string, fn func(ctx context.Context, id string) error) error {
+	logger.Debug("removal in progress, waiting")
+
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.RemovalInProgressWait)
+	defer cancel()
+
+	ticker := time.NewTicker(removalPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Warn("removal still in progress, skipping", "wait", r.cfg.RemovalInProgressWait)
+			return errAlreadyRemoved
+		case <-ticker.C:
+			pollCtx, pollCancel := context.WithTimeout(ctx, r.cfg.RequestTimeout)
+			err := fn(pollCtx, id)
+			pollCancel()
+
+			switch {
+			case err == nil, errdefs.IsNotFound(err):
+				return nil
+			case !removalInProgress(err):
+				return err
+			}
+		}
+	}
+}
+
+// removeAborted returns a removeError for the resources left in todo
+// when removal was aborted by err.
+func (r *reaper) removeAborted(resourceType string, todo map[string]error, err error) error {
+	for id, lastErr := range todo {
+		if lastErr == nil {
+			todo[id] = err
+		}
+	}
+
+	r.logger.Warn("remove aborted", "resource", resourceType, "left", len(todo), fieldError, err)
+
+	return &removeError{resourceType: resourceType, left: todo}
 }
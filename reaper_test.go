@@ -3,15 +3,23 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"testing"
 	"time"
@@ -26,6 +34,7 @@ import (
 	"github.com/docker/docker/errdefs"
 	"github.com/docker/docker/pkg/archive"
 	"github.com/docker/docker/pkg/jsonmessage"
+	bkclient "github.com/moby/buildkit/client"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 )
@@ -41,21 +50,45 @@ const (
 	imageID2         = "image2"
 	testImage        = "alpine:latest"
 	imageBuildResult = "moby.image.id"
+
+	// labelBase is the default label namespace.
+	labelBase = "org.testcontainers"
+
+	// ryukLabel is the default label used to identify reaper containers.
+	ryukLabel = labelBase + ryukLabelSuffix
+
+	// sessionIDLabel is the default label used to identify sessions.
+	sessionIDLabel = labelBase + sessionIDLabelSuffix
 )
 
 var (
+	// testConfigBase is the base config used for testing, which
+	// tests can copy to customise.
+	testConfigBase = config{
+		Port:                   0,
+		ConnectionTimeout:      time.Millisecond * 500,
+		ReconnectionTimeout:    time.Millisecond * 100,
+		RequestTimeout:         time.Millisecond * 50,
+		ShutdownTimeout:        time.Second * 2,
+		RemoveRetries:          1,
+		RetryOffset:            -time.Second * 2,
+		ChangesRetryInterval:   time.Millisecond * 100,
+		Verbose:                true,
+		PruneContainers:        true,
+		PruneNetworks:          true,
+		PruneVolumes:           true,
+		PruneImages:            true,
+		CountUntaggedImages:    true,
+		LabelNamespace:         labelBase,
+		RemoveContainerVolumes: true,
+	}
+
 	// testConfig is a config used for testing.
-	testConfig = withConfig(config{
-		Port:                 0,
-		ConnectionTimeout:    time.Millisecond * 500,
-		ReconnectionTimeout:  time.Millisecond * 100,
-		RequestTimeout:       time.Millisecond * 50,
-		ShutdownTimeout:      time.Second * 2,
-		RemoveRetries:        1,
-		RetryOffset:          -time.Second * 2,
-		ChangesRetryInterval: time.Millisecond * 100,
-		Verbose:              true,
-	})
+	testConfig = withConfig(testConfigBase)
+
+	// containerRemoveOptions are the options containers are removed with
+	// using testConfigBase.
+	containerRemoveOptions = container.RemoveOptions{RemoveVolumes: true, Force: true}
 
 	// discardLogger is a logger that discards all logs.
 	discardLogger = withLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
@@ -107,12 +140,36 @@ func Test_newReaper(t *testing.T) {
 		cli := &mockClient{}
 		cli.On("Ping", mockContext).Return(types.Ping{}, nil)
 		cli.On("NegotiateAPIVersion", mockContext).Return()
+		cli.On("ClientVersion").Return("1.47")
 		r, err := newReaper(ctx, discardLogger, testConfig, withClient(cli))
 		require.NoError(t, err)
 		require.NotNil(t, r)
 	})
 }
 
+func TestAPIVersion(t *testing.T) {
+	for version, warn := range map[string]bool{"1.47": false, "1.25": false, "1.24": true} {
+		t.Run(version, func(t *testing.T) {
+			cli := &mockClient{}
+			cli.On("Ping", mockContext).Return(types.Ping{}, nil)
+			cli.On("NegotiateAPIVersion", mockContext).Return()
+			cli.On("ClientVersion").Return(version)
+
+			var log safeBuffer
+			r, err := newReaper(context.Background(), withLogger(slog.New(slog.NewTextHandler(&log, nil))), testConfig, withClient(cli))
+			require.NoError(t, err)
+			t.Cleanup(func() { r.listener.Close() })
+
+			require.Regexp(t, `level=INFO msg=starting .* api_version=`+version+`\n`, log.String())
+			if warn {
+				require.Contains(t, log.String(), `level=WARN msg="docker api version below minimum, image label filters may be unreliable" api_version=1.24 min_api_version=1.25`)
+			} else {
+				require.NotContains(t, log.String(), "level=WARN")
+			}
+		})
+	}
+}
+
 // testConnect connects to the given endpoint, sends filter labels,
 // and expects an ACK. The connection is closed when the context is done.
 func testConnect(ctx context.Context, t *testing.T, endpoint string, labels map[string]string) {
@@ -141,6 +198,30 @@ func testConnect(ctx context.Context, t *testing.T, endpoint string, labels map[
 	}()
 }
 
+// testSend connects to the given endpoint, sends line and returns the response.
+// The connection is closed when the context is done.
+func testSend(ctx context.Context, t *testing.T, endpoint, line string) string {
+	t.Helper()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", endpoint)
+	require.NoError(t, err)
+
+	go func() {
+		defer conn.Close()
+		<-ctx.Done()
+	}()
+
+	_, err = conn.Write([]byte(line + "\n"))
+	require.NoError(t, err)
+
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+
+	return string(buf[:n])
+}
+
 // runTest is a test case for the reaper run method.
 type runTest struct {
 	createdAt1 time.Time
@@ -165,6 +246,34 @@ type runTest struct {
 	imageRemoveErr1 error
 	imageRemoveErr2 error
 	imageCreated2   time.Time
+
+	// connect, if set, is called after the standard test clients connect.
+	connect func(ctx context.Context, t *testing.T, addr string)
+
+	// labels1 and labels2, if set, replace testLabels1 and testLabels2
+	// as the labels of the standard test clients and their resources.
+	labels1 map[string]string
+	labels2 map[string]string
+}
+
+// labels returns the labels of the two standard test clients.
+func (tc *runTest) labels() (map[string]string, map[string]string) {
+	labels1, labels2 := testLabels1, testLabels2
+	if tc.labels1 != nil {
+		labels1 = tc.labels1
+	}
+	if tc.labels2 != nil {
+		labels2 = tc.labels2
+	}
+
+	return labels1, labels2
+}
+
+// withSessionID returns a copy of labels with a unique session ID label.
+func withSessionID(labels map[string]string) map[string]string {
+	labels = maps.Clone(labels)
+	labels[sessionIDLabel] = testID()
+	return labels
 }
 
 // newRunTest returns a new runTest with created at times set in the past.
@@ -194,10 +303,12 @@ func newMockClient(tc *runTest) *mockClient {
 	cli := &mockClient{}
 	cli.On("Ping", mock.Anything).Return(types.Ping{}, tc.pingErr)
 	cli.On("NegotiateAPIVersion", mockContext).Return()
+	cli.On("ClientVersion").Return("1.47")
 
 	// Mock the container list and remove calls.
-	filters1 := filterArgs(testLabels1)
-	filters2 := filterArgs(testLabels2)
+	labels1, labels2 := tc.labels()
+	filters1 := filterArgs(labels1)
+	filters2 := filterArgs(labels2)
 	cli.On("ContainerList", mockContext, container.ListOptions{All: true, Filters: filters1}).Return([]types.Container{
 		{
 			ID:      containerID1,
@@ -282,7 +393,8 @@ func newMockClient(tc *runTest) *mockClient {
 }
 
 // testReaperRun runs the reaper with the given test case and returns the log output.
-func testReaperRun(t *testing.T, tc *runTest) (string, error) {
+// Any options are applied after the test defaults.
+func testReaperRun(t *testing.T, tc *runTest, options ...reaperOption) (string, error) {
 	t.Helper()
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
@@ -293,7 +405,7 @@ func testReaperRun(t *testing.T, tc *runTest) (string, error) {
 		Level: slog.LevelDebug,
 	})))
 	client := newMockClient(tc)
-	r, err := newReaper(ctx, logger, withClient(client), testConfig)
+	r, err := newReaper(ctx, append([]reaperOption{logger, withClient(client), testConfig}, options...)...)
 	require.NoError(t, err)
 
 	errCh := make(chan error, 1)
@@ -306,8 +418,12 @@ func testReaperRun(t *testing.T, tc *runTest) (string, error) {
 
 	addr := r.listener.Addr().String()
 	// Connect twice with different labels.
-	testConnect(clientCtx, t, addr, testLabels1)
-	testConnect(clientCtx, t, addr, testLabels2)
+	labels1, labels2 := tc.labels()
+	testConnect(clientCtx, t, addr, labels1)
+	testConnect(clientCtx, t, addr, labels2)
+	if tc.connect != nil {
+		tc.connect(clientCtx, t, addr)
+	}
 
 	select {
 	case err = <-errCh:
@@ -391,6 +507,21 @@ func Test_newReaper_Run(t *testing.T) {
 		require.NotContains(t, log, "level=ERROR")
 		require.NotContains(t, log, "level=WARN")
 		require.Contains(t, log, "removed containers=1 networks=1 volumes=1 images=1")
+		require.Contains(t, log, `msg="prune accounting" resource=container matched=2 removed=1 skipped=1 failed=0`)
+		require.Contains(t, log, `msg="prune accounting" resource=image matched=2 removed=1 skipped=1 failed=0`)
+	})
+
+	t.Run("accounting", func(t *testing.T) {
+		tc := newRunTest()
+		tc.containerRemoveErr1 = errors.New("remove error")
+		tc.volumeRemoveErr2 = errNotFound
+		log, err := testReaperRun(t, tc)
+		require.Error(t, err)
+
+		require.Contains(t, log, `msg="prune accounting" resource=container matched=2 removed=1 skipped=0 failed=1`)
+		require.Contains(t, log, `msg="prune accounting" resource=network matched=2 removed=2 skipped=0 failed=0`)
+		require.Contains(t, log, `msg="prune accounting" resource=volume matched=2 removed=1 skipped=1 failed=0`)
+		require.Contains(t, log, `msg="prune accounting" resource=image matched=2 removed=2 skipped=0 failed=0`)
 	})
 
 	t.Run("container-remove-error", func(t *testing.T) {
@@ -484,6 +615,182 @@ func Test_newReaper_Run(t *testing.T) {
 		require.NotContains(t, log, "level=WARN")
 		require.Contains(t, log, "removed containers=2 networks=2 volumes=2 images=0")
 	})
+
+	t.Run("orphans-file", func(t *testing.T) {
+		tc := newRunTest()
+		tc.containerRemoveErr1 = errors.New("remove error")
+		tc.volumeRemoveErr2 = errors.New("in use")
+		cfg := testConfigBase
+		cfg.OrphansFile = filepath.Join(t.TempDir(), "orphans.json")
+		log, err := testReaperRun(t, tc, withConfig(cfg))
+		require.Error(t, err)
+		require.Contains(t, log, "orphans written")
+
+		data, err := os.ReadFile(cfg.OrphansFile)
+		require.NoError(t, err)
+
+		var orphans map[string][]orphan
+		require.NoError(t, json.Unmarshal(data, &orphans))
+		require.Equal(t, map[string][]orphan{
+			"container": {{ID: containerID1, Error: "remove error"}},
+			"volume":    {{ID: volumeName2, Error: "in use"}},
+		}, orphans)
+	})
+
+	t.Run("orphans-file-none", func(t *testing.T) {
+		tc := newRunTest()
+		cfg := testConfigBase
+		cfg.OrphansFile = filepath.Join(t.TempDir(), "orphans.json")
+		_, err := testReaperRun(t, tc, withConfig(cfg))
+		require.NoError(t, err)
+		require.NoFileExists(t, cfg.OrphansFile)
+	})
+
+	t.Run("stop-signal", func(t *testing.T) {
+		tc := newRunTest()
+		cli := newMockClient(tc)
+		stopOptions := container.StopOptions{Signal: "SIGQUIT"}
+		cli.On("ContainerStop", mockContext, containerID1, stopOptions).Return(nil).Once()
+		cli.On("ContainerStop", mockContext, containerID2, stopOptions).Return(errors.New("stop error")).Once()
+		cfg := testConfigBase
+		cfg.StopSignal = "SIGQUIT"
+		log, err := testReaperRun(t, tc, withConfig(cfg), withClient(cli))
+		require.NoError(t, err)
+		cli.AssertExpectations(t)
+		require.Contains(t, log, `level=WARN msg="container stop" error="stop error" id=`+containerID2+` signal=SIGQUIT`)
+		require.Contains(t, log, "removed containers=2 networks=2 volumes=2 images=2")
+	})
+
+	t.Run("stop-timeout", func(t *testing.T) {
+		// Containers are stopped with their own signal, and a stop slower
+		// than the request timeout doesn't time out the removal.
+		tc := newRunTest()
+		cli := &ctxErrClient{mockClient: newMockClient(tc), delay: time.Millisecond}
+		seconds := 30
+		stopOptions := container.StopOptions{Timeout: &seconds}
+		cli.On("ContainerStop", mockContext, mock.Anything, stopOptions).
+			Run(func(mock.Arguments) { time.Sleep(testConfigBase.RequestTimeout * 2) }).Return(nil).Twice()
+		cfg := testConfigBase
+		cfg.StopTimeout = time.Second * 30
+		log, err := testReaperRun(t, tc, withConfig(cfg), withClient(cli))
+		require.NoError(t, err)
+		cli.AssertExpectations(t)
+		require.Contains(t, log, "removed containers=2 networks=2 volumes=2 images=2")
+	})
+
+	t.Run("images-use-prune", func(t *testing.T) {
+		tc := newRunTest()
+		cli := newMockClient(tc)
+		start := time.Now()
+		for _, labels := range []map[string]string{testLabels1, testLabels2} {
+			// Images created since the prune started, or with the keep or
+			// an exclude label, are excluded by Docker.
+			pruneArgs := mock.MatchedBy(func(args filters.Args) bool {
+				until, err := strconv.ParseInt(args.Get("until")[0], 10, 64)
+				if err != nil || until > start.Unix() {
+					return false
+				}
+
+				args = args.Clone()
+				args.Del("until", args.Get("until")[0])
+				expected := filterArgs(labels)
+				expected.Add("dangling", "false")
+				expected.Add("label!", "keep=true")
+				expected.Add("label!", "exclude")
+				return reflect.DeepEqual(expected, args)
+			})
+			cli.On("ImagesPrune", mockContext, pruneArgs).Return(image.PruneReport{
+				ImagesDeleted: []image.DeleteResponse{
+					{Untagged: labels[labelBase+".sessionID"] + ":latest"},
+					{Deleted: "sha256:" + labels[labelBase+".sessionID"]},
+					{Deleted: "sha256:child-" + labels[labelBase+".sessionID"]},
+				},
+			}, nil).Once()
+		}
+		cfg := testConfigBase
+		cfg.ImagesUsePrune = true
+		cfg.KeepLabel = "keep"
+		cfg.ExcludeLabels = []string{"exclude"}
+		log, err := testReaperRun(t, tc, withConfig(cfg), withClient(cli))
+		require.NoError(t, err)
+		cli.AssertNumberOfCalls(t, "ImagesPrune", 2)
+		cli.AssertNotCalled(t, "ImageRemove", mock.Anything, mock.Anything, mock.Anything)
+		require.Contains(t, log, "removed containers=2 networks=2 volumes=2 images=4")
+	})
+
+	t.Run("images-use-prune-unsupported-exclusion", func(t *testing.T) {
+		// Docker can't exclude label globs, so images are removed individually.
+		tc := newRunTest()
+		cfg := testConfigBase
+		cfg.ImagesUsePrune = true
+		cfg.ExcludeLabels = []string{"exclude=a*"}
+		cli := newMockClient(tc)
+		log, err := testReaperRun(t, tc, withConfig(cfg), withClient(cli))
+		require.NoError(t, err)
+		cli.AssertNotCalled(t, "ImagesPrune", mock.Anything, mock.Anything)
+		cli.AssertCalled(t, "ImageRemove", mockContext, imageID1, imageRemoveOptions)
+		require.Contains(t, log, "removed containers=2 networks=2 volumes=2 images=2")
+	})
+
+	t.Run("hold-file", func(t *testing.T) {
+		tc := newRunTest()
+		cfg := testConfigBase
+		cfg.HoldFile = filepath.Join(t.TempDir(), "hold")
+		require.NoError(t, os.WriteFile(cfg.HoldFile, nil, 0o600))
+		log, err := testReaperRun(t, tc, withConfig(cfg))
+		require.NoError(t, err)
+		require.Contains(t, log, `msg="holding, skipping prune"`)
+		require.NotContains(t, log, "msg=removed")
+	})
+
+	t.Run("dry-run", func(t *testing.T) {
+		tc := newRunTest()
+		cli := newMockClient(tc)
+		cfg := testConfigBase
+		cfg.DryRun = true
+		log, err := testReaperRun(t, tc, withConfig(cfg), withClient(cli))
+		require.NoError(t, err)
+		cli.AssertNotCalled(t, "ContainerRemove", mock.Anything, mock.Anything, mock.Anything)
+		cli.AssertNotCalled(t, "NetworkRemove", mock.Anything, mock.Anything)
+		cli.AssertNotCalled(t, "VolumeRemove", mock.Anything, mock.Anything, mock.Anything)
+		cli.AssertNotCalled(t, "ImageRemove", mock.Anything, mock.Anything, mock.Anything)
+		require.Contains(t, log, `level=INFO msg="would remove" resource=container id=`+containerID1+` dry_run=true`)
+		require.Contains(t, log, `level=INFO msg="would remove" resource=image id=`+imageID2+` dry_run=true`)
+		require.Contains(t, log, "removed containers=2 networks=2 volumes=2 images=2 dry_run=true")
+	})
+
+	t.Run("hold-file-missing", func(t *testing.T) {
+		tc := newRunTest()
+		cfg := testConfigBase
+		cfg.HoldFile = filepath.Join(t.TempDir(), "hold")
+		log, err := testReaperRun(t, tc, withConfig(cfg))
+		require.NoError(t, err)
+		require.NotContains(t, log, "holding")
+		require.Contains(t, log, "removed containers=2 networks=2 volumes=2 images=2")
+	})
+
+	for _, tt := range []struct {
+		name     string
+		disable  func(cfg *config)
+		list     string
+		expected string
+	}{
+		{"containers", func(cfg *config) { cfg.PruneContainers = false }, "ContainerList", "removed containers=0 networks=2 volumes=2 images=2"},
+		{"networks", func(cfg *config) { cfg.PruneNetworks = false }, "NetworkList", "removed containers=2 networks=0 volumes=2 images=2"},
+		{"volumes", func(cfg *config) { cfg.PruneVolumes = false }, "VolumeList", "removed containers=2 networks=2 volumes=0 images=2"},
+		{"images", func(cfg *config) { cfg.PruneImages = false }, "ImageList", "removed containers=2 networks=2 volumes=2 images=0"},
+	} {
+		t.Run("prune-"+tt.name+"-disabled", func(t *testing.T) {
+			tc := newRunTest()
+			cli := newMockClient(tc)
+			cfg := testConfigBase
+			tt.disable(&cfg)
+			log, err := testReaperRun(t, tc, withConfig(cfg), withClient(cli))
+			require.NoError(t, err)
+			cli.AssertNotCalled(t, tt.list, mock.Anything, mock.Anything)
+			require.Contains(t, log, tt.expected)
+		})
+	}
 }
 
 // safeBuffer is a buffer safe for concurrent use.
@@ -552,155 +859,1870 @@ func TestAbortedClient(t *testing.T) {
 	require.Contains(t, log.String(), "shutdown, aborting client")
 }
 
-func TestShutdownSignal(t *testing.T) {
-	t.Run("slow-timeout", func(t *testing.T) {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
-		t.Cleanup(cancel)
+// closeCountListener is a net.Listener which counts calls to Close.
+type closeCountListener struct {
+	net.Listener
+	closes atomic.Int64
+}
 
-		var log safeBuffer
-		logger := withLogger(slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{
-			Level: slog.LevelDebug,
-		})))
-		tc := newRunTest()
-		cli := newMockClient(tc)
-		r, err := newReaper(ctx, logger, withClient(cli), testConfig)
-		require.NoError(t, err)
+// Close implements net.Listener.
+func (l *closeCountListener) Close() error {
+	l.closes.Add(1)
+	return l.Listener.Close() //nolint:wrapcheck // Test wrapper.
+}
 
-		errCh := make(chan error, 1)
-		runCtx, runCancel := context.WithCancel(ctx)
-		t.Cleanup(runCancel)
+func TestShutdownListenerConcurrent(t *testing.T) {
+	r, err := newReaper(context.Background(), discardLogger, testConfig, withClient(newMockClient(newRunTest())))
+	require.NoError(t, err)
+
+	listener := &closeCountListener{Listener: r.listener}
+	r.listener = listener
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for range 10 {
+		wg.Add(1)
 		go func() {
-			errCh <- r.run(runCtx)
+			defer wg.Done()
+			<-start
+			r.shutdownListener()
 		}()
+	}
+	close(start)
+	wg.Wait()
 
-		testConnect(ctx, t, r.listener.Addr().String(), testLabels1)
-		runCancel()
+	require.Equal(t, int64(1), listener.closes.Load())
+	select {
+	case <-r.shutdown:
+	default:
+		t.Fatal("shutdown not closed")
+	}
+}
 
-		select {
-		case err = <-errCh:
-			require.NoError(t, err)
-		case <-ctx.Done():
-			t.Fatal("timeout", log.String())
-		}
+func TestClientReadTimeout(t *testing.T) {
+	cfg := testConfigBase
+	cfg.ClientReadTimeout = time.Millisecond * 100
 
-		data := log.String()
-		require.Contains(t, data, "signal received")
-		require.Contains(t, data, `WARN msg="prune check" clients=1`)
-		require.Contains(t, data, "done")
-	})
+	var silent string
+	tc := newRunTest()
+	tc.connect = func(ctx context.Context, t *testing.T, addr string) {
+		t.Helper()
 
-	t.Run("fast-client-disconnect", func(t *testing.T) {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*1)
-		t.Cleanup(cancel)
+		// Connect without sending anything.
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		silent = conn.LocalAddr().String()
 
-		var log safeBuffer
-		logger := withLogger(slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{
-			Level: slog.LevelDebug,
-		})))
-		tc := newRunTest()
-		cli := newMockClient(tc)
-		r, err := newReaper(ctx, logger, withClient(cli), testConfig)
+		start := time.Now()
+		_, err = conn.Read(make([]byte, 1))
+		require.ErrorIs(t, err, io.EOF)
+		require.Less(t, time.Since(start), time.Millisecond*400)
+	}
+
+	log, err := testReaperRun(t, tc, withConfig(cfg))
+	require.NoError(t, err)
+	require.Contains(t, log, `level=WARN msg="client read timeout, dropping client" address=`+silent+" timeout=100ms")
+	require.Contains(t, log, `msg="client disconnected" address=`+silent)
+
+	// Clients which sent a filter can be idle longer than the timeout.
+	require.Equal(t, 1, strings.Count(log, "client read timeout"))
+	require.Contains(t, log, "removed containers=2 networks=2 volumes=2 images=2")
+}
+
+func TestHeartbeat(t *testing.T) {
+	cfg := testConfigBase
+	cfg.HeartbeatInterval = time.Millisecond * 20
+
+	tc := newRunTest()
+	tc.connect = func(ctx context.Context, t *testing.T, addr string) {
+		t.Helper()
+
+		// Stay connected so the reaper is idle waiting for the client.
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
 		require.NoError(t, err)
+		time.Sleep(time.Millisecond * 200)
+		conn.Close()
+	}
 
-		errCh := make(chan error, 1)
-		runCtx, runCancel := context.WithCancel(ctx)
-		t.Cleanup(runCancel)
-		go func() {
-			errCh <- r.run(runCtx)
-		}()
+	log, err := testReaperRun(t, tc, withConfig(cfg))
+	require.NoError(t, err)
+	require.Regexp(t, `level=INFO msg=idle clients=3 elapsed=\S+\n`, log)
+	require.Contains(t, log, "removed containers=2 networks=2 volumes=2 images=2")
+}
 
-		connectCtx, connectCancel := context.WithTimeout(ctx, time.Millisecond*100)
-		t.Cleanup(connectCancel)
-		testConnect(connectCtx, t, r.listener.Addr().String(), testLabels1)
-		runCancel()
+func TestReconnectionGrace(t *testing.T) {
+	cfg := testConfigBase
+	cfg.ReconnectionGrace = time.Millisecond * 300
 
-		select {
-		case err = <-errCh:
-			require.NoError(t, err)
-		case <-ctx.Done():
-			t.Fatal("timeout", log.String())
-		}
+	tc := newRunTest()
+	tc.connect = func(ctx context.Context, t *testing.T, addr string) {
+		t.Helper()
 
-		data := log.String()
-		require.Contains(t, data, "signal received")
-		require.NotContains(t, data, `WARN msg="prune check" clients=1`)
-		require.Contains(t, data, "done")
-	})
+		// Reconnect after the reconnection timeout, within the grace.
+		<-ctx.Done()
+		time.Sleep(cfg.ReconnectionTimeout + time.Millisecond*50)
+		var d net.Dialer
+		conn, err := d.DialContext(context.Background(), "tcp", addr)
+		require.NoError(t, err)
+		time.Sleep(time.Millisecond * 100)
+		conn.Close()
+	}
 
-	t.Run("immediate-no-clients", func(t *testing.T) {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*1)
-		t.Cleanup(cancel)
+	log, err := testReaperRun(t, tc, withConfig(cfg))
+	require.NoError(t, err)
+	require.Contains(t, log, `msg="reconnection grace" grace=300ms`)
+	require.Equal(t, 1, strings.Count(log, "prune check"))
+	require.Greater(t, strings.Index(log, "prune check"), strings.LastIndex(log, "client connected"))
+	require.Contains(t, log, "removed containers=2 networks=2 volumes=2 images=2")
+}
 
-		var log safeBuffer
-		logger := withLogger(slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{
-			Level: slog.LevelDebug,
-		})))
+func TestMaxFilters(t *testing.T) {
+	cfg := testConfigBase
+	cfg.MaxFilters = 2
+	r, err := newReaper(context.Background(), discardLogger, withConfig(cfg), withClient(newListMockClient(nil)))
+	require.NoError(t, err)
+	t.Cleanup(func() { r.listener.Close() })
+
+	require.NoError(t, r.addFilter("client1", filterKey(filterArgs(testLabels1))))
+	require.NoError(t, r.addFilter("client2", filterKey(filterArgs(testLabels2))))
+
+	// Filters already registered can still be shared at the limit.
+	require.NoError(t, r.addFilter("client3", filterKey(filterArgs(testLabels1))))
+
+	err = r.addFilter("client3", "label=third=true")
+	require.ErrorIs(t, err, errTooManyFilters)
+	require.EqualError(t, err, "too many filters: limit 2")
+	require.Len(t, r.filterArgs(), 2)
+
+	t.Run("disconnect", func(t *testing.T) {
+		cfg := testConfigBase
+		cfg.MaxFilters = 2
 		tc := newRunTest()
-		cli := newMockClient(tc)
-		r, err := newReaper(ctx, logger, withClient(cli), testConfig)
-		require.NoError(t, err)
+		tc.connect = func(ctx context.Context, t *testing.T, addr string) {
+			t.Helper()
 
-		errCh := make(chan error, 1)
-		runCtx, runCancel := context.WithCancel(ctx)
-		t.Cleanup(runCancel)
-		go func() {
-			errCh <- r.run(runCtx)
-		}()
-		runCancel()
+			var d net.Dialer
+			conn, err := d.DialContext(ctx, "tcp", addr)
+			require.NoError(t, err)
+			t.Cleanup(func() { conn.Close() })
 
-		select {
-		case err = <-errCh:
+			_, err = conn.Write([]byte("label=third=true\n"))
 			require.NoError(t, err)
-		case <-ctx.Done():
-			t.Fatal("timeout", log.String())
+
+			// Closed without an ACK.
+			_, err = conn.Read(make([]byte, 1))
+			require.ErrorIs(t, err, io.EOF)
 		}
 
-		data := log.String()
-		require.Contains(t, data, "signal received")
-		require.NotContains(t, data, `WARN msg="prune check" clients=1`)
-		require.Contains(t, data, "done")
+		log, err := testReaperRun(t, tc, withConfig(cfg))
+		require.NoError(t, err)
+		require.Contains(t, log, `error="too many filters: limit 2"`)
+		require.Contains(t, log, "removed containers=2 networks=2 volumes=2 images=2")
 	})
+}
 
-	t.Run("shutdown-timeout", func(t *testing.T) {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
-		t.Cleanup(cancel)
-
-		var log safeBuffer
-		logger := withLogger(slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{
-			Level: slog.LevelDebug,
-		})))
-		tc := newRunTest()
-		// Always trigger a change.
-		tc.containerCreated2 = time.Now().Add(time.Hour)
-		cli := newMockClient(tc)
-		r, err := newReaper(ctx, logger, withClient(cli), testConfig)
-		require.NoError(t, err)
+func TestStrictFilters(t *testing.T) {
+	for _, strict := range []bool{true, false} {
+		t.Run(strconv.FormatBool(strict), func(t *testing.T) {
+			cfg := testConfigBase
+			cfg.StrictFilters = strict
+			malformed := "NACK\n"
+			if !strict {
+				malformed = "ACK\n"
+			}
 
-		errCh := make(chan error, 1)
-		runCtx, runCancel := context.WithCancel(ctx)
-		t.Cleanup(runCancel)
-		go func() {
-			errCh <- r.run(runCtx)
-		}()
+			tc := newRunTest()
+			tc.connect = func(ctx context.Context, t *testing.T, addr string) {
+				t.Helper()
+				require.Equal(t, "ACK\n", testSend(ctx, t, addr, filterKey(filterArgs(testLabels1))))
+				require.Equal(t, malformed, testSend(ctx, t, addr, "label=%zz"))
+			}
 
-		connectCtx, connectCancel := context.WithCancel(ctx)
+			log, err := testReaperRun(t, tc, withConfig(cfg))
+			require.NoError(t, err)
+			require.Contains(t, log, `msg="add filter"`)
+		})
+	}
+}
+
+func TestJSONFilters(t *testing.T) {
+	query := "label=testing=true&label=testing.sessionid=mysession&name=legacy-"
+	tests := map[string]string{
+		"slice": `{"label":["testing=true","testing.sessionid=mysession"],"name":["legacy-"]}`,
+		"map":   `{"label":{"testing=true":true,"testing.sessionid=mysession":true},"name":{"legacy-":true}}`,
+	}
+
+	for name, msg := range tests {
+		t.Run(name, func(t *testing.T) {
+			r, err := newReaper(context.Background(), discardLogger, testConfig, withClient(newListMockClient(nil)))
+			require.NoError(t, err)
+			t.Cleanup(func() { r.listener.Close() })
+
+			// Both encodings are stored as the same filter.
+			require.NoError(t, r.addFilter("client1", query))
+			require.NoError(t, r.addFilter("client2", msg))
+			require.Len(t, r.filters, 1)
+			for _, entry := range r.filters {
+				require.Len(t, entry.clients, 2)
+			}
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := parseFilter(`{"label":`)
+		require.ErrorContains(t, err, "parse json")
+	})
+}
+
+// ctxErrClient is a client whose container removals take delay and
+// fail with the error of their context, as Docker's do, so a cancelled
+// prune can't complete.
+type ctxErrClient struct {
+	*mockClient
+	delay time.Duration
+}
+
+func (c *ctxErrClient) ContainerRemove(ctx context.Context, containerID string, options container.RemoveOptions) error {
+	if err := c.mockClient.ContainerRemove(ctx, containerID, options); err != nil {
+		return err
+	}
+
+	return waitContext(ctx, c.delay)
+}
+
+func TestSecondSignal(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	t.Cleanup(cancel)
+
+	var log safeBuffer
+	logger := withLogger(slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+	})))
+	cfg := testConfigBase
+	cfg.ShutdownTimeout = time.Minute
+	cli := &ctxErrClient{mockClient: newMockClient(newRunTest()), delay: time.Millisecond * 10}
+	r, err := newReaper(ctx, logger, withClient(cli), withConfig(cfg))
+	require.NoError(t, err)
+
+	runCtx, runCancel := context.WithCancel(ctx)
+	t.Cleanup(runCancel)
+	signals := make(chan os.Signal, 1)
+	go r.handleSignals(signals, runCancel)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- r.run(runCtx)
+	}()
+
+	// The connected client would delay the prune until the shutdown timeout.
+	testConnect(ctx, t, r.listener.Addr().String(), testLabels1)
+	signals <- syscall.SIGTERM
+	require.Eventually(t, func() bool {
+		return strings.Contains(log.String(), "signal received")
+	}, time.Second, time.Millisecond*10)
+
+	start := time.Now()
+	signals <- syscall.SIGTERM
+	select {
+	case err = <-errCh:
+		require.NoError(t, err)
+	case <-ctx.Done():
+		t.Fatal("timeout", log.String())
+	}
+	require.Less(t, time.Since(start), time.Second)
+
+	data := log.String()
+	require.Contains(t, data, `level=WARN msg="second signal received, forcing shutdown" signal=terminated`)
+	require.Contains(t, data, `level=WARN msg="forced shutdown, forcing prune" clients=1`)
+	require.Contains(t, data, "removed containers=1 networks=1 volumes=1 images=1")
+	require.Contains(t, data, "done")
+
+	// Only the first call has any effect.
+	r.forceShutdown()
+}
+
+func TestSecondSignalDuringPrune(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	t.Cleanup(cancel)
+
+	var log safeBuffer
+	logger := withLogger(slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+	})))
+	cfg := testConfigBase
+	cfg.RequestTimeout = time.Minute
+	cli := &ctxErrClient{mockClient: newMockClient(newRunTest()), delay: time.Minute}
+	r, err := newReaper(ctx, logger, withClient(cli), withConfig(cfg))
+	require.NoError(t, err)
+
+	runCtx, runCancel := context.WithCancel(ctx)
+	t.Cleanup(runCancel)
+	signals := make(chan os.Signal, 1)
+	go r.handleSignals(signals, runCancel)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- r.run(runCtx)
+	}()
+
+	clientCtx, clientCancel := context.WithCancel(ctx)
+	t.Cleanup(clientCancel)
+	testConnect(clientCtx, t, r.listener.Addr().String(), testLabels1)
+	signals <- syscall.SIGTERM
+	clientCancel()
+
+	// The slow removal is abandoned once the second signal is received.
+	require.Eventually(t, func() bool {
+		return strings.Contains(log.String(), "msg=remove resource=container")
+	}, time.Second, time.Millisecond*10, log.String())
+	start := time.Now()
+	signals <- syscall.SIGTERM
+	select {
+	case err = <-errCh:
+		require.ErrorContains(t, err, "container left 1 items")
+	case <-ctx.Done():
+		t.Fatal("timeout", log.String())
+	}
+	require.Less(t, time.Since(start), time.Second)
+	require.NotContains(t, log.String(), "forced shutdown, forcing prune")
+}
+
+func TestRequireFirstConnection(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	t.Cleanup(cancel)
+
+	var log safeBuffer
+	logger := withLogger(slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+	})))
+	cfg := testConfigBase
+	cfg.RequireFirstConnection = true
+	r, err := newReaper(ctx, logger, withClient(newMockClient(newRunTest())), withConfig(cfg))
+	require.NoError(t, err)
+
+	// No client connects before the connection timeout.
+	err = r.run(ctx)
+	require.ErrorIs(t, err, errNoConnection)
+	require.Contains(t, log.String(), `level=ERROR msg="no client connected" connection_timeout=500ms`)
+	require.NotContains(t, log.String(), "prune check")
+
+	t.Run("connected", func(t *testing.T) {
+		log, err := testReaperRun(t, newRunTest(), withConfig(cfg))
+		require.NoError(t, err)
+		require.Contains(t, log, "removed containers=2 networks=2 volumes=2 images=2")
+	})
+}
+
+func TestShutdownSignal(t *testing.T) {
+	t.Run("slow-timeout", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		t.Cleanup(cancel)
+
+		var log safeBuffer
+		logger := withLogger(slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{
+			Level: slog.LevelDebug,
+		})))
+		tc := newRunTest()
+		cli := newMockClient(tc)
+		r, err := newReaper(ctx, logger, withClient(cli), testConfig)
+		require.NoError(t, err)
+
+		errCh := make(chan error, 1)
+		runCtx, runCancel := context.WithCancel(ctx)
+		t.Cleanup(runCancel)
+		go func() {
+			errCh <- r.run(runCtx)
+		}()
+
+		testConnect(ctx, t, r.listener.Addr().String(), testLabels1)
+		runCancel()
+
+		select {
+		case err = <-errCh:
+			require.NoError(t, err)
+		case <-ctx.Done():
+			t.Fatal("timeout", log.String())
+		}
+
+		data := log.String()
+		require.Contains(t, data, "signal received")
+		require.Contains(t, data, `WARN msg="prune check" clients=1`)
+		require.Contains(t, data, "done")
+	})
+
+	t.Run("fast-client-disconnect", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*1)
+		t.Cleanup(cancel)
+
+		var log safeBuffer
+		logger := withLogger(slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{
+			Level: slog.LevelDebug,
+		})))
+		tc := newRunTest()
+		cli := newMockClient(tc)
+		r, err := newReaper(ctx, logger, withClient(cli), testConfig)
+		require.NoError(t, err)
+
+		errCh := make(chan error, 1)
+		runCtx, runCancel := context.WithCancel(ctx)
+		t.Cleanup(runCancel)
+		go func() {
+			errCh <- r.run(runCtx)
+		}()
+
+		connectCtx, connectCancel := context.WithTimeout(ctx, time.Millisecond*100)
+		t.Cleanup(connectCancel)
+		testConnect(connectCtx, t, r.listener.Addr().String(), testLabels1)
+		runCancel()
+
+		select {
+		case err = <-errCh:
+			require.NoError(t, err)
+		case <-ctx.Done():
+			t.Fatal("timeout", log.String())
+		}
+
+		data := log.String()
+		require.Contains(t, data, "signal received")
+		require.NotContains(t, data, `WARN msg="prune check" clients=1`)
+		require.Contains(t, data, "done")
+	})
+
+	t.Run("immediate-no-clients", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*1)
+		t.Cleanup(cancel)
+
+		var log safeBuffer
+		logger := withLogger(slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{
+			Level: slog.LevelDebug,
+		})))
+		tc := newRunTest()
+		cli := newMockClient(tc)
+		r, err := newReaper(ctx, logger, withClient(cli), testConfig)
+		require.NoError(t, err)
+
+		errCh := make(chan error, 1)
+		runCtx, runCancel := context.WithCancel(ctx)
+		t.Cleanup(runCancel)
+		go func() {
+			errCh <- r.run(runCtx)
+		}()
+		runCancel()
+
+		select {
+		case err = <-errCh:
+			require.NoError(t, err)
+		case <-ctx.Done():
+			t.Fatal("timeout", log.String())
+		}
+
+		data := log.String()
+		require.Contains(t, data, "signal received")
+		require.NotContains(t, data, `WARN msg="prune check" clients=1`)
+		require.Contains(t, data, "done")
+	})
+
+	t.Run("shutdown-timeout", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		t.Cleanup(cancel)
+
+		var log safeBuffer
+		logger := withLogger(slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{
+			Level: slog.LevelDebug,
+		})))
+		tc := newRunTest()
+		// Always trigger a change.
+		tc.containerCreated2 = time.Now().Add(time.Hour)
+		cli := newMockClient(tc)
+		r, err := newReaper(ctx, logger, withClient(cli), testConfig)
+		require.NoError(t, err)
+
+		errCh := make(chan error, 1)
+		runCtx, runCancel := context.WithCancel(ctx)
+		t.Cleanup(runCancel)
+		go func() {
+			errCh <- r.run(runCtx)
+		}()
+
+		connectCtx, connectCancel := context.WithCancel(ctx)
+		t.Cleanup(connectCancel)
+		testConnect(connectCtx, t, r.listener.Addr().String(), testLabels2)
+		connectCancel()
+		runCancel()
+
+		select {
+		case err = <-errCh:
+			require.EqualError(t, err, "prune wait: resources: affected containers: container container2: changes detected")
+		case <-ctx.Done():
+			t.Fatal("timeout", log.String())
+		}
+
+		data := log.String()
+		require.Contains(t, data, "signal received")
+		require.Contains(t, data, "change detected, waiting again")
+		require.Contains(t, data, "shutdown timeout reached, forcing prune")
+		require.Contains(t, data, "done")
+	})
+
+	t.Run("shutdown-timeout-best-effort-ok", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		t.Cleanup(cancel)
+
+		var log safeBuffer
+		logger := withLogger(slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{
+			Level: slog.LevelDebug,
+		})))
+		tc := newRunTest()
+		// Always trigger a change.
+		tc.containerCreated2 = time.Now().Add(time.Hour)
+		cli := newMockClient(tc)
+		cfg := testConfigBase
+		cfg.ShutdownBestEffortOK = true
+		r, err := newReaper(ctx, logger, withClient(cli), withConfig(cfg))
+		require.NoError(t, err)
+
+		errCh := make(chan error, 1)
+		runCtx, runCancel := context.WithCancel(ctx)
+		t.Cleanup(runCancel)
+		go func() {
+			errCh <- r.run(runCtx)
+		}()
+
+		connectCtx, connectCancel := context.WithCancel(ctx)
 		t.Cleanup(connectCancel)
 		testConnect(connectCtx, t, r.listener.Addr().String(), testLabels2)
 		connectCancel()
 		runCancel()
 
+		// The incomplete forced prune is logged rather than returned.
+		select {
+		case err = <-errCh:
+			require.NoError(t, err)
+		case <-ctx.Done():
+			t.Fatal("timeout", log.String())
+		}
+
+		data := log.String()
+		require.Contains(t, data, "shutdown timeout reached, forcing prune")
+		require.Contains(t, data, `level=ERROR msg="forced prune incomplete" error="prune wait: resources: affected containers: container container2: changes detected"`)
+		require.Contains(t, data, "done")
+	})
+}
+
+func Test_filterKey(t *testing.T) {
+	args1 := filters.NewArgs(
+		filters.Arg("label", "a=1"),
+		filters.Arg("label", "b=2"),
+		filters.Arg("name", "test"),
+	)
+	args2 := filters.NewArgs(
+		filters.Arg("name", "test"),
+		filters.Arg("label", "b=2"),
+		filters.Arg("label", "a=1"),
+	)
+	require.Equal(t, filterKey(args1), filterKey(args2))
+	require.Equal(t, "label=a%3D1&label=b%3D2&name=test", filterKey(args1))
+
+	args3 := filters.NewArgs(filters.Arg("label", "a=1"))
+	require.NotEqual(t, filterKey(args1), filterKey(args3))
+
+	t.Run("add-filter", func(t *testing.T) {
+		cfg := testConfigBase
+		r := &reaper{
+			cfg:     &cfg,
+			filters: make(map[string]*filterEntry),
+			logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		}
+		require.NoError(t, r.addFilter("client1", "label=a=1&label=b=2&name=test"))
+		require.NoError(t, r.addFilter("client2", "name=test&label=b=2&label=a=1"))
+		require.Len(t, r.filterArgs(), 1)
+	})
+}
+
+// newListMockClient returns a new mock client which returns containers for the
+// given filters and no other resources.
+func newListMockClient(containers map[*filters.Args][]types.Container) *mockClient {
+	cli := &mockClient{}
+	cli.On("Ping", mockContext).Return(types.Ping{}, nil)
+	cli.On("NegotiateAPIVersion", mockContext).Return()
+	cli.On("ClientVersion").Return("1.47")
+	for args, list := range containers {
+		cli.On("ContainerList", mockContext, container.ListOptions{All: true, Filters: *args}).Return(list, nil)
+	}
+	cli.On("NetworkList", mockContext, mock.Anything).Return([]network.Summary{}, nil)
+	cli.On("VolumeList", mockContext, mock.Anything).Return(volume.ListResponse{}, nil)
+	cli.On("ImageList", mockContext, mock.Anything).Return([]image.Summary{}, nil)
+
+	return cli
+}
+
+func TestReferenceCounting(t *testing.T) {
+	ctx := context.Background()
+	since := time.Now()
+	created := since.Add(-time.Minute).Unix()
+	filters1 := filterArgs(testLabels1)
+	filters2 := filterArgs(testLabels2)
+	shared := types.Container{ID: "shared", Created: created}
+	exclusive := types.Container{ID: "exclusive", Created: created}
+
+	// newTestReaper returns a reaper with client1 registering testLabels1
+	// and client2 registering testLabels2, with client2 disconnected.
+	newTestReaper := func(t *testing.T, refCount bool) *reaper {
+		t.Helper()
+
+		cli := newListMockClient(map[*filters.Args][]types.Container{
+			&filters1: {shared},
+			&filters2: {shared, exclusive},
+		})
+		cfg := testConfigBase
+		cfg.ReferenceCounting = refCount
+		r, err := newReaper(ctx, discardLogger, withConfig(cfg), withClient(cli))
+		require.NoError(t, err)
+		t.Cleanup(func() { r.listener.Close() })
+
+		require.NoError(t, r.addFilter("client1", filterKey(filters1)))
+		require.NoError(t, r.addFilter("client2", filterKey(filters2)))
+		r.releaseFilters("client2")
+
+		return r
+	}
+
+	t.Run("overlap-active", func(t *testing.T) {
+		r := newTestReaper(t, true)
+		res, err := r.resources(since)
+		require.NoError(t, err)
+		require.Equal(t, []string{exclusive.ID}, res.containers)
+	})
+
+	t.Run("overlap-released", func(t *testing.T) {
+		r := newTestReaper(t, true)
+		r.releaseFilters("client1")
+		res, err := r.resources(since)
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{shared.ID, shared.ID, exclusive.ID}, res.containers)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		r := newTestReaper(t, false)
+		res, err := r.resources(since)
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{shared.ID, shared.ID, exclusive.ID}, res.containers)
+	})
+
+	t.Run("client-prune", func(t *testing.T) {
+		r := newTestReaper(t, true)
+		cli := r.client.(*mockClient)
+		cli.On("ContainerRemove", mockContext, exclusive.ID, containerRemoveOptions).Return(nil)
+
+		// The prune of client2's filter must skip the container
+		// still referenced by client1.
+		r.pruneClient(pruneRequest{addr: "client2", keys: []string{filterKey(filters2)}})
+		require.Eventually(t, func() bool {
+			r.mtx.Lock()
+			defer r.mtx.Unlock()
+			_, ok := r.filters[filterKey(filters2)]
+			return !ok
+		}, time.Second, time.Millisecond*10)
+		cli.AssertCalled(t, "ContainerRemove", mockContext, exclusive.ID, containerRemoveOptions)
+		cli.AssertNotCalled(t, "ContainerRemove", mockContext, shared.ID, containerRemoveOptions)
+	})
+}
+
+func TestSessionDeadline(t *testing.T) {
+	ctx := context.Background()
+	since := time.Now()
+	created := since.Add(-time.Minute).Unix()
+	filters1 := filterArgs(testLabels1)
+	filters2 := filterArgs(testLabels2)
+
+	// blockUntilDone blocks the mocked call until its context is done.
+	blockUntilDone := func(args mock.Arguments) {
+		<-args.Get(0).(context.Context).Done()
+	}
+
+	// newTestReaper returns a reaper with a 100ms session deadline and a filter
+	// registered for each session.
+	newTestReaper := func(t *testing.T, cli *mockClient) (*reaper, *safeBuffer) {
+		t.Helper()
+
+		var log safeBuffer
+		logger := withLogger(slog.New(slog.NewTextHandler(&log, nil)))
+		cfg := testConfigBase
+		cfg.RequestTimeout = time.Second
+		cfg.SessionDeadline = time.Millisecond * 100
+		r, err := newReaper(ctx, logger, withConfig(cfg), withClient(cli))
+		require.NoError(t, err)
+		t.Cleanup(func() { r.listener.Close() })
+
+		require.NoError(t, r.addFilter("client1", filterKey(filters1)))
+		require.NoError(t, r.addFilter("client2", filterKey(filters2)))
+
+		return r, &log
+	}
+
+	t.Run("slow-list", func(t *testing.T) {
+		cli := newListMockClient(map[*filters.Args][]types.Container{
+			&filters2: {{ID: containerID2, Created: created}},
+		})
+		cli.On("ContainerList", mockContext, container.ListOptions{All: true, Filters: filters1}).
+			Run(blockUntilDone).Return([]types.Container{}, context.DeadlineExceeded)
+		cli.On("ContainerRemove", mockContext, containerID2, containerRemoveOptions).Return(nil).Once()
+		r, log := newTestReaper(t, cli)
+
+		start := time.Now()
+		res, err := r.resources(since)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.Less(t, time.Since(start), time.Second)
+		require.Equal(t, []string{containerID2}, res.containers)
+		require.Len(t, res.sessions, 2)
+		require.Contains(t, log.String(), `msg="session deadline exceeded listing" filter=`+strconv.Quote(filterKey(filters1)))
+
+		require.NoError(t, r.prune(context.Background(), res))
+		cli.AssertExpectations(t)
+		require.Contains(t, log.String(), "removed containers=1 networks=0 volumes=0 images=0")
+	})
+
+	t.Run("slow-remove", func(t *testing.T) {
+		cli := newListMockClient(map[*filters.Args][]types.Container{
+			&filters1: {{ID: containerID1, Created: created}},
+			&filters2: {{ID: containerID2, Created: created}},
+		})
+		cli.On("ContainerRemove", mockContext, containerID1, containerRemoveOptions).
+			Run(blockUntilDone).Return(context.DeadlineExceeded)
+		cli.On("ContainerRemove", mockContext, containerID2, containerRemoveOptions).Return(nil).Once()
+		r, log := newTestReaper(t, cli)
+
+		res, err := r.resources(since)
+		require.NoError(t, err)
+
+		start := time.Now()
+		require.EqualError(t, r.prune(context.Background(), res), "container left 1 items")
+		require.Less(t, time.Since(start), time.Second)
+		cli.AssertExpectations(t)
+
+		data := log.String()
+		require.Contains(t, data, `msg="session deadline exceeded removing"`)
+		require.Contains(t, data, "containers=1 networks=0 volumes=0 images=0")
+		require.Contains(t, data, "removed containers=1 networks=0 volumes=0 images=0")
+	})
+}
+
+func TestPruneAbandoned(t *testing.T) {
+	cli := &mockClient{}
+	cli.On("ContainerRemove", mockContext, containerID1, containerRemoveOptions).
+		Run(func(args mock.Arguments) {
+			<-args.Get(0).(context.Context).Done()
+		}).Return(context.DeadlineExceeded)
+
+	var log safeBuffer
+	cfg := testConfigBase
+	cfg.RequestTimeout = time.Second
+	r := &reaper{
+		cfg:    &cfg,
+		client: cli,
+		logger: slog.New(slog.NewTextHandler(&log, nil)),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	t.Cleanup(cancel)
+
+	start := time.Now()
+	require.Error(t, r.prune(ctx, &resources{containers: []string{containerID1, containerID2}}))
+	require.Less(t, time.Since(start), time.Second)
+	require.Contains(t, log.String(), `level=WARN msg=abandoned resource=container ids="[`+containerID1+" "+containerID2+`]" error="context deadline exceeded"`)
+
+	t.Run("completed", func(t *testing.T) {
+		var log safeBuffer
+		r.logger = slog.New(slog.NewTextHandler(&log, nil))
+		r.logAbandoned(context.Background(), []error{&removeError{resourceType: "container", left: map[string]error{containerID1: errors.New("in use")}}})
+		require.NotContains(t, log.String(), "abandoned")
+	})
+}
+
+func TestPruneOrder(t *testing.T) {
+	var order []string
+	record := func(resourceType string) func(mock.Arguments) {
+		return func(mock.Arguments) { order = append(order, resourceType) }
+	}
+
+	cli := &mockClient{}
+	cli.On("ContainerRemove", mockContext, containerID1, containerRemoveOptions).Run(record("container")).Return(nil)
+	cli.On("NetworkRemove", mockContext, networkID1).Run(record("network")).Return(nil)
+	cli.On("VolumeRemove", mockContext, volumeName1, volumeRemoveForce).Run(record("volume")).Return(nil)
+	cli.On("ImageRemove", mockContext, imageID1, imageRemoveOptions).Run(record("image")).
+		Return([]image.DeleteResponse{{Deleted: imageID1}}, nil)
+
+	res := &resources{
+		containers: []string{containerID1},
+		networks:   []string{networkID1},
+		volumes:    []string{volumeName1},
+		images:     []string{imageID1},
+	}
+	cfg := testConfigBase
+	r := &reaper{cfg: &cfg, client: cli, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	// The default order removes containers first.
+	require.NoError(t, r.prune(context.Background(), res))
+	require.Equal(t, []string{"container", "network", "volume", "image"}, order)
+
+	order = nil
+	cfg.PruneOrder = []string{"container", "volume", "network", "image"}
+	require.NoError(t, r.prune(context.Background(), res))
+	require.Equal(t, cfg.PruneOrder, order)
+}
+
+func TestRemoveContainerVolumes(t *testing.T) {
+	keep := container.RemoveOptions{Force: true}
+	cli := &mockClient{}
+	cli.On("ContainerRemove", mockContext, containerID1, keep).Return(nil)
+
+	cfg := testConfigBase
+	cfg.RemoveContainerVolumes = false
+	r := &reaper{cfg: &cfg, client: cli, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	// Anonymous volumes are kept with the container removed.
+	require.NoError(t, r.prune(context.Background(), &resources{containers: []string{containerID1}}))
+	cli.AssertCalled(t, "ContainerRemove", mockContext, containerID1, keep)
+	cli.AssertNotCalled(t, "ContainerRemove", mockContext, containerID1, containerRemoveOptions)
+}
+
+func TestMaxRemovals(t *testing.T) {
+	cli := &mockClient{}
+	var log safeBuffer
+	cfg := testConfigBase
+	cfg.MaxRemovals = 3
+	r := &reaper{cfg: &cfg, client: cli, logger: slog.New(slog.NewTextHandler(&log, nil))}
+
+	res := &resources{
+		containers: []string{containerID1, containerID2},
+		networks:   []string{networkID1},
+		volumes:    []string{volumeName1},
+	}
+
+	// Exceeding the limit removes nothing.
+	err := r.prune(context.Background(), res)
+	require.ErrorIs(t, err, errTooManyRemovals)
+	require.EqualError(t, err, "too many removals: 4 over limit 3")
+	require.Contains(t, log.String(), `level=ERROR msg="too many removals, refusing to prune" limit=3 containers=2 networks=1 volumes=1 images=0`)
+	cli.AssertNotCalled(t, "ContainerRemove", mock.Anything, mock.Anything, mock.Anything)
+	cli.AssertNotCalled(t, "NetworkRemove", mock.Anything, mock.Anything)
+	cli.AssertNotCalled(t, "VolumeRemove", mock.Anything, mock.Anything, mock.Anything)
+	cli.AssertNotCalled(t, "ImageRemove", mock.Anything, mock.Anything, mock.Anything)
+	require.NotContains(t, log.String(), "msg=removed")
+
+	t.Run("deferred-images", func(t *testing.T) {
+		images := &resources{images: []string{imageID1, imageID2, "image3", "image4"}}
+		err := r.pruneDeferredImages(context.Background(), images)
+		require.ErrorIs(t, err, errTooManyRemovals)
+		cli.AssertNotCalled(t, "ImageRemove", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("client-prune", func(t *testing.T) {
+		created := time.Now().Add(-time.Minute).Unix()
+		args := filterArgs(testLabels1)
+		cli := newListMockClient(map[*filters.Args][]types.Container{
+			&args: {
+				{ID: containerID1, Created: created},
+				{ID: containerID2, Created: created},
+				{ID: "container3", Created: created},
+				{ID: "container4", Created: created},
+			},
+		})
+		var log safeBuffer
+		r, err := newReaper(context.Background(), withLogger(slog.New(slog.NewTextHandler(&log, nil))), withConfig(cfg), withClient(cli))
+		require.NoError(t, err)
+		t.Cleanup(func() { r.listener.Close() })
+		require.NoError(t, r.addFilter("client", filterKey(args)))
+
+		r.pruneClient(pruneRequest{addr: "client", keys: []string{filterKey(args)}})
+		require.Eventually(t, func() bool {
+			return strings.Contains(log.String(), `level=ERROR msg="client prune"`)
+		}, time.Second, time.Millisecond*10, log.String())
+		require.Contains(t, log.String(), "too many removals: 4 over limit 3")
+		cli.AssertNotCalled(t, "ContainerRemove", mock.Anything, mock.Anything, mock.Anything)
+
+		// The filter is left for the final prune.
+		r.mtx.Lock()
+		defer r.mtx.Unlock()
+		require.Contains(t, r.filters, filterKey(args))
+	})
+}
+
+func TestScopeNetwork(t *testing.T) {
+	since := time.Now()
+	created := since.Add(-time.Minute).Unix()
+	args := filterArgs(testLabels1)
+	scoped := args.Clone()
+	scoped.Add("network", "tests")
+	cli := newListMockClient(map[*filters.Args][]types.Container{
+		&args:   {{ID: containerID1, Created: created}, {ID: containerID2, Created: created}},
+		&scoped: {{ID: containerID2, Created: created}},
+	})
+
+	cfg := testConfigBase
+	cfg.ScopeNetwork = "tests"
+	r := &reaper{
+		cfg:    &cfg,
+		client: cli,
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	ids, _, err := r.affectedContainers(context.Background(), since, args, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{containerID2}, ids)
+
+	t.Run("disabled", func(t *testing.T) {
+		cfg.ScopeNetwork = ""
+		ids, _, err := r.affectedContainers(context.Background(), since, args, nil, nil)
+		require.NoError(t, err)
+		require.Equal(t, []string{containerID1, containerID2}, ids)
+	})
+}
+
+func TestContainerHealth(t *testing.T) {
+	since := time.Now()
+	created := since.Add(-time.Minute).Unix()
+	args := filterArgs(testLabels1)
+	health := args.Clone()
+	health.Add("health", "unhealthy")
+	health.Add("health", "none")
+	cli := newListMockClient(map[*filters.Args][]types.Container{
+		&args:   {{ID: containerID1, Created: created, Status: "Up 1 minute (healthy)"}, {ID: containerID2, Created: created, Status: "Up 1 minute (unhealthy)"}},
+		&health: {{ID: containerID2, Created: created, Status: "Up 1 minute (unhealthy)"}},
+	})
+
+	cfg := testConfigBase
+	cfg.ContainerHealth = []string{"unhealthy", "none"}
+	r := &reaper{
+		cfg:    &cfg,
+		client: cli,
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	ids, _, err := r.affectedContainers(context.Background(), since, args, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{containerID2}, ids)
+
+	t.Run("disabled", func(t *testing.T) {
+		cfg.ContainerHealth = nil
+		ids, _, err := r.affectedContainers(context.Background(), since, args, nil, nil)
+		require.NoError(t, err)
+		require.Equal(t, []string{containerID1, containerID2}, ids)
+	})
+}
+
+func TestBindAddr(t *testing.T) {
+	// freePort returns a port which was free on the bind address.
+	freePort := func(t *testing.T) uint16 {
+		t.Helper()
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer listener.Close()
+
+		return uint16(listener.Addr().(*net.TCPAddr).Port) //nolint:forcetypeassert,gosec // Always a TCP port.
+	}
+
+	var log safeBuffer
+	cfg := testConfigBase
+	cfg.BindAddr = "127.0.0.1"
+	cfg.HealthPort = freePort(t)
+	cfg.AdminPort = freePort(t)
+	r, err := newReaper(context.Background(),
+		withConfig(cfg),
+		withClient(newListMockClient(nil)),
+		withLogger(slog.New(slog.NewTextHandler(&log, nil))),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		r.listener.Close()
+		r.healthListener.Close()
+		r.adminListener.Close()
+	})
+
+	addr, ok := r.listener.Addr().(*net.TCPAddr)
+	require.True(t, ok)
+	require.Equal(t, "127.0.0.1", addr.IP.String())
+	require.NotZero(t, addr.Port)
+	require.Contains(t, log.String(), "msg=Started address="+addr.String())
+
+	// The health and admin endpoints use the same address.
+	for _, listener := range []net.Listener{r.healthListener, r.adminListener} {
+		addr, ok := listener.Addr().(*net.TCPAddr)
+		require.True(t, ok)
+		require.Equal(t, "127.0.0.1", addr.IP.String())
+	}
+
+	t.Run("listen-error", func(t *testing.T) {
+		// The endpoints already listening are closed if a later one fails.
+		for name, port := range map[string]func(cfg *config, used uint16){
+			"admin": func(cfg *config, used uint16) { cfg.AdminPort = used },
+			"main":  func(cfg *config, used uint16) { cfg.Port = used },
+		} {
+			t.Run(name, func(t *testing.T) {
+				used, err := net.Listen("tcp", "127.0.0.1:0")
+				require.NoError(t, err)
+				t.Cleanup(func() { used.Close() })
+
+				usedPort := uint16(used.Addr().(*net.TCPAddr).Port) //nolint:forcetypeassert,gosec // Always a TCP port.
+				cfg := testConfigBase
+				cfg.BindAddr = "127.0.0.1"
+				cfg.HealthPort = freePort(t)
+				cfg.AdminPort = freePort(t)
+				port(&cfg, usedPort)
+				_, err = newReaper(context.Background(), discardLogger, withConfig(cfg), withClient(newListMockClient(nil)))
+				require.ErrorIs(t, err, errListen)
+
+				for _, free := range []uint16{cfg.HealthPort, cfg.AdminPort} {
+					if free == usedPort {
+						continue
+					}
+
+					listener, err := net.Listen("tcp", net.JoinHostPort(cfg.BindAddr, strconv.Itoa(int(free))))
+					require.NoError(t, err)
+					listener.Close()
+				}
+			})
+		}
+	})
+}
+
+func TestLabelNamespace(t *testing.T) {
+	const namespace = "io.podman.testing"
+	since := time.Now()
+	created := since.Add(-time.Minute).Unix()
+	args := filterArgs(map[string]string{namespace + sessionIDLabelSuffix: "session1"})
+	cli := newListMockClient(map[*filters.Args][]types.Container{
+		&args: {
+			{ID: "reaper", Created: created, Labels: map[string]string{namespace + ryukLabelSuffix: "true"}},
+			{ID: "default-reaper", Created: created, Labels: map[string]string{ryukLabel: "true"}},
+			{ID: "container", Created: created},
+		},
+	})
+
+	cfg := testConfigBase
+	cfg.LabelNamespace = namespace
+	r, err := newReaper(context.Background(), discardLogger, withConfig(cfg), withClient(cli))
+	require.NoError(t, err)
+	t.Cleanup(func() { r.listener.Close() })
+
+	// Only reapers in the configured namespace are skipped.
+	ids, _, err := r.affectedContainers(context.Background(), since, args, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"default-reaper", "container"}, ids)
+
+	// Session IDs are read from the configured namespace.
+	require.NoError(t, r.addFilter("client1", filterKey(args)))
+	require.NoError(t, r.addFilter("client1", filterKey(filterArgs(testLabels1))))
+	require.Equal(t, []string{"session1"}, r.sessionIDs())
+}
+
+func TestKeepLabel(t *testing.T) {
+	since := time.Now()
+	created := since.Add(-time.Minute)
+	args := filterArgs(testLabels1)
+	kept := map[string]string{"debug.keep": "true"}
+	notKept := map[string]string{"debug.keep": "false"}
+
+	cli := &mockClient{}
+	cli.On("ContainerList", mockContext, mock.Anything).Return([]types.Container{
+		{ID: containerID1, Created: created.Unix(), Labels: kept},
+		{ID: containerID2, Created: created.Unix(), Labels: notKept},
+	}, nil)
+	cli.On("NetworkList", mockContext, mock.Anything).Return([]network.Summary{
+		{ID: networkID1, Created: created, Labels: kept},
+		{ID: networkID2, Created: created},
+	}, nil)
+	cli.On("VolumeList", mockContext, mock.Anything).Return(volume.ListResponse{Volumes: []*volume.Volume{
+		{Name: volumeName1, CreatedAt: created.Format(time.RFC3339), Labels: kept},
+		{Name: volumeName2, CreatedAt: created.Format(time.RFC3339)},
+	}}, nil)
+	cli.On("ImageList", mockContext, mock.Anything).Return([]image.Summary{
+		{ID: imageID1, Created: created.Unix(), Labels: kept},
+		{ID: imageID2, Created: created.Unix()},
+	}, nil)
+
+	var log bytes.Buffer
+	cfg := testConfigBase
+	cfg.KeepLabel = "debug.keep"
+	r := &reaper{
+		cfg:    &cfg,
+		client: cli,
+		logger: slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	}
+
+	ctx := context.Background()
+	containers, _, err := r.affectedContainers(ctx, since, args, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{containerID2}, containers)
+
+	networks, err := r.affectedNetworks(ctx, since, args, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{networkID2}, networks)
+
+	volumes, err := r.affectedVolumes(ctx, since, args, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{volumeName2}, volumes)
+
+	images, err := r.affectedImages(ctx, since, args, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{imageID2}, images)
+
+	require.Contains(t, log.String(), `msg="skipping kept resource" resource=container id=`+containerID1)
+	require.Contains(t, log.String(), `msg="skipping kept resource" resource=network id=`+networkID1)
+	require.Contains(t, log.String(), `msg="skipping kept resource" resource=volume id=`+volumeName1)
+	require.Contains(t, log.String(), `msg="skipping kept resource" resource=image id=`+imageID1)
+
+	t.Run("disabled", func(t *testing.T) {
+		cfg.KeepLabel = ""
+		containers, _, err := r.affectedContainers(ctx, since, args, nil, nil)
+		require.NoError(t, err)
+		require.Equal(t, []string{containerID1, containerID2}, containers)
+	})
+}
+
+func TestExcludeLabels(t *testing.T) {
+	since := time.Now()
+	created := since.Add(-time.Minute)
+	args := filterArgs(testLabels1)
+
+	// Resources match the session filter, some also match an exclude label.
+	excluded := maps.Clone(testLabels1)
+	excluded["infrastructure"] = "true"
+	platform := maps.Clone(testLabels1)
+	platform["team"] = "platform"
+	other := maps.Clone(testLabels1)
+	other["team"] = "other"
+
+	cli := &mockClient{}
+	cli.On("ContainerList", mockContext, mock.Anything).Return([]types.Container{
+		{ID: containerID1, Created: created.Unix(), Labels: excluded},
+		{ID: containerID2, Created: created.Unix(), Labels: other},
+	}, nil)
+	cli.On("NetworkList", mockContext, mock.Anything).Return([]network.Summary{
+		{ID: networkID1, Created: created, Labels: platform},
+		{ID: networkID2, Created: created, Labels: testLabels1},
+	}, nil)
+	cli.On("VolumeList", mockContext, mock.Anything).Return(volume.ListResponse{Volumes: []*volume.Volume{
+		{Name: volumeName1, CreatedAt: created.Format(time.RFC3339), Labels: excluded},
+		{Name: volumeName2, CreatedAt: created.Format(time.RFC3339), Labels: testLabels1},
+	}}, nil)
+	cli.On("ImageList", mockContext, mock.Anything).Return([]image.Summary{
+		{ID: imageID1, Created: created.Unix(), Labels: platform},
+		{ID: imageID2, Created: created.Unix(), Labels: other},
+	}, nil)
+
+	var log safeBuffer
+	cfg := testConfigBase
+	cfg.ExcludeLabels = []string{"infrastructure", "team=platform"}
+	r := &reaper{
+		cfg:    &cfg,
+		client: cli,
+		logger: slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	}
+
+	ctx := context.Background()
+	containers, _, err := r.affectedContainers(ctx, since, args, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{containerID2}, containers)
+
+	networks, err := r.affectedNetworks(ctx, since, args, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{networkID2}, networks)
+
+	volumes, err := r.affectedVolumes(ctx, since, args, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{volumeName2}, volumes)
+
+	images, err := r.affectedImages(ctx, since, args, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{imageID2}, images)
+
+	require.Contains(t, log.String(), `msg="skipping excluded resource" resource=container id=`+containerID1+` label=infrastructure`)
+	require.Contains(t, log.String(), `msg="skipping excluded resource" resource=network id=`+networkID1+` label="team=platform"`)
+	require.Contains(t, log.String(), `msg="skipping excluded resource" resource=volume id=`+volumeName1+` label=infrastructure`)
+	require.Contains(t, log.String(), `msg="skipping excluded resource" resource=image id=`+imageID1+` label="team=platform"`)
+
+	t.Run("disabled", func(t *testing.T) {
+		cfg.ExcludeLabels = nil
+		containers, _, err := r.affectedContainers(ctx, since, args, nil, nil)
+		require.NoError(t, err)
+		require.Equal(t, []string{containerID1, containerID2}, containers)
+	})
+}
+
+func TestImageRetryOffset(t *testing.T) {
+	now := time.Now()
+	created := now.Add(-time.Second * 2)
+	args := filterArgs(testLabels1)
+
+	cli := &mockClient{}
+	cli.On("ContainerList", mockContext, mock.Anything).Return([]types.Container{
+		{ID: containerID1, Created: created.Unix()},
+	}, nil)
+	cli.On("ImageList", mockContext, mock.Anything).Return([]image.Summary{
+		{ID: imageID1, Created: created.Unix()},
+	}, nil)
+
+	cfg := testConfigBase
+	cfg.RetryOffset = -time.Second * 5
+	r := &reaper{cfg: &cfg, client: cli, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	// Both were created within the retry offset, so are changes.
+	ctx := context.Background()
+	since := now.Add(cfg.RetryOffset)
+	_, _, err := r.affectedContainers(ctx, since, args, nil, nil)
+	require.ErrorIs(t, err, errChangesDetected)
+	_, err = r.affectedImages(ctx, since, args, nil, nil)
+	require.ErrorIs(t, err, errChangesDetected)
+
+	// The image offset only applies to images.
+	cfg.ImageRetryOffset = -time.Second
+	_, _, err = r.affectedContainers(ctx, since, args, nil, nil)
+	require.ErrorIs(t, err, errChangesDetected)
+	images, err := r.affectedImages(ctx, since, args, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{imageID1}, images)
+}
+
+func TestMaintenanceWindow(t *testing.T) {
+	t.Run("deferred", func(t *testing.T) {
+		cfg := testConfigBase
+		cfg.MaintenanceUntil = time.Now().Add(time.Second)
+		log, err := testReaperRun(t, newRunTest(), withConfig(cfg))
+		require.NoError(t, err)
+		require.False(t, time.Now().Before(cfg.MaintenanceUntil))
+
+		deferred := strings.Index(log, `msg="maintenance window, deferring prune"`)
+		check := strings.Index(log, `msg="prune check"`)
+		require.NotEqual(t, -1, deferred)
+		require.Greater(t, check, deferred)
+		require.Contains(t, log, "removed containers=2 networks=2 volumes=2 images=2")
+	})
+
+	t.Run("passed", func(t *testing.T) {
+		cfg := testConfigBase
+		cfg.MaintenanceUntil = time.Now().Add(-time.Minute)
+		log, err := testReaperRun(t, newRunTest(), withConfig(cfg))
+		require.NoError(t, err)
+		require.NotContains(t, log, "maintenance window")
+		require.Contains(t, log, "removed containers=2 networks=2 volumes=2 images=2")
+	})
+
+	t.Run("shutdown-timeout", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		t.Cleanup(cancel)
+
+		var log safeBuffer
+		logger := withLogger(slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{
+			Level: slog.LevelDebug,
+		})))
+		cfg := testConfigBase
+		cfg.ShutdownTimeout = time.Millisecond * 200
+		cfg.MaintenanceUntil = time.Now().Add(time.Hour)
+		r, err := newReaper(ctx, logger, withClient(newMockClient(newRunTest())), withConfig(cfg))
+		require.NoError(t, err)
+
+		errCh := make(chan error, 1)
+		runCtx, runCancel := context.WithCancel(ctx)
+		t.Cleanup(runCancel)
+		go func() {
+			errCh <- r.run(runCtx)
+		}()
+
+		// Client stays connected so the prune is forced at the shutdown timeout.
+		testConnect(ctx, t, r.listener.Addr().String(), testLabels1)
+		runCancel()
+
 		select {
 		case err = <-errCh:
-			require.EqualError(t, err, "prune wait: resources: affected containers: container container2: changes detected")
+			require.NoError(t, err)
 		case <-ctx.Done():
 			t.Fatal("timeout", log.String())
 		}
 
 		data := log.String()
-		require.Contains(t, data, "signal received")
-		require.Contains(t, data, "change detected, waiting again")
-		require.Contains(t, data, "shutdown timeout reached, forcing prune")
-		require.Contains(t, data, "done")
+		require.Contains(t, data, "shutdown timeout reached, ignoring maintenance window")
+		require.Contains(t, data, "removed containers=1 networks=1 volumes=1 images=1")
+	})
+}
+
+func TestMemoryLimit(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	t.Cleanup(cancel)
+
+	var log safeBuffer
+	logger := withLogger(slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+	})))
+	tc := newRunTest()
+	cli := newMockClient(tc)
+	cfg := testConfigBase
+	cfg.MaxMemory = 1 // Always exceeded.
+	r, err := newReaper(ctx, logger, withClient(cli), withConfig(cfg))
+	require.NoError(t, err)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- r.run(ctx)
+	}()
+
+	// Client stays connected so only the memory limit can trigger the prune.
+	testConnect(ctx, t, r.listener.Addr().String(), testLabels1)
+
+	select {
+	case err = <-errCh:
+		require.NoError(t, err)
+	case <-ctx.Done():
+		t.Fatal("timeout", log.String())
+	}
+
+	data := log.String()
+	require.Contains(t, data, "memory limit exceeded, forcing prune")
+	require.Contains(t, data, `WARN msg="prune check" clients=1`)
+	require.Contains(t, data, "removed containers=1 networks=1 volumes=1 images=1")
+}
+
+func TestMinClients(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	t.Cleanup(cancel)
+
+	var log safeBuffer
+	logger := withLogger(slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+	})))
+	cli := newMockClient(newRunTest())
+	cfg := testConfigBase
+	cfg.MinClients = 2
+	r, err := newReaper(ctx, logger, withClient(cli), withConfig(cfg))
+	require.NoError(t, err)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- r.run(ctx)
+	}()
+
+	// First shard connects and disconnects, which must not prune.
+	addr := r.listener.Addr().String()
+	shardCtx, shardCancel := context.WithCancel(ctx)
+	testConnect(shardCtx, t, addr, testLabels1)
+	shardCancel()
+
+	select {
+	case err = <-errCh:
+		t.Fatal("pruned before minimum clients", err, log.String())
+	case <-time.After(cfg.ReconnectionTimeout * 3):
+	}
+	require.Contains(t, log.String(), `msg="waiting for minimum clients" seen=1 min_clients=2`)
+
+	// Second shard completes the minimum.
+	shardCtx, shardCancel = context.WithCancel(ctx)
+	testConnect(shardCtx, t, addr, testLabels2)
+	shardCancel()
+
+	select {
+	case err = <-errCh:
+		require.NoError(t, err)
+	case <-ctx.Done():
+		t.Fatal("timeout", log.String())
+	}
+
+	require.Contains(t, log.String(), "removed containers=2 networks=2 volumes=2 images=2")
+}
+
+func TestReconnect(t *testing.T) {
+	// Stale client which only answers the startup ping.
+	stale := &mockClient{}
+	stale.On("NegotiateAPIVersion", mockContext).Return()
+	stale.On("ClientVersion").Return("1.47")
+	stale.On("Ping", mockContext).Return(types.Ping{}, nil).Once()
+	stale.On("Ping", mockContext).Return(types.Ping{}, errors.New("connection refused"))
+	stale.On("Close").Return(errors.New("close error")).Once()
+
+	var created atomic.Int64
+	fresh := newMockClient(newRunTest())
+	newClient := withNewClient(func() (dockerClient, error) {
+		created.Add(1)
+		return fresh, nil
+	})
+
+	cfg := testConfigBase
+	cfg.PingInterval = time.Millisecond * 10
+	log, err := testReaperRun(t, newRunTest(), withConfig(cfg), withClient(stale), newClient)
+	require.NoError(t, err)
+	require.Contains(t, log, `msg="reconnecting docker client" error="ping: connection refused"`)
+	require.Contains(t, log, `msg="docker client reconnected"`)
+	require.Contains(t, log, "removed containers=2 networks=2 volumes=2 images=2")
+	require.Equal(t, int64(1), created.Load())
+	stale.AssertNotCalled(t, "ContainerList", mock.Anything, mock.Anything)
+	stale.AssertExpectations(t)
+	require.Contains(t, log, `level=WARN msg="docker client close" error="close error"`)
+}
+
+func TestNewDockerClient(t *testing.T) {
+	t.Setenv("DOCKER_HOST", "tcp://env:2375")
+	t.Setenv("DOCKER_CERT_PATH", "")
+
+	t.Run("env", func(t *testing.T) {
+		cfg := testConfigBase
+		r := &reaper{cfg: &cfg}
+		cli, err := r.newDockerClient()
+		require.NoError(t, err)
+		require.IsType(t, &client.Client{}, cli)
+		require.Equal(t, "tcp://env:2375", cli.(*client.Client).DaemonHost()) //nolint:forcetypeassert // Checked above.
+	})
+
+	t.Run("tls", func(t *testing.T) {
+		// Daemon which only accepts clients presenting a certificate.
+		certFile, keyFile, _ := testCertificate(t)
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		require.NoError(t, err)
+
+		var clientCerts atomic.Int64
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			clientCerts.Store(int64(len(req.TLS.PeerCertificates)))
+			w.Header().Set("Api-Version", "1.45")
+			_, _ = w.Write([]byte("OK"))
+		}))
+		srv.TLS = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientAuth:   tls.RequireAnyClientCert,
+			MinVersion:   tls.VersionTLS12,
+		}
+		srv.StartTLS()
+		t.Cleanup(srv.Close)
+
+		cfg := testConfigBase
+		cfg.DockerHost = "tcp://" + srv.Listener.Addr().String()
+		cfg.DockerTLSCert = certFile
+		cfg.DockerTLSKey = keyFile
+		cfg.DockerTLSCA = certFile
+		r := &reaper{cfg: &cfg}
+		cli, err := r.newDockerClient()
+		require.NoError(t, err)
+
+		_, err = cli.Ping(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, int64(1), clientCerts.Load())
+	})
+
+	t.Run("invalid-cert", func(t *testing.T) {
+		cfg := testConfigBase
+		cfg.DockerTLSCert = filepath.Join(t.TempDir(), "missing.pem")
+		cfg.DockerTLSKey = cfg.DockerTLSCert
+
+		r := &reaper{cfg: &cfg}
+		_, err := r.newDockerClient()
+		require.Error(t, err)
+	})
+}
+
+func TestPruneBuildkit(t *testing.T) {
+	t.Run("pruned", func(t *testing.T) {
+		tc := newRunTest()
+		tc.labels1 = withSessionID(testLabels1)
+		tc.labels2 = withSessionID(testLabels2)
+		bk := &mockBuildkitClient{}
+		for _, labels := range []map[string]string{tc.labels1, tc.labels2} {
+			filter := []bkclient.PruneOption{bkclient.WithFilter([]string{"description~=" + labels[sessionIDLabel]})}
+			bk.On("Prune", mockContext, mock.Anything, filter).Run(func(args mock.Arguments) {
+				ch := args.Get(1).(chan bkclient.UsageInfo)
+				ch <- bkclient.UsageInfo{ID: "record-" + labels[sessionIDLabel], Size: 100}
+			}).Return(nil).Once()
+		}
+		bk.On("Close").Return(nil).Once()
+
+		log, err := testReaperRun(t, tc, withBuildkitClient(bk))
+		require.NoError(t, err)
+		require.NotContains(t, log, "level=WARN")
+		require.Contains(t, log, `msg="buildkit pruned" records=2 size=200`)
+		bk.AssertExpectations(t)
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		tc := newRunTest()
+		tc.labels1 = withSessionID(testLabels1)
+		tc.labels2 = withSessionID(testLabels2)
+		bk := &mockBuildkitClient{}
+		bk.On("Prune", mockContext, mock.Anything, mock.Anything).Return(errors.New("connection refused"))
+		bk.On("Close").Return(errors.New("close error")).Once()
+
+		log, err := testReaperRun(t, tc, withBuildkitClient(bk))
+		require.NoError(t, err)
+		require.Contains(t, log, `level=WARN msg="buildkit close" error="close error"`)
+		require.Contains(t, log, `level=WARN msg="buildkit prune"`)
+		require.Contains(t, log, `msg="buildkit pruned" records=0 size=0`)
+	})
+}
+
+func TestPruneBuildCache(t *testing.T) {
+	newTestReaper := func(t *testing.T, cli *mockClient) (*reaper, *safeBuffer) {
+		t.Helper()
+
+		var log safeBuffer
+		cfg := testConfigBase
+		cfg.PruneBuildCache = true
+		return &reaper{
+			cfg:    &cfg,
+			client: cli,
+			logger: slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{Level: slog.LevelDebug})),
+		}, &log
+	}
+
+	t.Run("pruned", func(t *testing.T) {
+		before := time.Now().Add(testConfigBase.RetryOffset).Unix()
+		cli := &mockClient{}
+		cli.On("BuildCachePrune", mockContext, mock.MatchedBy(func(opts types.BuildCachePruneOptions) bool {
+			until, err := strconv.ParseInt(opts.Filters.Get("until")[0], 10, 64)
+			return err == nil && until >= before && opts.Filters.Len() == 1 && !opts.All
+		})).Return(&types.BuildCachePruneReport{CachesDeleted: []string{"cache1", "cache2"}, SpaceReclaimed: 1024}, nil).Once()
+
+		r, log := newTestReaper(t, cli)
+		require.NoError(t, r.prune(context.Background(), &resources{}))
+		cli.AssertExpectations(t)
+		require.Contains(t, log.String(), `msg="build cache record pruned" id=cache1`)
+		require.Contains(t, log.String(), "removed containers=0 networks=0 volumes=0 images=0 build_cache_bytes=1024")
+	})
+
+	t.Run("error", func(t *testing.T) {
+		cli := &mockClient{}
+		cli.On("BuildCachePrune", mockContext, mock.Anything).Return((*types.BuildCachePruneReport)(nil), errors.New("not supported"))
+
+		r, log := newTestReaper(t, cli)
+		require.EqualError(t, r.prune(context.Background(), &resources{}), "build cache prune: not supported")
+		require.Contains(t, log.String(), "removed containers=0 networks=0 volumes=0 images=0 build_cache_bytes=0")
+	})
+
+	t.Run("dry-run", func(t *testing.T) {
+		cli := &mockClient{}
+		r, _ := newTestReaper(t, cli)
+		r.cfg.DryRun = true
+		require.NoError(t, r.prune(context.Background(), &resources{}))
+		cli.AssertNotCalled(t, "BuildCachePrune", mock.Anything, mock.Anything)
+	})
+}
+
+func TestRemoveCount(t *testing.T) {
+	const (
+		workers   = 8
+		resources = 500
+	)
+
+	cfg := testConfigBase
+	cfg.RemoveRetries = 1
+	r := &reaper{
+		cfg:    &cfg,
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	// Every third resource is not found, every fifth fails
+	// and the rest are removed.
+	var expected, failed int64
+	ids := make([]string, resources)
+	for i := range ids {
+		ids[i] = strconv.Itoa(i)
+		switch {
+		case i%3 == 0:
+		case i%5 == 0:
+			failed++
+		default:
+			expected++
+		}
+	}
+
+	// Errors are collected and asserted once the workers have finished,
+	// as require must be called from the test goroutine.
+	var (
+		parseErrs []error
+		parseMtx  sync.Mutex
+	)
+	fn := func(_ context.Context, id string) error {
+		i, err := strconv.Atoi(id)
+		if err != nil {
+			parseMtx.Lock()
+			defer parseMtx.Unlock()
+			parseErrs = append(parseErrs, err)
+			return err
+		}
+
+		switch {
+		case i%3 == 0:
+			return errNotFound
+		case i%5 == 0:
+			return errors.New("remove error")
+		default:
+			return nil
+		}
+	}
+
+	// Share a single count between concurrent removals.
+	var count atomic.Int64
+	var wg sync.WaitGroup
+	errs := make([]error, workers)
+	for worker := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[worker] = r.remove(context.Background(), "worker"+strconv.Itoa(worker), ids, &count, fn)
+		}()
+	}
+	wg.Wait()
+
+	require.Empty(t, parseErrs)
+	for worker, err := range errs {
+		require.EqualError(t, err, fmt.Sprintf("worker%d left %d items", worker, failed))
+	}
+	require.Equal(t, expected*workers, count.Load())
+}
+
+func TestRemoveConcurrency(t *testing.T) {
+	const (
+		concurrency = 4
+		resources   = 200
+	)
+
+	cfg := testConfigBase
+	cfg.RemoveRetries = 2
+	cfg.RemoveConcurrency = concurrency
+	r := &reaper{
+		cfg:    &cfg,
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	// Every third resource is not found, every fifth fails on its
+	// first attempt and the rest are removed first time.
+	var expected int64
+	ids := make([]string, resources)
+	for i := range ids {
+		ids[i] = strconv.Itoa(i)
+		if i%3 != 0 {
+			expected++
+		}
+	}
+
+	var mtx sync.Mutex
+	var active, peak int
+	var parseErrs []error
+	failed := make(map[string]bool)
+	removed := make(map[string]int)
+	fn := func(_ context.Context, id string) error {
+		mtx.Lock()
+		active++
+		peak = max(peak, active)
+		mtx.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mtx.Lock()
+		defer mtx.Unlock()
+		active--
+
+		// Collected rather than required, fn runs on the removal goroutines.
+		i, err := strconv.Atoi(id)
+		if err != nil {
+			parseErrs = append(parseErrs, err)
+			return err
+		}
+
+		switch {
+		case i%3 == 0:
+			return errNotFound
+		case i%5 == 0 && !failed[id]:
+			failed[id] = true
+			return errors.New("remove error")
+		}
+
+		removed[id]++
+		return nil
+	}
+
+	var count atomic.Int64
+	require.NoError(t, r.remove(context.Background(), "container", ids, &count, fn))
+	require.Empty(t, parseErrs)
+	require.Equal(t, expected, count.Load())
+	require.Len(t, removed, int(expected))
+	for id, n := range removed {
+		require.Equal(t, 1, n, id)
+	}
+	require.NotEmpty(t, failed)
+	require.LessOrEqual(t, peak, concurrency)
+	require.Greater(t, peak, 1)
+}
+
+func TestRemoveBackoff(t *testing.T) {
+	var delays []time.Duration
+	orig := sleepContext
+	t.Cleanup(func() { sleepContext = orig })
+	sleepContext = func(_ context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+
+	cfg := testConfigBase
+	cfg.RemoveRetries = 5
+	cfg.RemoveBackoff = time.Second
+	r := &reaper{
+		cfg:    &cfg,
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	var count atomic.Int64
+	err := r.remove(context.Background(), "container", []string{containerID1}, &count, func(context.Context, string) error {
+		return errors.New("remove error")
+	})
+	require.EqualError(t, err, "container left 1 items")
+
+	// No delay after the last attempt, and each delay is the doubled
+	// backoff less up to half of it in jitter.
+	require.Len(t, delays, cfg.RemoveRetries-1)
+	for i, delay := range delays {
+		backoff := cfg.RemoveBackoff << i
+		require.LessOrEqual(t, delay, backoff, i)
+		require.GreaterOrEqual(t, delay, backoff/2, i)
+	}
+
+	t.Run("max", func(t *testing.T) {
+		require.LessOrEqual(t, r.removeBackoff(100), maxRemoveBackoff)
+		require.GreaterOrEqual(t, r.removeBackoff(100), maxRemoveBackoff/2)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		cfg.RemoveBackoff = 0
+		require.Zero(t, r.removeBackoff(3))
+	})
+}
+
+func TestRemoveCancelled(t *testing.T) {
+	var log safeBuffer
+	cfg := testConfigBase
+	cfg.RemoveRetries = 10
+	cfg.RemoveBackoff = time.Minute
+	r := &reaper{
+		cfg:    &cfg,
+		logger: slog.New(slog.NewTextHandler(&log, nil)),
+	}
+
+	// Cancelled during the backoff after the first attempt.
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	var attempts, count atomic.Int64
+	start := time.Now()
+	err := r.remove(ctx, "container", []string{containerID1, containerID2}, &count, func(context.Context, string) error {
+		if attempts.Add(1) == 2 {
+			time.AfterFunc(time.Millisecond*50, cancel)
+		}
+		return errors.New("remove error")
+	})
+	require.Less(t, time.Since(start), time.Second*5)
+	require.Equal(t, int64(2), attempts.Load())
+
+	var rerr *removeError
+	require.ErrorAs(t, err, &rerr)
+	require.Len(t, rerr.left, 2)
+	require.Contains(t, log.String(), `level=WARN msg="remove aborted" resource=container left=2 error="context canceled"`)
+}
+
+func TestRemoveChunks(t *testing.T) {
+	const resources = 7
+
+	// removed is the number of resources removed at each pause.
+	var removed atomic.Int64
+	var pauses []int64
+	orig := sleepContext
+	t.Cleanup(func() { sleepContext = orig })
+	sleepContext = func(_ context.Context, d time.Duration) error {
+		require.Equal(t, time.Second, d)
+		pauses = append(pauses, removed.Load())
+		return nil
+	}
+
+	cfg := testConfigBase
+	cfg.RemoveConcurrency = 2
+	cfg.RemoveChunkSize = 3
+	cfg.RemoveChunkPause = time.Second
+	r := &reaper{
+		cfg:    &cfg,
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	ids := make([]string, resources)
+	for i := range ids {
+		ids[i] = strconv.Itoa(i)
+	}
+
+	var count atomic.Int64
+	require.NoError(t, r.remove(context.Background(), "container", ids, &count, func(context.Context, string) error {
+		time.Sleep(time.Millisecond)
+		removed.Add(1)
+		return nil
+	}))
+	require.Equal(t, int64(resources), count.Load())
+
+	// Each chunk completes before the pause and no pause after the last.
+	require.Equal(t, []int64{3, 6}, pauses)
+
+	// The rest are abandoned if the pause is cancelled.
+	sleepContext = func(context.Context, time.Duration) error {
+		return context.Canceled
+	}
+	err := r.remove(context.Background(), "container", ids, &count, func(context.Context, string) error {
+		return nil
+	})
+	var rerr *removeError
+	require.ErrorAs(t, err, &rerr)
+	require.Len(t, rerr.left, resources-3)
+}
+
+func TestRemoveInProgress(t *testing.T) {
+	errInProgress := errdefs.Conflict(errors.New("removal of container " + containerID1 + " is already in progress"))
+
+	// newTestReaper returns a reaper which waits up to wait for
+	// removals already in progress.
+	newTestReaper := func(wait time.Duration) *reaper {
+		cfg := testConfigBase
+		cfg.RemovalInProgressWait = wait
+		return &reaper{
+			cfg:    &cfg,
+			logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		}
+	}
+
+	t.Run("gone", func(t *testing.T) {
+		cli := &mockClient{}
+		cli.On("ContainerRemove", mockContext, containerID1, containerRemoveOptions).Return(errInProgress).Twice()
+		cli.On("ContainerRemove", mockContext, containerID1, containerRemoveOptions).Return(errNotFound).Once()
+
+		r := newTestReaper(time.Second)
+		var count atomic.Int64
+		err := r.remove(context.Background(), "container", []string{containerID1}, &count, func(ctx context.Context, id string) error {
+			return cli.ContainerRemove(ctx, id, containerRemoveOptions)
+		})
+		require.NoError(t, err)
+		require.Equal(t, int64(1), count.Load())
+		cli.AssertExpectations(t)
+	})
+
+	t.Run("still-in-progress", func(t *testing.T) {
+		r := newTestReaper(removalPollInterval * 3)
+		var count atomic.Int64
+		err := r.remove(context.Background(), "container", []string{containerID1}, &count, func(context.Context, string) error {
+			return errInProgress
+		})
+		require.NoError(t, err)
+		require.Zero(t, count.Load())
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		r := newTestReaper(0)
+		var count atomic.Int64
+		err := r.remove(context.Background(), "container", []string{containerID1}, &count, func(context.Context, string) error {
+			return errInProgress
+		})
+		require.EqualError(t, err, "container left 1 items")
+		require.Zero(t, count.Load())
 	})
 }
 
@@ -933,3 +2955,124 @@ func testReaper(ctx context.Context, t *testing.T, expect ...string) {
 		t.Fatal("timeout", log.String())
 	}
 }
+
+func TestImageChildrenRemoved(t *testing.T) {
+	const (
+		parent = "sha256:parent"
+		child  = "sha256:child"
+		other  = "sha256:other"
+	)
+
+	cli := &mockClient{}
+	cli.On("ImageRemove", mockContext, parent, imageRemoveOptions).Return([]image.DeleteResponse{
+		{Untagged: "parent:latest"},
+		{Deleted: parent},
+		{Deleted: child},
+	}, nil).Once()
+	cli.On("ImageRemove", mockContext, other, imageRemoveOptions).Return([]image.DeleteResponse{
+		{Deleted: other},
+	}, nil).Once()
+
+	var log safeBuffer
+	cfg := testConfigBase
+	r := &reaper{
+		cfg:    &cfg,
+		client: cli,
+		logger: slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	}
+
+	require.NoError(t, r.prune(context.Background(), &resources{images: []string{parent, child, other}}))
+	cli.AssertExpectations(t)
+	cli.AssertNotCalled(t, "ImageRemove", mockContext, child, imageRemoveOptions)
+
+	data := log.String()
+	require.Contains(t, data, `msg="already removed" resource=image id=sha256:child`)
+	require.Contains(t, data, "removed containers=0 networks=0 volumes=0 images=2")
+}
+
+func TestImageUntagged(t *testing.T) {
+	const (
+		shared  = "sha256:shared"
+		deleted = "sha256:deleted"
+	)
+
+	newTestReaper := func(t *testing.T, countUntagged bool) (*reaper, *safeBuffer) {
+		t.Helper()
+
+		cli := &mockClient{}
+		cli.On("ImageRemove", mockContext, shared, imageRemoveOptions).Return([]image.DeleteResponse{
+			{Untagged: "test:shared"},
+		}, nil).Once()
+		cli.On("ImageRemove", mockContext, deleted, imageRemoveOptions).Return([]image.DeleteResponse{
+			{Untagged: "test:deleted"},
+			{Deleted: deleted},
+		}, nil).Once()
+
+		var log safeBuffer
+		cfg := testConfigBase
+		cfg.CountUntaggedImages = countUntagged
+		return &reaper{
+			cfg:    &cfg,
+			client: cli,
+			logger: slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{Level: slog.LevelDebug})),
+		}, &log
+	}
+
+	t.Run("counted", func(t *testing.T) {
+		r, log := newTestReaper(t, true)
+		require.NoError(t, r.prune(context.Background(), &resources{images: []string{shared, deleted}}))
+
+		data := log.String()
+		require.Contains(t, data, `msg="image untagged" id=sha256:shared untagged=[test:shared]`)
+		require.Contains(t, data, `msg="image deleted" id=sha256:deleted deleted=1 untagged=[test:deleted]`)
+		require.Contains(t, data, "removed containers=0 networks=0 volumes=0 images=2")
+	})
+
+	t.Run("not-counted", func(t *testing.T) {
+		r, log := newTestReaper(t, false)
+		require.NoError(t, r.prune(context.Background(), &resources{images: []string{shared, deleted}}))
+
+		data := log.String()
+		require.Contains(t, data, `msg="image untagged" id=sha256:shared untagged=[test:shared]`)
+		require.Contains(t, data, "removed containers=0 networks=0 volumes=0 images=1")
+		require.Contains(t, data, "resource=image matched=2 removed=1 skipped=1 failed=0")
+		require.NotContains(t, data, "level=ERROR")
+	})
+}
+
+func TestSummaryGroup(t *testing.T) {
+	ctx := context.Background()
+	since := time.Now()
+	created := since.Add(-time.Minute).Unix()
+	args := filterArgs(testLabels1)
+	cli := newListMockClient(map[*filters.Args][]types.Container{
+		&args: {
+			{ID: "a1", Created: created, Labels: map[string]string{"project": "a"}},
+			{ID: "a2", Created: created, Labels: map[string]string{"project": "a"}},
+			{ID: "b1", Created: created, Labels: map[string]string{"project": "b"}},
+			{ID: "none", Created: created},
+		},
+	})
+	for _, id := range []string{"a1", "a2", "none"} {
+		cli.On("ContainerRemove", mockContext, id, containerRemoveOptions).Return(nil)
+	}
+	cli.On("ContainerRemove", mockContext, "b1", containerRemoveOptions).Return(errors.New("remove error"))
+
+	var log safeBuffer
+	cfg := testConfigBase
+	cfg.RemoveRetries = 1
+	cfg.SummaryGroupLabel = "project"
+	r, err := newReaper(ctx, withLogger(slog.New(slog.NewTextHandler(&log, nil))), withConfig(cfg), withClient(cli))
+	require.NoError(t, err)
+	t.Cleanup(func() { r.listener.Close() })
+	require.NoError(t, r.addFilter("client1", filterKey(args)))
+
+	res, err := r.resources(since)
+	require.NoError(t, err)
+	require.Error(t, r.prune(context.Background(), res))
+
+	data := log.String()
+	require.Contains(t, data, `msg="removed group" label=project group="" containers=1 networks=0 volumes=0 images=0`)
+	require.Contains(t, data, `msg="removed group" label=project group=a containers=2 networks=0 volumes=0 images=0`)
+	require.NotContains(t, data, "group=b")
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+
+	"github.com/docker/docker/client"
+)
+
+// listReconnect calls list with the current Docker client. If it fails with
+// a connection error, such as when the daemon restarted, the client is
+// recreated and list retried up to the configured number of times.
+func listReconnect[T any](ctx context.Context, r *reaper, list func(cli dockerClient) (T, error)) (T, error) {
+	ret, err := list(r.docker())
+	for attempt := 1; attempt <= r.cfg.ReconnectRetries && client.IsErrConnectionFailed(err) && r.newClient != nil; attempt++ {
+		r.logger.Warn("list connection failed, reconnecting", fieldError, err, "attempt", attempt)
+		if attempt > 1 {
+			if werr := sleepContext(ctx, reconnectInterval); werr != nil {
+				break
+			}
+		}
+
+		if rerr := r.reconnect(ctx); rerr != nil {
+			r.logger.Error("docker reconnect", fieldError, rerr, "attempt", attempt)
+			continue
+		}
+
+		r.logger.Info("docker client reconnected")
+		ret, err = list(r.docker())
+	}
+
+	return ret, err
+}
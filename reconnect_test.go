@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListReconnect(t *testing.T) {
+	var delays []time.Duration
+	orig := sleepContext
+	t.Cleanup(func() { sleepContext = orig })
+	sleepContext = func(_ context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+
+	since := time.Now()
+	args := filterArgs(testLabels1)
+	options := container.ListOptions{All: true, Filters: args}
+
+	// newTestReaper returns a reaper whose client fails to connect and
+	// whose new clients fail to ping the given number of times first.
+	newTestReaper := func(t *testing.T, pingFailures int) (*reaper, *atomic.Int64, *safeBuffer) {
+		t.Helper()
+
+		delays = nil
+		stale := &mockClient{}
+		stale.On("ContainerList", mockContext, options).
+			Return([]types.Container(nil), client.ErrorConnectionFailed("unix:///var/run/docker.sock"))
+		stale.On("Close").Return(nil)
+
+		fresh := &mockClient{}
+		fresh.On("NegotiateAPIVersion", mockContext).Return()
+		fresh.On("ClientVersion").Return("1.47")
+		if pingFailures > 0 {
+			fresh.On("Ping", mockContext).Return(types.Ping{}, errors.New("connection refused")).Times(pingFailures)
+			fresh.On("Close").Return(nil).Times(pingFailures)
+		}
+		fresh.On("Ping", mockContext).Return(types.Ping{}, nil)
+		fresh.On("ContainerList", mockContext, options).
+			Return([]types.Container{{ID: containerID1, Created: since.Add(-time.Minute).Unix()}}, nil)
+
+		var created atomic.Int64
+		var log safeBuffer
+		cfg := testConfigBase
+		cfg.ReconnectRetries = 3
+		return &reaper{
+			cfg:    &cfg,
+			client: stale,
+			newClient: func() (dockerClient, error) {
+				created.Add(1)
+				return fresh, nil
+			},
+			logger: slog.New(slog.NewTextHandler(&log, nil)),
+		}, &created, &log
+	}
+
+	t.Run("recovered", func(t *testing.T) {
+		r, created, log := newTestReaper(t, 1)
+		stale := r.client.(*mockClient)
+
+		ids, _, err := r.affectedContainers(context.Background(), since, args, nil, nil)
+		require.NoError(t, err)
+		require.Equal(t, []string{containerID1}, ids)
+		require.Equal(t, int64(2), created.Load())
+		require.Equal(t, []time.Duration{reconnectInterval}, delays)
+		require.Contains(t, log.String(), `level=WARN msg="list connection failed, reconnecting"`)
+		require.Contains(t, log.String(), `msg="docker client reconnected"`)
+
+		// The replaced client and the one which failed to ping are closed.
+		stale.AssertNumberOfCalls(t, "Close", 1)
+		r.client.(*mockClient).AssertNumberOfCalls(t, "Close", 1)
+	})
+
+	t.Run("exhausted", func(t *testing.T) {
+		r, created, _ := newTestReaper(t, 3)
+
+		_, _, err := r.affectedContainers(context.Background(), since, args, nil, nil)
+		require.True(t, client.IsErrConnectionFailed(err))
+		require.Equal(t, int64(3), created.Load())
+		r.client.(*mockClient).AssertNotCalled(t, "Close")
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		r, created, _ := newTestReaper(t, 0)
+		r.cfg.ReconnectRetries = 0
+
+		_, _, err := r.affectedContainers(context.Background(), since, args, nil, nil)
+		require.True(t, client.IsErrConnectionFailed(err))
+		require.Zero(t, created.Load())
+	})
+}
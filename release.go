@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// waitRelease waits until the release file, if configured, no longer
+// exists so an external process can control when resources are removed.
+// The wait is bounded by the shutdown timeout, after which the prune
+// proceeds regardless, and is abandoned if ctx is done.
+func (r *reaper) waitRelease(ctx context.Context) {
+	if r.cfg.ReleaseFile == "" || !r.releaseFileExists() {
+		return
+	}
+
+	r.logger.Info("waiting for release file", "file", r.cfg.ReleaseFile)
+
+	ticker := time.NewTicker(r.cfg.ReleasePollInterval)
+	defer ticker.Stop()
+
+	timeout := time.NewTimer(r.cfg.ShutdownTimeout)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			r.logger.Warn("release file wait abandoned", "file", r.cfg.ReleaseFile, fieldError, ctx.Err())
+			return
+		case <-timeout.C:
+			r.logger.Warn("release file still present after shutdown timeout, pruning", "file", r.cfg.ReleaseFile)
+			return
+		case <-ticker.C:
+			if !r.releaseFileExists() {
+				r.logger.Info("release file removed", "file", r.cfg.ReleaseFile)
+				return
+			}
+		}
+	}
+}
+
+// releaseFileExists returns true if the release file exists.
+func (r *reaper) releaseFileExists() bool {
+	_, err := os.Stat(r.cfg.ReleaseFile)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		// Treat unexpected errors as absent so pruning isn't blocked.
+		r.logger.Error("release file", "file", r.cfg.ReleaseFile, fieldError, err)
+	}
+
+	return err == nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReleaseFile(t *testing.T) {
+	t.Run("removed", func(t *testing.T) {
+		cfg := testConfigBase
+		cfg.ReleaseFile = filepath.Join(t.TempDir(), "release")
+		cfg.ReleasePollInterval = time.Millisecond * 10
+		require.NoError(t, os.WriteFile(cfg.ReleaseFile, nil, 0o600))
+
+		// Clients disconnect after 500ms so the prune is held until the
+		// file is removed.
+		timer := time.AfterFunc(time.Second, func() {
+			os.Remove(cfg.ReleaseFile)
+		})
+		t.Cleanup(func() { timer.Stop() })
+
+		log, err := testReaperRun(t, newRunTest(), withConfig(cfg))
+		require.NoError(t, err)
+
+		waiting := strings.Index(log, `msg="waiting for release file"`)
+		released := strings.Index(log, `msg="release file removed"`)
+		removed := strings.Index(log, "removed containers=2 networks=2 volumes=2 images=2")
+		require.NotEqual(t, -1, waiting)
+		require.Greater(t, released, waiting)
+		require.Greater(t, removed, released)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		cfg := testConfigBase
+		cfg.ReleaseFile = filepath.Join(t.TempDir(), "release")
+		cfg.ReleasePollInterval = time.Millisecond * 10
+		cfg.ShutdownTimeout = time.Millisecond * 200
+		require.NoError(t, os.WriteFile(cfg.ReleaseFile, nil, 0o600))
+
+		log, err := testReaperRun(t, newRunTest(), withConfig(cfg))
+		require.NoError(t, err)
+		require.Contains(t, log, `msg="release file still present after shutdown timeout, pruning"`)
+		require.Contains(t, log, "removed containers=2 networks=2 volumes=2 images=2")
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		cfg := testConfigBase
+		cfg.ReleaseFile = filepath.Join(t.TempDir(), "release")
+		cfg.ReleasePollInterval = time.Millisecond * 10
+		cfg.ShutdownTimeout = time.Minute
+		require.NoError(t, os.WriteFile(cfg.ReleaseFile, nil, 0o600))
+
+		var log safeBuffer
+		logger := withLogger(slog.New(slog.NewTextHandler(&log, nil)))
+		r, err := newReaper(context.Background(), logger, withClient(newMockClient(newRunTest())), withConfig(cfg))
+		require.NoError(t, err)
+		t.Cleanup(func() { r.listener.Close() })
+
+		// The wait is abandoned once ctx is done, such as when shutdown is forced.
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		start := time.Now()
+		r.waitRelease(ctx)
+		require.Less(t, time.Since(start), time.Second)
+		require.Contains(t, log.String(), `msg="release file wait abandoned"`)
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		cfg := testConfigBase
+		cfg.ReleaseFile = filepath.Join(t.TempDir(), "release")
+		cfg.ReleasePollInterval = time.Millisecond * 10
+
+		log, err := testReaperRun(t, newRunTest(), withConfig(cfg))
+		require.NoError(t, err)
+		require.NotContains(t, log, "release file")
+		require.Contains(t, log, "removed containers=2 networks=2 volumes=2 images=2")
+	})
+}
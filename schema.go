@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// errFilterNotAllowed is returned by addFilter if a filter is rejected
+// by the configured filter schema.
+var errFilterNotAllowed = errors.New("filter not allowed")
+
+// filterSchema restricts the filters clients can register.
+type filterSchema struct {
+	// Types are the allowed filter types, for example label.
+	// If empty all types are allowed.
+	Types []string `json:"types"`
+
+	// Labels are the allowed label keys of label filters.
+	// If empty all label keys are allowed.
+	Labels []string `json:"labels"`
+}
+
+// loadFilterSchema loads the filter schema if configured.
+func (r *reaper) loadFilterSchema() error {
+	if r.cfg.FilterSchema == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(r.cfg.FilterSchema)
+	if err != nil {
+		return fmt.Errorf("read: %w", err)
+	}
+
+	var schema filterSchema
+	if err = json.Unmarshal(data, &schema); err != nil {
+		return fmt.Errorf("unmarshal: %w", err)
+	}
+
+	r.filterSchema = &schema
+
+	return nil
+}
+
+// validate returns an error wrapping errFilterNotAllowed if query uses
+// a filter type or label key which isn't allowed by the schema.
+func (s *filterSchema) validate(query map[string][]string) error {
+	if s == nil {
+		return nil
+	}
+
+	for filterType, values := range query {
+		if len(s.Types) > 0 && !slices.Contains(s.Types, filterType) {
+			return fmt.Errorf("type %q: %w", filterType, errFilterNotAllowed)
+		}
+
+		if filterType != "label" || len(s.Labels) == 0 {
+			continue
+		}
+
+		for _, value := range values {
+			key, _, _ := strings.Cut(value, "=")
+			if !slices.Contains(s.Labels, key) {
+				return fmt.Errorf("label %q: %w", key, errFilterNotAllowed)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateRequiredLabels returns an error wrapping errFilterNotAllowed if
+// query doesn't have a label filter for each of the required label keys,
+// so filters too broad to identify a session are rejected.
+func validateRequiredLabels(query map[string][]string, required []string) error {
+	for _, key := range required {
+		if !slices.ContainsFunc(query["label"], func(value string) bool {
+			labelKey, _, _ := strings.Cut(value, "=")
+			return labelKey == key
+		}) {
+			return fmt.Errorf("required label %q missing: %w", key, errFilterNotAllowed)
+		}
+	}
+
+	return nil
+}
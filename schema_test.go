@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterSchema(t *testing.T) {
+	schema := &filterSchema{
+		Types:  []string{"label", "name"},
+		Labels: []string{sessionIDLabel, labelBase},
+	}
+
+	tests := map[string]struct {
+		query   map[string][]string
+		allowed bool
+	}{
+		"label":           {query: map[string][]string{"label": {sessionIDLabel + "=1234"}}, allowed: true},
+		"label-key-only":  {query: map[string][]string{"label": {labelBase}}, allowed: true},
+		"name":            {query: map[string][]string{"name": {"test"}}, allowed: true},
+		"type-not-listed": {query: map[string][]string{"id": {"1234"}}},
+		"label-not-listed": {query: map[string][]string{
+			"label": {sessionIDLabel + "=1234", "other=true"},
+		}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := schema.validate(tc.query)
+			if tc.allowed {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, errFilterNotAllowed)
+		})
+	}
+
+	t.Run("nil", func(t *testing.T) {
+		var schema *filterSchema
+		require.NoError(t, schema.validate(map[string][]string{"id": {"1234"}}))
+	})
+}
+
+func TestFilterSchemaNACK(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "schema.json")
+	require.NoError(t, os.WriteFile(file, []byte(`{"types":["label"]}`), 0o600))
+
+	cfg := testConfigBase
+	cfg.FilterSchema = file
+	tc := newRunTest()
+	tc.connect = func(ctx context.Context, t *testing.T, addr string) {
+		t.Helper()
+		require.Equal(t, "NACK\n", testSend(ctx, t, addr, "id=1234"))
+	}
+
+	log, err := testReaperRun(t, tc, withConfig(cfg))
+	require.NoError(t, err)
+	require.Contains(t, log, `error="type \"id\": filter not allowed"`)
+	require.Contains(t, log, "removed containers=2 networks=2 volumes=2 images=2")
+
+	t.Run("invalid", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(file, []byte(`{"types":`), 0o600))
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		t.Cleanup(cancel)
+		_, err := newReaper(ctx, discardLogger, withConfig(cfg), withClient(newMockClient(newRunTest())))
+		var serr *json.SyntaxError
+		require.ErrorAs(t, err, &serr)
+	})
+}
+
+func TestRequiredFilterLabels(t *testing.T) {
+	required := []string{sessionIDLabel, labelBase}
+
+	tests := map[string]struct {
+		query   map[string][]string
+		allowed bool
+	}{
+		"all":            {query: map[string][]string{"label": {sessionIDLabel + "=1234", labelBase + "=true"}}, allowed: true},
+		"key-only":       {query: map[string][]string{"label": {sessionIDLabel, labelBase}}, allowed: true},
+		"missing-one":    {query: map[string][]string{"label": {sessionIDLabel + "=1234"}}},
+		"missing-all":    {query: map[string][]string{"label": {"color=blue"}}},
+		"value-matching": {query: map[string][]string{"label": {"other=" + sessionIDLabel, labelBase}}},
+		"other-type":     {query: map[string][]string{"name": {sessionIDLabel}, "label": {labelBase}}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := validateRequiredLabels(tc.query, required)
+			if tc.allowed {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, errFilterNotAllowed)
+		})
+	}
+
+	t.Run("none", func(t *testing.T) {
+		require.NoError(t, validateRequiredLabels(map[string][]string{"label": {"color=blue"}}, nil))
+	})
+
+	t.Run("nack", func(t *testing.T) {
+		cfg := testConfigBase
+		cfg.RequiredFilterLabels = []string{labelBase + ".sessionID"}
+		tc := newRunTest()
+		tc.connect = func(ctx context.Context, t *testing.T, addr string) {
+			t.Helper()
+			require.Equal(t, "NACK\n", testSend(ctx, t, addr, "label=color=blue"))
+		}
+
+		// The rejected filter isn't stored, so only the resources
+		// of the filters with the required label are removed.
+		log, err := testReaperRun(t, tc, withConfig(cfg))
+		require.NoError(t, err)
+		require.Contains(t, log, `error="required label \"`+labelBase+`.sessionID\" missing: filter not allowed"`)
+		require.Contains(t, log, "removed containers=2 networks=2 volumes=2 images=2")
+	})
+}
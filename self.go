@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+)
+
+const (
+	// composeProjectLabel is the label which identifies the compose project
+	// a container belongs to.
+	composeProjectLabel = "com.docker.compose.project"
+
+	// composeServiceLabel is the label which identifies the compose service
+	// a container belongs to.
+	composeServiceLabel = "com.docker.compose.service"
+)
+
+// hostname returns the hostname, which Docker sets to the short
+// container ID by default.
+var hostname = os.Hostname //nolint:gochecknoglobals // Replaced by tests.
+
+// removeSelf removes the container the reaper is running in, identified by
+// its hostname. It must be the last action as on success the process is
+// terminated, so nothing is logged after the removal request.
+func (r *reaper) removeSelf() {
+	id, err := r.selfID()
+	if err != nil {
+		r.logger.Error("self remove", fieldError, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.cfg.RequestTimeout)
+	defer cancel()
+
+	r.logger.Info("removing self", "id", id)
+	// The reaper's own anonymous volumes hold no test data, so are always removed.
+	if err = r.docker().ContainerRemove(ctx, id, container.RemoveOptions{RemoveVolumes: true, Force: true}); err != nil {
+		r.logger.Error("self remove", fieldError, err)
+	}
+}
+
+// selfID returns the ID of the container the reaper is running in.
+func (r *reaper) selfID() (string, error) {
+	self, err := r.selfContainer()
+	if err != nil {
+		return "", err
+	}
+
+	return self.ID, nil
+}
+
+// selfContainer returns the container the reaper is running in.
+func (r *reaper) selfContainer() (*types.Container, error) {
+	name, err := hostname()
+	if err != nil {
+		return nil, fmt.Errorf("hostname: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.cfg.RequestTimeout)
+	defer cancel()
+
+	containers, err := r.docker().ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("id", name)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("container list: %w", err)
+	}
+
+	// The id filter matches prefixes, so require a single match to
+	// avoid removing another container.
+	if len(containers) != 1 {
+		return nil, fmt.Errorf("hostname %q matched %d containers", name, len(containers))
+	}
+
+	return &containers[0], nil
+}
+
+// excludeSelf removes the reaper's own container, and the networks and
+// volumes it uses, from res. This prevents the reaper severing its own
+// connection when it runs inside the compose project it's cleaning, which
+// is left to remove them, for example with compose down.
+// If the reaper's own container can't be identified res is cleared, so
+// nothing is removed rather than risk removing the reaper's resources.
+func (r *reaper) excludeSelf(res *resources) error {
+	self, err := r.selfContainer()
+	if err != nil {
+		*res = resources{}
+		return err
+	}
+
+	ids := map[string]struct{}{self.ID: {}}
+	if self.NetworkSettings != nil {
+		for _, endpoint := range self.NetworkSettings.Networks {
+			if endpoint != nil && endpoint.NetworkID != "" {
+				ids[endpoint.NetworkID] = struct{}{}
+			}
+		}
+	}
+
+	for _, m := range self.Mounts {
+		if m.Type == mount.TypeVolume && m.Name != "" {
+			ids[m.Name] = struct{}{}
+		}
+	}
+
+	r.logger.Debug("protecting self",
+		"id", self.ID,
+		"project", self.Labels[composeProjectLabel],
+		"service", self.Labels[composeServiceLabel],
+	)
+	r.exclude(res, ids, "skipping reaper's own resource")
+
+	return nil
+}
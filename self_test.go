@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelfRemove(t *testing.T) {
+	const (
+		name   = "ryukself"
+		selfID = name + "0123456789"
+	)
+
+	orig := hostname
+	t.Cleanup(func() { hostname = orig })
+	hostname = func() (string, error) { return name, nil }
+
+	selfList := container.ListOptions{All: true, Filters: filters.NewArgs(filters.Arg("id", name))}
+	cfg := testConfigBase
+	cfg.SelfRemove = true
+
+	t.Run("removed-last", func(t *testing.T) {
+		tc := newRunTest()
+		cli := newMockClient(tc)
+		cli.On("ContainerList", mockContext, selfList).Return([]types.Container{{ID: selfID}}, nil)
+		cli.On("ContainerRemove", mockContext, selfID, containerRemoveOptions).Return(nil).Once()
+
+		log, err := testReaperRun(t, tc, withConfig(cfg), withClient(cli))
+		require.NoError(t, err)
+		cli.AssertExpectations(t)
+
+		// Self removal must be after the prune and everything else.
+		removed := strings.Index(log, "removed containers=2")
+		done := strings.Index(log, "msg=done")
+		self := strings.Index(log, `msg="removing self" id=`+selfID)
+		require.NotEqual(t, -1, removed)
+		require.Greater(t, done, removed)
+		require.Greater(t, self, done)
+		require.NotContains(t, log, `msg="self remove"`)
+	})
+
+	t.Run("ambiguous", func(t *testing.T) {
+		tc := newRunTest()
+		cli := newMockClient(tc)
+		cli.On("ContainerList", mockContext, selfList).Return([]types.Container{{ID: selfID}, {ID: name + "other"}}, nil)
+
+		log, err := testReaperRun(t, tc, withConfig(cfg), withClient(cli))
+		require.NoError(t, err)
+		require.Contains(t, log, `msg="self remove" error="hostname \"ryukself\" matched 2 containers"`)
+		require.NotContains(t, log, "removing self")
+	})
+
+	t.Run("hostname-error", func(t *testing.T) {
+		hostname = func() (string, error) { return "", errors.New("no hostname") }
+
+		log, err := testReaperRun(t, newRunTest(), withConfig(cfg))
+		require.NoError(t, err)
+		require.Contains(t, log, `msg="self remove" error="hostname: no hostname"`)
+	})
+}
+
+func TestExcludeSelf(t *testing.T) {
+	const name = "ryukself"
+
+	orig := hostname
+	t.Cleanup(func() { hostname = orig })
+	hostname = func() (string, error) { return name, nil }
+
+	self := types.Container{
+		ID:     name + "0123456789",
+		Labels: map[string]string{composeProjectLabel: "tests", composeServiceLabel: "ryuk"},
+		NetworkSettings: &types.SummaryNetworkSettings{
+			Networks: map[string]*network.EndpointSettings{
+				"tests_default": {NetworkID: "selfnet"},
+			},
+		},
+		Mounts: []types.MountPoint{
+			{Type: mount.TypeVolume, Name: "selfvol"},
+			{Type: mount.TypeBind, Source: "/var/run/docker.sock"},
+		},
+	}
+
+	cli := &mockClient{}
+	cli.On("ContainerList", mockContext, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("id", name)),
+	}).Return([]types.Container{self}, nil)
+
+	var log bytes.Buffer
+	cfg := testConfigBase
+	r := &reaper{
+		cfg:    &cfg,
+		client: cli,
+		logger: slog.New(slog.NewTextHandler(&log, nil)),
+	}
+
+	res := &resources{
+		containers: []string{self.ID, containerID1},
+		networks:   []string{"selfnet", networkID1},
+		volumes:    []string{"selfvol", volumeName1},
+		images:     []string{imageID1},
+		sessions: []*resources{{
+			containers: []string{self.ID},
+			networks:   []string{"selfnet", networkID1},
+		}},
+	}
+	require.NoError(t, r.excludeSelf(res))
+	require.Equal(t, []string{containerID1}, res.containers)
+	require.Equal(t, []string{networkID1}, res.networks)
+	require.Equal(t, []string{volumeName1}, res.volumes)
+	require.Equal(t, []string{imageID1}, res.images)
+	require.Empty(t, res.sessions[0].containers)
+	require.Equal(t, []string{networkID1}, res.sessions[0].networks)
+	require.Contains(t, log.String(), `msg="skipping reaper's own resource" resource=container id=`+self.ID)
+	require.Contains(t, log.String(), `msg="skipping reaper's own resource" resource=network id=selfnet`)
+	require.Contains(t, log.String(), `msg="skipping reaper's own resource" resource=volume id=selfvol`)
+
+	t.Run("not-found", func(t *testing.T) {
+		cli := &mockClient{}
+		cli.On("ContainerList", mockContext, mock.Anything).Return([]types.Container{}, nil)
+		r.client = cli
+
+		// Nothing is removed if the reaper can't identify itself.
+		res := &resources{containers: []string{containerID1}, networks: []string{networkID1}}
+		require.EqualError(t, r.excludeSelf(res), `hostname "ryukself" matched 0 containers`)
+		require.Equal(t, &resources{}, res)
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/docker/docker/api/types/filters"
+)
+
+// sessionIDsKey is the context key of the session ID of each resource
+// being removed.
+type sessionIDsKey struct{}
+
+// sessionID returns the value of the session ID label filter of args,
+// or "" if it has none.
+func (r *reaper) sessionID(args filters.Args) string {
+	label := r.cfg.sessionIDLabel()
+	for _, value := range args.Get("label") {
+		if key, id, ok := strings.Cut(value, "="); ok && key == label && id != "" {
+			return id
+		}
+	}
+
+	return ""
+}
+
+// recordSessionIDs records id as the session ID of the resources of res,
+// if it's not empty.
+func recordSessionIDs(res *resources, id string) {
+	if id == "" {
+		return
+	}
+
+	if res.sessionIDs == nil {
+		res.sessionIDs = make(map[string]string)
+	}
+
+	for _, ids := range [][]string{res.containers, res.networks, res.volumes, res.images} {
+		for _, resourceID := range ids {
+			res.sessionIDs[resourceID] = id
+		}
+	}
+}
+
+// withSessionIDs returns ctx with the session ID of each resource,
+// so removals can be attributed to the session which caused them.
+func withSessionIDs(ctx context.Context, sessionIDs map[string]string) context.Context {
+	if len(sessionIDs) == 0 {
+		return ctx
+	}
+
+	return context.WithValue(ctx, sessionIDsKey{}, sessionIDs)
+}
+
+// sessionLogger returns logger with the session ID of resource id in
+// ctx, if any.
+func sessionLogger(ctx context.Context, logger *slog.Logger, id string) *slog.Logger {
+	sessionIDs, _ := ctx.Value(sessionIDsKey{}).(map[string]string)
+	if session, ok := sessionIDs[id]; ok {
+		return logger.With("session", session)
+	}
+
+	return logger
+}
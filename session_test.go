@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/filters"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionID(t *testing.T) {
+	r := &reaper{cfg: &testConfigBase}
+
+	require.Equal(t, testLabels1[sessionIDLabel], r.sessionID(filterArgs(testLabels1)))
+	require.Empty(t, r.sessionID(filterArgs(map[string]string{labelBase: "true"})))
+	require.Empty(t, r.sessionID(filters.NewArgs(filters.Arg("label", sessionIDLabel))))
+}
+
+func TestSessionLog(t *testing.T) {
+	tc := newRunTest()
+	tc.containerCreated2 = time.Now().Add(time.Millisecond * 200)
+	tc.labels1 = withSessionID(testLabels1)
+	tc.labels2 = withSessionID(testLabels2)
+	log, err := testReaperRun(t, tc)
+	require.NoError(t, err)
+
+	session1 := tc.labels1[sessionIDLabel]
+	session2 := tc.labels2[sessionIDLabel]
+	require.Contains(t, log, `msg="change detected, waiting again" error="affected containers: container container2: changes detected" session=[`+session2+`]`)
+	require.Contains(t, log, "msg=remove resource=container id="+containerID1+" attempt=1 session="+session1)
+	require.Contains(t, log, "msg=remove resource=container id="+containerID2+" attempt=1 session="+session2)
+	require.Contains(t, log, "msg=remove resource=image id="+imageID2+" attempt=1 session="+session2)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"time"
+)
+
+// sessionTTLAddr is the client address prunes of expired filters are logged with.
+const sessionTTLAddr = "session ttl"
+
+// expiredFilters returns the keys of the filters registered more than
+// the session TTL before now, marking them so each is only returned once.
+// Safe to call concurrently.
+func (r *reaper) expiredFilters(now time.Time) []string {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	var keys []string
+	for key, entry := range r.filters {
+		if entry.expired || now.Sub(entry.added) < r.cfg.SessionTTL {
+			continue
+		}
+
+		entry.expired = true
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// checkSessionTTL prunes the filters which have exceeded the session TTL,
+// even if their clients are still connected, so a client which hangs
+// can't keep its resources forever.
+func (r *reaper) checkSessionTTL(now time.Time) {
+	keys := r.expiredFilters(now)
+	if len(keys) == 0 {
+		return
+	}
+
+	r.logger.Warn("session ttl expired, forcing prune", "filters", len(keys), "session_ttl", r.cfg.SessionTTL)
+	r.pruneClient(pruneRequest{addr: sessionTTLAddr, keys: keys, expired: true})
+}
+
+// removeFilters removes the filters with keys, whichever clients registered them.
+// Safe to call concurrently.
+func (r *reaper) removeFilters(keys []string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	for _, key := range keys {
+		delete(r.filters, key)
+	}
+}
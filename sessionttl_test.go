@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionTTL(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	t.Cleanup(cancel)
+
+	var log safeBuffer
+	logger := withLogger(slog.New(slog.NewTextHandler(&log, nil)))
+	cli := newMockClient(newRunTest())
+	cfg := testConfigBase
+	cfg.ReconnectionTimeout = time.Minute
+	cfg.SessionTTL = time.Millisecond * 200
+	r, err := newReaper(ctx, logger, withClient(cli), withConfig(cfg))
+	require.NoError(t, err)
+
+	runCtx, runCancel := context.WithCancel(ctx)
+	t.Cleanup(runCancel)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- r.run(runCtx)
+	}()
+
+	// The client stays connected, but hangs.
+	clientCtx, clientCancel := context.WithCancel(ctx)
+	t.Cleanup(clientCancel)
+	start := time.Now()
+	testConnect(clientCtx, t, r.listener.Addr().String(), testLabels1)
+
+	require.Eventually(t, func() bool {
+		return strings.Contains(log.String(), "client prune completed")
+	}, time.Second*2, time.Millisecond*10, log.String())
+	require.GreaterOrEqual(t, time.Since(start), cfg.SessionTTL)
+	require.Contains(t, log.String(), `level=WARN msg="session ttl expired, forcing prune" filters=1 session_ttl=200ms`)
+	require.Contains(t, log.String(), "containers=1 networks=1 volumes=1 images=1")
+	cli.AssertCalled(t, "ContainerRemove", mockContext, containerID1, containerRemoveOptions)
+	require.Empty(t, r.filterArgs())
+
+	clientCancel()
+	require.Eventually(t, func() bool {
+		return strings.Contains(log.String(), "clients=0")
+	}, time.Second, time.Millisecond*10, log.String())
+	runCancel()
+	select {
+	case err = <-errCh:
+		require.NoError(t, err)
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	t.Run("expired-once", func(t *testing.T) {
+		r := &reaper{cfg: &cfg, filters: map[string]*filterEntry{
+			"old": {added: start},
+			"new": {added: start.Add(time.Second)},
+		}}
+		require.Equal(t, []string{"old"}, r.expiredFilters(start.Add(cfg.SessionTTL)))
+		require.Empty(t, r.expiredFilters(start.Add(cfg.SessionTTL)))
+		require.Equal(t, []string{"new"}, r.expiredFilters(start.Add(time.Second+cfg.SessionTTL)))
+	})
+}
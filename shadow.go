@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// withShadowClient returns a reaperOption that sets the Docker client of
+// the shadow daemon.
+// Default: A docker client for the configured shadow host, if any.
+func withShadowClient(client dockerClient) reaperOption {
+	return func(r *reaper) error {
+		r.shadow = client
+		return nil
+	}
+}
+
+// newShadowClient returns a new Docker client for the configured shadow
+// host, with the remaining settings taken from the environment.
+func (r *reaper) newShadowClient() (dockerClient, error) {
+	return client.NewClientWithOpts(client.FromEnv, client.WithHost(r.cfg.ShadowDockerHost)) //nolint:wrapcheck // Wrapped by caller.
+}
+
+// compareShadow lists the resources matching the filters of res on the shadow
+// daemon and logs any differences from res. Nothing is removed from the shadow
+// daemon, so it validates the reaper's behaviour against another daemon.
+func (r *reaper) compareShadow(res *resources) {
+	if r.shadow == nil || res == nil {
+		return
+	}
+
+	shadow := &reaper{
+		cfg:    r.cfg,
+		client: r.shadow,
+		logger: r.logger.With("daemon", "shadow"),
+	}
+
+	since := time.Now().Add(r.cfg.RetryOffset)
+	var found resources
+	for _, args := range res.filters {
+		ctx, cancel := context.WithTimeout(context.Background(), r.cfg.RequestTimeout)
+		listed, err := shadow.affectedResources(ctx, since, args)
+		cancel()
+		if err != nil {
+			// Best effort, compare what was listed.
+			shadow.logger.Warn("shadow resources", fieldError, err)
+		}
+
+		found.containers = append(found.containers, listed.containers...)
+		found.networks = append(found.networks, listed.networks...)
+		found.volumes = append(found.volumes, listed.volumes...)
+		found.images = append(found.images, listed.images...)
+	}
+
+	matched := true
+	for _, diff := range []struct {
+		resourceType     string
+		primary, shadows []string
+	}{
+		{"container", res.containers, found.containers},
+		{"network", res.networks, found.networks},
+		{"volume", res.volumes, found.volumes},
+		{"image", res.images, found.images},
+	} {
+		onlyPrimary, onlyShadow := difference(diff.primary, diff.shadows)
+		if len(onlyPrimary) == 0 && len(onlyShadow) == 0 {
+			continue
+		}
+
+		matched = false
+		r.logger.Warn("shadow discrepancy",
+			"resource", diff.resourceType,
+			"only_primary", onlyPrimary,
+			"only_shadow", onlyShadow,
+		)
+	}
+
+	if matched {
+		r.logger.Info("shadow matched")
+	}
+}
+
+// difference returns the sorted unique IDs which are only in a and only in b.
+func difference(a, b []string) (onlyA, onlyB []string) {
+	only := func(from, other []string) []string {
+		var ids []string
+		for _, id := range from {
+			if !slices.Contains(other, id) && !slices.Contains(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+		slices.Sort(ids)
+
+		return ids
+	}
+
+	return only(a, b), only(b, a)
+}
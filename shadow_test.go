@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShadow(t *testing.T) {
+	t.Run("matched", func(t *testing.T) {
+		shadow := newMockClient(newRunTest())
+		log, err := testReaperRun(t, newRunTest(), withShadowClient(shadow))
+		require.NoError(t, err)
+		require.Contains(t, log, `msg="shadow matched"`)
+		require.NotContains(t, log, "shadow discrepancy")
+		require.Contains(t, log, "removed containers=2 networks=2 volumes=2 images=2")
+		shadow.AssertNotCalled(t, "ContainerRemove", mock.Anything, mock.Anything, mock.Anything)
+		shadow.AssertNotCalled(t, "NetworkRemove", mock.Anything, mock.Anything)
+		shadow.AssertNotCalled(t, "VolumeRemove", mock.Anything, mock.Anything, mock.Anything)
+		shadow.AssertNotCalled(t, "ImageRemove", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("discrepancy", func(t *testing.T) {
+		created := time.Now().Add(-time.Minute).Unix()
+		filters1 := filterArgs(testLabels1)
+		filters2 := filterArgs(testLabels2)
+		shadow := newListMockClient(map[*filters.Args][]types.Container{
+			&filters1: {{ID: containerID1, Created: created}},
+			&filters2: {{ID: "extra", Created: created}},
+		})
+
+		log, err := testReaperRun(t, newRunTest(), withShadowClient(shadow))
+		require.NoError(t, err)
+		require.Contains(t, log, `msg="shadow discrepancy" resource=container only_primary=[container2] only_shadow=[extra]`)
+		require.Contains(t, log, `msg="shadow discrepancy" resource=network only_primary="[network1 network2]" only_shadow=[]`)
+		require.NotContains(t, log, "shadow matched")
+
+		// The primary daemon is still pruned as normal.
+		require.Contains(t, log, "removed containers=2 networks=2 volumes=2 images=2")
+	})
+}
+
+func Test_difference(t *testing.T) {
+	onlyA, onlyB := difference([]string{"c", "a", "b", "a"}, []string{"b", "d"})
+	require.Equal(t, []string{"a", "c"}, onlyA)
+	require.Equal(t, []string{"d"}, onlyB)
+}
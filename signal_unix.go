@@ -0,0 +1,35 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyManualPrune forwards SIGUSR2 to trigger until stop is called.
+func notifyManualPrune(trigger chan<- struct{}) (stop func()) {
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(signals, syscall.SIGUSR2)
+	go func() {
+		for {
+			select {
+			case <-signals:
+				select {
+				case trigger <- struct{}{}:
+				default:
+					// Already triggered.
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
@@ -0,0 +1,64 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestManualPrune(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	t.Cleanup(cancel)
+
+	var log safeBuffer
+	logger := withLogger(slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+	})))
+	cli := newMockClient(newRunTest())
+	cfg := testConfigBase
+	cfg.ManualPrune = true
+	r, err := newReaper(ctx, logger, withClient(cli), withConfig(cfg))
+	require.NoError(t, err)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- r.run(ctx)
+	}()
+
+	clientCtx, clientCancel := context.WithCancel(ctx)
+	t.Cleanup(clientCancel)
+	testConnect(clientCtx, t, r.listener.Addr().String(), testLabels1)
+
+	require.NoError(t, syscall.Kill(syscall.Getpid(), syscall.SIGUSR2))
+	require.Eventually(t, func() bool {
+		return strings.Contains(log.String(), "manual prune completed")
+	}, time.Second, time.Millisecond*10)
+
+	data := log.String()
+	require.Contains(t, data, "manual prune started")
+	require.Contains(t, data, "manual prune completed")
+	require.Contains(t, data, "removed containers=1 networks=1 volumes=1 images=1")
+
+	// The reaper keeps running until the client disconnects.
+	select {
+	case err = <-errCh:
+		t.Fatal("reaper stopped after manual prune", err, log.String())
+	default:
+	}
+
+	clientCancel()
+	select {
+	case err = <-errCh:
+		require.NoError(t, err)
+	case <-ctx.Done():
+		t.Fatal("timeout", log.String())
+	}
+	require.Contains(t, log.String(), "done")
+}
@@ -0,0 +1,8 @@
+//go:build windows
+
+package main
+
+// notifyManualPrune is a no-op as SIGUSR2 is not supported on Windows.
+func notifyManualPrune(chan<- struct{}) (stop func()) {
+	return func() {}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/docker/docker/api/types"
+)
+
+// newSizes returns a map to record the size of resources in,
+// or nil if size reporting is not enabled.
+func (r *reaper) newSizes() map[string]int64 {
+	if !r.cfg.ReportSizes {
+		return nil
+	}
+
+	return make(map[string]int64)
+}
+
+// recordSize records the size in bytes of the resource id in sizes, if size
+// reporting is enabled. Negative sizes, which the daemon uses when the size
+// wasn't calculated, are ignored.
+func recordSize(sizes map[string]int64, id string, size int64) {
+	if sizes == nil || size < 0 {
+		return
+	}
+
+	sizes[id] = size
+}
+
+// volumeSizesKey is the context key of the volume sizes of a prune pass.
+type volumeSizesKey struct{}
+
+// passVolumeSizes are the volume sizes of a prune pass, requested on first use.
+type passVolumeSizes struct {
+	// ctx is the context of the prune pass, which bounds the request
+	// instead of the context of the filter listed first.
+	ctx context.Context //nolint:containedctx // Shared by the filters of the pass.
+
+	once  sync.Once
+	sizes map[string]int64
+}
+
+// withVolumeSizes returns ctx with the volume sizes of a prune pass, if size
+// reporting and volume pruning are enabled, so the daemon's disk usage is
+// only requested once however many filters are listed.
+func (r *reaper) withVolumeSizes(ctx context.Context) context.Context {
+	if !r.cfg.ReportSizes || !r.cfg.PruneVolumes {
+		return ctx
+	}
+
+	pass := &passVolumeSizes{}
+	ctx = context.WithValue(ctx, volumeSizesKey{}, pass)
+	pass.ctx = ctx
+
+	return ctx
+}
+
+// volumeSizesOnce returns the volume sizes of the prune pass of ctx,
+// requesting them on first use, or requests them if ctx has no prune pass.
+// Errors are logged as sizes are only reported.
+// Safe to call concurrently.
+func (r *reaper) volumeSizesOnce(ctx context.Context) map[string]int64 {
+	request := func(ctx context.Context) map[string]int64 {
+		sizes, err := r.volumeSizes(ctx)
+		if err != nil {
+			r.logger.Error("volume sizes", fieldError, err)
+		}
+
+		return sizes
+	}
+
+	pass, ok := ctx.Value(volumeSizesKey{}).(*passVolumeSizes)
+	if !ok {
+		return request(ctx)
+	}
+
+	pass.once.Do(func() {
+		ctx, cancel := context.WithTimeout(pass.ctx, r.cfg.resourceListTimeout("volume"))
+		defer cancel()
+
+		pass.sizes = request(ctx)
+	})
+
+	return pass.sizes
+}
+
+// volumeSizes returns the disk usage of volumes by name, which isn't
+// included when listing volumes.
+func (r *reaper) volumeSizes(ctx context.Context) (map[string]int64, error) {
+	usage, err := r.docker().DiskUsage(ctx, types.DiskUsageOptions{
+		Types: []types.DiskUsageObject{types.VolumeObject},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("disk usage: %w", err)
+	}
+
+	sizes := make(map[string]int64, len(usage.Volumes))
+	for _, volume := range usage.Volumes {
+		if volume.UsageData != nil {
+			recordSize(sizes, volume.Name, volume.UsageData.Size)
+		}
+	}
+
+	return sizes, nil
+}
+
+// trackSize returns a function which calls fn and adds the size of
+// successfully removed resources to reclaimed, if size reporting is enabled.
+// Safe to call concurrently.
+func trackSize(sizes map[string]int64, reclaimed *atomic.Int64, fn func(ctx context.Context, id string) error) func(ctx context.Context, id string) error {
+	if sizes == nil {
+		return fn
+	}
+
+	return func(ctx context.Context, id string) error {
+		if err := fn(ctx, id); err != nil {
+			return err
+		}
+
+		reclaimed.Add(sizes[id])
+
+		return nil
+	}
+}
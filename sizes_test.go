@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReportSizes(t *testing.T) {
+	since := time.Now()
+	created := since.Add(-time.Minute)
+	args := filterArgs(testLabels1)
+
+	cli := &mockClient{}
+	cli.On("ContainerList", mockContext, container.ListOptions{All: true, Size: true, Filters: args}).Return([]types.Container{
+		{ID: containerID1, Created: created.Unix(), SizeRw: 100},
+		{ID: containerID2, Created: created.Unix(), SizeRw: 50},
+	}, nil)
+	cli.On("ContainerRemove", mockContext, containerID1, containerRemoveOptions).Return(nil)
+	cli.On("ContainerRemove", mockContext, containerID2, containerRemoveOptions).Return(errors.New("in use"))
+	cli.On("NetworkList", mockContext, network.ListOptions{Filters: args}).Return([]network.Summary{}, nil)
+	cli.On("VolumeList", mockContext, volume.ListOptions{Filters: args}).Return(volume.ListResponse{
+		Volumes: []*volume.Volume{
+			{Name: volumeName1, CreatedAt: created.Format(time.RFC3339)},
+		},
+	}, nil)
+	cli.On("DiskUsage", mockContext, types.DiskUsageOptions{Types: []types.DiskUsageObject{types.VolumeObject}}).Return(types.DiskUsage{
+		Volumes: []*volume.Volume{
+			{Name: volumeName1, UsageData: &volume.UsageData{Size: 200}},
+			{Name: volumeName2, UsageData: &volume.UsageData{Size: 400}},
+		},
+	}, nil)
+	cli.On("VolumeRemove", mockContext, volumeName1, volumeRemoveForce).Return(nil)
+	cli.On("ImageList", mockContext, image.ListOptions{Filters: args}).Return([]image.Summary{
+		{ID: imageID1, Created: created.Unix(), Size: 300},
+	}, nil)
+	cli.On("ImageRemove", mockContext, imageID1, imageRemoveOptions).
+		Return([]image.DeleteResponse{{Deleted: imageID1}}, nil)
+
+	var log safeBuffer
+	cfg := testConfigBase
+	cfg.ReportSizes = true
+	r := &reaper{
+		cfg:    &cfg,
+		client: cli,
+		logger: slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	}
+
+	res, err := r.affectedResources(context.Background(), since, args)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int64{
+		containerID1: 100,
+		containerID2: 50,
+		volumeName1:  200,
+		imageID1:     300,
+	}, res.sizes)
+
+	// The container which failed to be removed isn't counted.
+	require.Error(t, r.prune(context.Background(), res))
+	require.Contains(t, log.String(), "removed containers=1 networks=0 volumes=1 images=1 reclaimed_bytes.total=600"+
+		" reclaimed_bytes.containers=100 reclaimed_bytes.volumes=200 reclaimed_bytes.images=300")
+
+	t.Run("once-per-prune", func(t *testing.T) {
+		// Disk usage is requested once however many filters are listed.
+		args1, args2 := filterArgs(testLabels1), filterArgs(testLabels2)
+		cli := &mockClient{}
+		cli.On("ContainerList", mockContext, mock.Anything).Return([]types.Container{}, nil)
+		cli.On("NetworkList", mockContext, mock.Anything).Return([]network.Summary{}, nil)
+		cli.On("ImageList", mockContext, mock.Anything).Return([]image.Summary{}, nil)
+		for args, name := range map[*filters.Args]string{&args1: volumeName1, &args2: volumeName2} {
+			cli.On("VolumeList", mockContext, volume.ListOptions{Filters: *args}).Return(volume.ListResponse{
+				Volumes: []*volume.Volume{{Name: name, CreatedAt: created.Format(time.RFC3339)}},
+			}, nil)
+		}
+		cli.On("DiskUsage", mockContext, mock.Anything).Return(types.DiskUsage{
+			Volumes: []*volume.Volume{
+				{Name: volumeName1, UsageData: &volume.UsageData{Size: 200}},
+				{Name: volumeName2, UsageData: &volume.UsageData{Size: 400}},
+			},
+		}, nil).Once()
+
+		cfg := testConfigBase
+		cfg.ReportSizes = true
+		r := &reaper{
+			cfg:    &cfg,
+			client: cli,
+			logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		}
+
+		res, err := r.resourcesFor(since, []filters.Args{args1, args2}, nil)
+		require.NoError(t, err)
+		require.Equal(t, map[string]int64{volumeName1: 200, volumeName2: 400}, res.sizes)
+		cli.AssertNumberOfCalls(t, "DiskUsage", 1)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		cfg.ReportSizes = false
+		require.Nil(t, r.newSizes())
+		require.Equal(t, []any{
+			"containers", int64(0),
+			"networks", int64(0),
+			"volumes", int64(0),
+			"images", int64(0),
+		}, new(removeCounts).logAttrs(false))
+	})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"slices"
+
+	"github.com/docker/docker/api/types/filters"
+)
+
+// subsumes reports whether every resource matched by b is also matched
+// by a, so listing b as well as a is redundant.
+//
+// Label filters must all match, so a subsumes b if b has all of a's labels.
+// Other filter types, such as name, match any value, so to be conservative
+// they must have the same values.
+func subsumes(a, b filters.Args) bool {
+	for _, key := range a.Keys() {
+		if !b.Contains(key) {
+			return false
+		}
+
+		aValues, bValues := a.Get(key), b.Get(key)
+		if key == "label" {
+			for _, value := range aValues {
+				if !slices.Contains(bValues, value) {
+					return false
+				}
+			}
+			continue
+		}
+
+		slices.Sort(aValues)
+		slices.Sort(bValues)
+		if !slices.Equal(aValues, bValues) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// dropSubsumed returns args without those subsumed by another,
+// keeping the first of any which subsume each other.
+func dropSubsumed(args []filters.Args) []filters.Args {
+	kept := make([]filters.Args, 0, len(args))
+	for i, b := range args {
+		subsumed := false
+		for j, a := range args {
+			if j != i && subsumes(a, b) && (j < i || !subsumes(b, a)) {
+				subsumed = true
+				break
+			}
+		}
+
+		if !subsumed {
+			kept = append(kept, b)
+		}
+	}
+
+	return kept
+}
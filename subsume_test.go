@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubsumes(t *testing.T) {
+	session := filters.NewArgs(filters.Arg("label", "session=1"))
+	sessionColor := filters.NewArgs(filters.Arg("label", "session=1"), filters.Arg("label", "color=blue"))
+	otherSession := filters.NewArgs(filters.Arg("label", "session=2"))
+	name := filters.NewArgs(filters.Arg("label", "session=1"), filters.Arg("name", "a"))
+	names := filters.NewArgs(filters.Arg("label", "session=1"), filters.Arg("name", "a"), filters.Arg("name", "b"))
+
+	tests := map[string]struct {
+		a, b     filters.Args
+		expected bool
+	}{
+		"equal":              {a: session, b: session, expected: true},
+		"label-subset":       {a: session, b: sessionColor, expected: true},
+		"label-superset":     {a: sessionColor, b: session},
+		"different-label":    {a: session, b: otherSession},
+		"extra-type":         {a: session, b: name, expected: true},
+		"missing-type":       {a: name, b: session},
+		"different-values":   {a: name, b: names},
+		"different-values-2": {a: names, b: name},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.expected, subsumes(tc.a, tc.b))
+		})
+	}
+
+	t.Run("drop", func(t *testing.T) {
+		require.Equal(t,
+			[]filters.Args{session, otherSession},
+			dropSubsumed([]filters.Args{sessionColor, session, otherSession, name, session.Clone()}),
+		)
+		require.Empty(t, dropSubsumed(nil))
+	})
+}
+
+func TestResourcesSubsumedFilters(t *testing.T) {
+	since := time.Now()
+	created := since.Add(-time.Minute).Unix()
+	broad := filterArgs(testLabels1)
+	narrow := broad.Clone()
+	narrow.Add("label", "color=blue")
+
+	// Only the broad filter is listed, which includes the narrow one's resources.
+	cli := newListMockClient(map[*filters.Args][]types.Container{
+		&broad: {{ID: containerID1, Created: created}, {ID: containerID2, Created: created}},
+	})
+
+	var log safeBuffer
+	cfg := testConfigBase
+	r, err := newReaper(context.Background(), withConfig(cfg), withClient(cli), withLogger(slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{Level: slog.LevelDebug}))))
+	require.NoError(t, err)
+	t.Cleanup(func() { r.listener.Close() })
+
+	require.NoError(t, r.addFilter("client1", filterKey(narrow)))
+	require.NoError(t, r.addFilter("client2", filterKey(broad)))
+
+	res, err := r.resources(since)
+	require.NoError(t, err)
+	require.Equal(t, []string{containerID1, containerID2}, res.containers)
+	require.Equal(t, []filters.Args{broad}, res.filters)
+	cli.AssertNumberOfCalls(t, "ContainerList", 1)
+	require.Contains(t, log.String(), `msg="dropped subsumed filters" count=1`)
+}
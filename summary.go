@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// newGroups returns a map to record the summary group of resources in,
+// or nil if no summary group label is configured.
+func (r *reaper) newGroups() map[string]string {
+	if r.cfg.SummaryGroupLabel == "" {
+		return nil
+	}
+
+	return make(map[string]string)
+}
+
+// recordGroup records the summary group label value of the resource id
+// in groups, if grouping is enabled.
+func (r *reaper) recordGroup(groups map[string]string, id string, labels map[string]string) {
+	if groups == nil {
+		return
+	}
+
+	groups[id] = labels[r.cfg.SummaryGroupLabel]
+}
+
+// groupSummary tracks the number of resources removed per summary group.
+type groupSummary struct {
+	// groups is the summary group of each resource.
+	groups map[string]string
+
+	// counts is the number of resources removed by group and resource type.
+	counts map[string]map[string]int
+	mtx    sync.Mutex
+}
+
+// newGroupSummary returns a new groupSummary for the given resource groups,
+// or nil if grouping is not enabled.
+func newGroupSummary(groups map[string]string) *groupSummary {
+	if groups == nil {
+		return nil
+	}
+
+	return &groupSummary{
+		groups: groups,
+		counts: make(map[string]map[string]int),
+	}
+}
+
+// track returns a function which calls fn and records successful removals
+// of resourceType against the resource's group.
+// Safe to call concurrently.
+func (s *groupSummary) track(resourceType string, fn func(ctx context.Context, id string) error) func(ctx context.Context, id string) error {
+	if s == nil {
+		return fn
+	}
+
+	return func(ctx context.Context, id string) error {
+		if err := fn(ctx, id); err != nil {
+			return err
+		}
+
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+
+		group := s.groups[id]
+		if s.counts[group] == nil {
+			s.counts[group] = make(map[string]int)
+		}
+		s.counts[group][resourceType]++
+
+		return nil
+	}
+}
+
+// log logs the removed counts of each group, in group order.
+func (s *groupSummary) log(logger *slog.Logger, label string) {
+	if s == nil {
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for _, group := range slices.Sorted(maps.Keys(s.counts)) {
+		counts := s.counts[group]
+		logger.Info("removed group",
+			"label", label,
+			"group", group,
+			"containers", counts["container"],
+			"networks", counts["network"],
+			"volumes", counts["volume"],
+			"images", counts["image"],
+		)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// tlsRecordTypeHandshake is the first byte of a TLS handshake record,
+// which a TLS ClientHello always starts with.
+const tlsRecordTypeHandshake = 0x16
+
+// errTLSAutodetectNoCert is returned if TLS auto-detection is enabled
+// without a TLS certificate.
+var errTLSAutodetectNoCert = errors.New("tls autodetect requires tls cert and key files")
+
+// peekConn is a net.Conn whose reads are served from a buffered reader,
+// so bytes peeked from the connection are still read by its consumer.
+type peekConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// Read implements net.Conn.
+func (c *peekConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b) //nolint:wrapcheck // Transparent wrapper.
+}
+
+// loadTLSConfig loads the TLS configuration if a certificate is configured.
+func (r *reaper) loadTLSConfig() error {
+	if r.cfg.TLSCertFile == "" && r.cfg.TLSKeyFile == "" {
+		if r.cfg.TLSAutodetect {
+			return errTLSAutodetectNoCert
+		}
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.cfg.TLSCertFile, r.cfg.TLSKeyFile)
+	if err != nil {
+		return fmt.Errorf("load key pair: %w", err)
+	}
+
+	r.tlsConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	return nil
+}
+
+// serverConn returns conn wrapped in TLS if configured. If TLS
+// auto-detection is enabled the first byte is peeked to determine if
+// the client started a TLS handshake, otherwise conn is plaintext.
+func (r *reaper) serverConn(conn net.Conn) (net.Conn, error) {
+	if r.tlsConfig == nil {
+		return conn, nil
+	}
+
+	if !r.cfg.TLSAutodetect {
+		return r.handshake(tls.Server(conn, r.tlsConfig))
+	}
+
+	pc := &peekConn{Conn: conn, reader: bufio.NewReader(conn)}
+	first, err := pc.reader.Peek(1)
+	if err != nil {
+		return nil, fmt.Errorf("peek: %w", err)
+	}
+
+	if first[0] != tlsRecordTypeHandshake {
+		return pc, nil
+	}
+
+	return r.handshake(tls.Server(pc, r.tlsConfig))
+}
+
+// handshake completes the TLS handshake of conn within the connection
+// timeout, so clients which never complete it don't hold the connection.
+func (r *reaper) handshake(conn *tls.Conn) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.cfg.ConnectionTimeout)
+	defer cancel()
+
+	if err := conn.HandshakeContext(ctx); err != nil {
+		return nil, fmt.Errorf("tls handshake: %w", err)
+	}
+
+	return conn, nil
+}
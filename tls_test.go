@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testCertificate writes a self-signed certificate and key for localhost
+// to a temporary directory and returns their paths and a pool trusting it.
+func testCertificate(t *testing.T) (certFile, keyFile string, pool *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		DNSNames:     []string{"localhost"},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool = x509.NewCertPool()
+	pool.AddCert(cert)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+
+	return certFile, keyFile, pool
+}
+
+// testSendTLS connects to the given endpoint over TLS, sends line and returns
+// the response. The connection is closed when the context is done.
+func testSendTLS(ctx context.Context, t *testing.T, endpoint, line string, pool *x509.CertPool) string {
+	t.Helper()
+
+	d := tls.Dialer{Config: &tls.Config{RootCAs: pool, ServerName: "localhost", MinVersion: tls.VersionTLS12}}
+	conn, err := d.DialContext(ctx, "tcp", endpoint)
+	require.NoError(t, err)
+
+	go func() {
+		defer conn.Close()
+		<-ctx.Done()
+	}()
+
+	_, err = conn.Write([]byte(line + "\n"))
+	require.NoError(t, err)
+
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+
+	return string(buf[:n])
+}
+
+func TestTLSListener(t *testing.T) {
+	certFile, keyFile, pool := testCertificate(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	t.Cleanup(cancel)
+
+	var log safeBuffer
+	logger := withLogger(slog.New(slog.NewTextHandler(&log, nil)))
+	cfg := testConfigBase
+	cfg.TLSCertFile = certFile
+	cfg.TLSKeyFile = keyFile
+	r, err := newReaper(ctx, logger, withConfig(cfg), withClient(newMockClient(newRunTest())))
+	require.NoError(t, err)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- r.run(ctx)
+	}()
+
+	addr := r.listener.Addr().String()
+
+	// A client which never starts the handshake is disconnected
+	// after the connection timeout.
+	var d net.Dialer
+	stalled, err := d.DialContext(ctx, "tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { stalled.Close() })
+	require.NoError(t, stalled.SetReadDeadline(time.Now().Add(time.Second*2)))
+	_, err = stalled.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+	require.Contains(t, log.String(), `msg="server conn"`)
+	require.Contains(t, log.String(), "tls handshake: context deadline exceeded")
+
+	clientCtx, clientCancel := context.WithTimeout(ctx, time.Millisecond*500)
+	t.Cleanup(clientCancel)
+	require.Equal(t, "ACK\n", testSendTLS(clientCtx, t, addr, filterKey(filterArgs(testLabels1)), pool))
+
+	select {
+	case err = <-errCh:
+		require.NoError(t, err)
+	case <-ctx.Done():
+		t.Fatal("timeout", log.String())
+	}
+
+	require.Contains(t, log.String(), "removed containers=1 networks=1 volumes=1 images=1")
+}
+
+func TestTLSAutodetect(t *testing.T) {
+	certFile, keyFile, pool := testCertificate(t)
+
+	t.Run("mixed", func(t *testing.T) {
+		cfg := testConfigBase
+		cfg.TLSCertFile = certFile
+		cfg.TLSKeyFile = keyFile
+		cfg.TLSAutodetect = true
+
+		// Plaintext clients are connected by testReaperRun.
+		tc := newRunTest()
+		tc.connect = func(ctx context.Context, t *testing.T, addr string) {
+			t.Helper()
+			require.Equal(t, "ACK\n", testSendTLS(ctx, t, addr, filterKey(filterArgs(testLabels1)), pool))
+		}
+		log, err := testReaperRun(t, tc, withConfig(cfg))
+		require.NoError(t, err)
+		require.NotContains(t, log, "level=ERROR")
+		require.Contains(t, log, "removed containers=2 networks=2 volumes=2 images=2")
+	})
+
+	t.Run("no-cert", func(t *testing.T) {
+		cfg := testConfigBase
+		cfg.TLSAutodetect = true
+		_, err := newReaper(context.Background(), discardLogger, withConfig(cfg), withClient(newMockClient(newRunTest())))
+		require.ErrorIs(t, err, errTLSAutodetectNoCert)
+	})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const (
+	// tracerName is the name of the tracer spans are created with.
+	tracerName = "github.com/testcontainers/moby-ryuk"
+
+	// attrResourceType is the span attribute of the resource type.
+	attrResourceType = attribute.Key("ryuk.resource.type")
+
+	// attrCount is the span attribute of the number of resources
+	// to list or remove.
+	attrCount = attribute.Key("ryuk.count")
+
+	// attrRemoved is the span attribute of the number of resources removed.
+	attrRemoved = attribute.Key("ryuk.removed")
+
+	// attrClients is the span attribute of the number of distinct clients.
+	attrClients = attribute.Key("ryuk.clients")
+
+	// attrFilters is the span attribute of the number of filters.
+	attrFilters = attribute.Key("ryuk.filters")
+)
+
+// withSpanExporter returns a reaperOption that sets the exporter
+// spans are exported with.
+// Default: an OTLP HTTP exporter if an OTLP endpoint is configured,
+// otherwise tracing is disabled.
+func withSpanExporter(exporter sdktrace.SpanExporter) reaperOption {
+	return func(r *reaper) error {
+		r.spanExporter = exporter
+		return nil
+	}
+}
+
+// initTracing initialises the tracer provider, if tracing is enabled.
+func (r *reaper) initTracing(ctx context.Context) error {
+	if r.spanExporter == nil && r.cfg.OtelEndpoint != "" {
+		exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(r.cfg.OtelEndpoint))
+		if err != nil {
+			return fmt.Errorf("otlp exporter: %w", err)
+		}
+		r.spanExporter = exporter
+	}
+
+	if r.spanExporter == nil {
+		return nil
+	}
+
+	r.tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(r.spanExporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "ryuk"))),
+	)
+
+	return nil
+}
+
+// shutdownTracing exports any remaining spans and shuts down the tracer
+// provider, if tracing is enabled.
+func (r *reaper) shutdownTracing() {
+	if r.tracerProvider == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.cfg.RequestTimeout)
+	defer cancel()
+
+	if err := r.tracerProvider.Shutdown(ctx); err != nil {
+		r.logger.Error("tracing shutdown", fieldError, err)
+	}
+}
+
+// startSpan starts a span called name, as a child of any span in ctx,
+// with attrs. If tracing is disabled the span does nothing.
+func (r *reaper) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if r.tracerProvider == nil {
+		return ctx, noop.Span{}
+	}
+
+	return r.tracerProvider.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...)) //nolint:spancheck // Ended by caller.
+}
+
+// resourceCountAttrs returns the span attributes of the number of each
+// type of resources.
+func resourceCountAttrs(resources *resources) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.Int("ryuk.containers", len(resources.containers)),
+		attribute.Int("ryuk.networks", len(resources.networks)),
+		attribute.Int("ryuk.volumes", len(resources.volumes)),
+		attribute.Int("ryuk.images", len(resources.images)),
+	}
+}
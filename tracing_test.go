@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// keptExporter is an in memory exporter which keeps its spans on shutdown,
+// so they can be checked after the reaper has run.
+type keptExporter struct {
+	*tracetest.InMemoryExporter
+}
+
+// Shutdown implements sdktrace.SpanExporter.
+func (keptExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+func TestTracing(t *testing.T) {
+	exporter := keptExporter{tracetest.NewInMemoryExporter()}
+	_, err := testReaperRun(t, newRunTest(), withSpanExporter(exporter))
+	require.NoError(t, err)
+
+	// attrs are the attributes of each span by name and resource type.
+	attrs := make(map[string]map[attribute.Key]attribute.Value)
+	for _, span := range exporter.GetSpans() {
+		values := make(map[attribute.Key]attribute.Value, len(span.Attributes))
+		for _, attr := range span.Attributes {
+			values[attr.Key] = attr.Value
+		}
+		attrs[span.Name+values[attrResourceType].AsString()] = values
+	}
+
+	require.ElementsMatch(t, []string{
+		"pruneWait",
+		"resources",
+		"affectedContainerscontainer",
+		"affectedNetworksnetwork",
+		"affectedVolumesvolume",
+		"affectedImagesimage",
+		"prune",
+		"removecontainer",
+		"removenetwork",
+		"removevolume",
+		"removeimage",
+	}, slices.Collect(maps.Keys(attrs)))
+
+	require.Equal(t, int64(2), attrs["pruneWait"][attrClients].AsInt64())
+	require.Equal(t, int64(2), attrs["resources"][attrFilters].AsInt64())
+	require.Equal(t, int64(2), attrs["resources"]["ryuk.containers"].AsInt64())
+	require.Equal(t, int64(8), attrs["prune"][attrRemoved].AsInt64())
+	for _, resourceType := range []string{"container", "network", "volume", "image"} {
+		require.Equal(t, int64(2), attrs["remove"+resourceType][attrCount].AsInt64(), resourceType)
+		require.Equal(t, int64(2), attrs["remove"+resourceType][attrRemoved].AsInt64(), resourceType)
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		r := &reaper{cfg: &testConfigBase}
+		ctx, span := r.startSpan(context.Background(), "test")
+		require.Equal(t, context.Background(), ctx)
+		require.False(t, span.IsRecording())
+		span.End()
+	})
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/filters"
+)
+
+// removedIDs are the IDs of the resources removed of each type.
+type removedIDs struct {
+	ids map[string][]string
+	mtx sync.Mutex
+}
+
+// newRemovedIDs returns removed IDs to track removals in,
+// or nil if no webhook is configured.
+func (r *reaper) newRemovedIDs() *removedIDs {
+	if r.cfg.WebhookURL == "" {
+		return nil
+	}
+
+	return &removedIDs{ids: make(map[string][]string)}
+}
+
+// add records id as removed. Safe to call concurrently and on nil.
+func (s *removedIDs) add(resourceType, id string) {
+	if s == nil {
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.ids[resourceType] = append(s.ids[resourceType], id)
+}
+
+// get returns the IDs of the removed resources of resourceType.
+// Safe to call concurrently.
+func (s *removedIDs) get(resourceType string) []string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return append([]string{}, s.ids[resourceType]...)
+}
+
+// track returns a function which calls fn and records successfully
+// removed resources. Safe to call concurrently and on nil.
+func (s *removedIDs) track(resourceType string, fn func(ctx context.Context, id string) error) func(ctx context.Context, id string) error {
+	if s == nil {
+		return fn
+	}
+
+	return func(ctx context.Context, id string) error {
+		if err := fn(ctx, id); err != nil {
+			return err
+		}
+
+		s.add(resourceType, id)
+
+		return nil
+	}
+}
+
+// webhookPayload is the JSON payload posted to the webhook after a prune.
+type webhookPayload struct {
+	// Time is when the prune completed.
+	Time time.Time `json:"time"`
+
+	// The IDs, or names for volumes, of the resources removed.
+	Containers []string `json:"containers"`
+	Networks   []string `json:"networks"`
+	Volumes    []string `json:"volumes"`
+	Images     []string `json:"images"`
+
+	// SessionLabels are the label filters of the sessions pruned.
+	SessionLabels []string `json:"session_labels"`
+
+	// DryRun is set if nothing was actually removed.
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// notifyWebhook posts the resources removed by a prune of args to the
+// configured webhook, if any. Delivery is done in the background so it
+// can't block the prune, and failures are logged.
+func (r *reaper) notifyWebhook(args []filters.Args, counts *removeCounts) {
+	if counts.ids == nil {
+		return
+	}
+
+	payload := webhookPayload{
+		Time:          time.Now(),
+		Containers:    counts.ids.get("container"),
+		Networks:      counts.ids.get("network"),
+		Volumes:       counts.ids.get("volume"),
+		Images:        counts.ids.get("image"),
+		SessionLabels: []string{},
+		DryRun:        r.cfg.DryRun,
+	}
+	for _, arg := range args {
+		payload.SessionLabels = append(payload.SessionLabels, arg.Get("label")...)
+	}
+	slices.Sort(payload.SessionLabels)
+	payload.SessionLabels = slices.Compact(payload.SessionLabels)
+
+	r.webhooks.Add(1)
+	go func() {
+		defer r.webhooks.Done()
+
+		if err := r.postWebhook(payload); err != nil {
+			r.logger.Error("webhook", fieldError, err)
+		}
+	}()
+}
+
+// postWebhook posts payload to the webhook, bounded by the request timeout.
+func (r *reaper) postWebhook(payload webhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.cfg.RequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.cfg.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("post: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("post: unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhook(t *testing.T) {
+	type request struct {
+		method      string
+		contentType string
+		payload     map[string]any
+		err         error
+	}
+
+	var (
+		requests []request
+		mtx      sync.Mutex
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// Only record here, require must be called from the test goroutine.
+		recorded := request{
+			method:      req.Method,
+			contentType: req.Header.Get("Content-Type"),
+		}
+		recorded.err = json.NewDecoder(req.Body).Decode(&recorded.payload)
+
+		mtx.Lock()
+		defer mtx.Unlock()
+		requests = append(requests, recorded)
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := testConfigBase
+	cfg.WebhookURL = srv.URL
+	log, err := testReaperRun(t, newRunTest(), withConfig(cfg))
+	require.NoError(t, err)
+	require.NotContains(t, log, "level=ERROR")
+
+	// Delivery completes before the reaper exits.
+	mtx.Lock()
+	defer mtx.Unlock()
+	require.Len(t, requests, 1)
+	require.Equal(t, http.MethodPost, requests[0].method)
+	require.Equal(t, "application/json", requests[0].contentType)
+	require.NoError(t, requests[0].err)
+	payload := requests[0].payload
+
+	ts, ok := payload["time"].(string)
+	require.True(t, ok)
+	_, err = time.Parse(time.RFC3339Nano, ts)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []any{containerID1, containerID2}, payload["containers"])
+	require.ElementsMatch(t, []any{networkID1, networkID2}, payload["networks"])
+	require.ElementsMatch(t, []any{volumeName1, volumeName2}, payload["volumes"])
+	require.ElementsMatch(t, []any{imageID1, imageID2}, payload["images"])
+	require.NotContains(t, payload, "dry_run")
+
+	labels, ok := payload["session_labels"].([]any)
+	require.True(t, ok)
+	for _, args := range []map[string]string{testLabels1, testLabels2} {
+		for _, label := range filterArgs(args).Get("label") {
+			require.Contains(t, labels, label)
+		}
+	}
+
+	t.Run("failure", func(t *testing.T) {
+		failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		t.Cleanup(failing.Close)
+
+		cfg.WebhookURL = failing.URL
+		log, err := testReaperRun(t, newRunTest(), withConfig(cfg))
+		require.NoError(t, err)
+		require.Contains(t, log, `level=ERROR msg=webhook error="post: unexpected status 500 Internal Server Error"`)
+		require.Contains(t, log, "removed containers=2 networks=2 volumes=2 images=2")
+	})
+}